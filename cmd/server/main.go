@@ -31,19 +31,263 @@ func main() {
 				fmt.Printf("picfit %s\n", constants.Version)
 			},
 		},
+		{
+			Name:  "rotate-key",
+			Usage: "Re-wrap the data keys of the encrypted records with a new master key",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "new-key-file",
+					Usage: "File with the base64 encoded new master key",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				newKeyFile := c.String("new-key-file")
+				if newKeyFile == "" {
+					fmt.Fprintf(os.Stderr, "--new-key-file is required\n")
+					os.Exit(1)
+				}
+
+				rotated, err := server.RotateMasterKey(config, newKeyFile)
+				if err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("re-wrapped %d data keys, switch the config to the new master key\n", rotated)
+			},
+		},
+		{
+			Name:  "fsck",
+			Usage: "Verify the checksums of every record version and report the corrupt or missing chunks",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "quarantine",
+					Usage: "Stop serving the corrupt records and versions, they are kept in the database",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				report, err := server.Fsck(config, c.Bool("quarantine"))
+				if err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				for _, record := range report.Corrupt {
+					if record.Version != 0 {
+						fmt.Printf("%s version %d: %s\n", record.ID, record.Version, record.Problem)
+						continue
+					}
+					fmt.Printf("%s: %s\n", record.ID, record.Problem)
+				}
+				fmt.Printf("checked %d records, %d corrupt, %d quarantined\n", report.Checked, len(report.Corrupt), report.Quarantined)
+
+				if len(report.Corrupt) > 0 {
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Name:  "backup",
+			Usage: "Write a verified snapshot of the database, the server can keep running",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Snapshot file path, - writes to the standard output",
+				},
+				cli.BoolFlag{
+					Name:  "gzip",
+					Usage: "Compress the snapshot with gzip",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				output := c.String("output")
+				if output == "" {
+					fmt.Fprintf(os.Stderr, "--output is required\n")
+					os.Exit(1)
+				}
+
+				if err := server.Backup(config, output, c.Bool("gzip")); err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				if output != "-" {
+					fmt.Printf("snapshot written to %s\n", output)
+				}
+			},
+		},
+		{
+			Name:  "restore",
+			Usage: "Replace the database with a snapshot written by backup, the server must be stopped",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input, i",
+					Usage: "Snapshot file path, plain or gzip compressed",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Replace the existing database",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				input := c.String("input")
+				if input == "" {
+					fmt.Fprintf(os.Stderr, "--input is required\n")
+					os.Exit(1)
+				}
+
+				if err := server.Restore(config, input, c.Bool("force")); err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("database restored from %s\n", input)
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "Write the records with their metadata to a tar archive",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Archive file path, - writes to the standard output",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				output := c.String("output")
+				if output == "" {
+					fmt.Fprintf(os.Stderr, "--output is required\n")
+					os.Exit(1)
+				}
+
+				exported, err := server.Export(config, output)
+				if err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				fmt.Fprintf(os.Stderr, "exported %d records\n", exported)
+			},
+		},
+		{
+			Name:  "import",
+			Usage: "Insert the records from a tar archive written by export",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input, i",
+					Usage: "Archive file path, - reads the standard input",
+				},
+				cli.StringFlag{
+					Name:  "conflict",
+					Value: "skip",
+					Usage: "What to do with the records whose ID is taken: skip, overwrite or rename",
+				},
+			},
+			Action: func(c *cli.Context) {
+				config := configPath(c.GlobalString("config"))
+
+				input := c.String("input")
+				if input == "" {
+					fmt.Fprintf(os.Stderr, "--input is required\n")
+					os.Exit(1)
+				}
+
+				report, err := server.Import(config, input, c.String("conflict"))
+				for original, renamed := range report.Renamed {
+					fmt.Printf("%s: renamed to %s\n", original, renamed)
+				}
+				fmt.Printf("imported %d records, %d skipped, %d overwritten, %d renamed\n", report.Imported, report.Skipped, report.Overwritten, len(report.Renamed))
+
+				if err != nil {
+					fmt.Fprint(os.Stderr, err)
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "Show or change the version of the database schema",
+			Subcommands: []cli.Command{
+				{
+					Name:  "status",
+					Usage: "Show the applied and the pending migrations",
+					Action: func(c *cli.Context) {
+						config := configPath(c.GlobalString("config"))
+
+						status, err := server.MigrationStatus(config)
+						if err != nil {
+							fmt.Fprint(os.Stderr, err)
+							os.Exit(1)
+						}
+
+						fmt.Printf("database version %d, latest %d\n", status.Current, status.Latest())
+						for _, migration := range status.Migrations {
+							state := "pending"
+							if migration.Version <= status.Current {
+								state = "applied"
+							}
+							fmt.Printf("%03d-%s: %s\n", migration.Version, migration.Name, state)
+						}
+					},
+				},
+				{
+					Name:  "up",
+					Usage: "Apply the pending migrations",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "to",
+							Usage: "Version to migrate to, the latest one by default",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Check the migrations apply without changing the database",
+						},
+					},
+					Action: func(c *cli.Context) {
+						migrate(c, false)
+					},
+				},
+				{
+					Name:  "down",
+					Usage: "Revert the migrations newer than a version",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "to",
+							Value: -1,
+							Usage: "Version to migrate to, 0 reverts all the migrations",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Check the migrations revert and report the rows they delete without changing the database",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "Revert the migrations which delete records or other rows, the data is lost for good",
+						},
+					},
+					Action: func(c *cli.Context) {
+						if c.Int("to") < 0 {
+							fmt.Fprintf(os.Stderr, "--to is required\n")
+							os.Exit(1)
+						}
+						migrate(c, true)
+					},
+				},
+			},
+		},
 	}
 	app.Action = func(c *cli.Context) {
-		config := c.String("config")
-
-		if config != "" {
-			if _, err := os.Stat(config); err != nil {
-				fmt.Fprintf(os.Stderr, "Can't find config file `%s`\n", config)
-				os.Exit(1)
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "Can't find config file\n")
-			os.Exit(1)
-		}
+		config := configPath(c.String("config"))
 
 		// context.Background()
 		err := server.Run(config)
@@ -59,3 +303,44 @@ func main() {
 		panic(err)
 	}
 }
+
+// migrate - runs the up and down subcommands of migrate
+func migrate(c *cli.Context, down bool) {
+	config := configPath(c.GlobalString("config"))
+	dryRun := c.Bool("dry-run")
+
+	steps, err := server.Migrate(config, down, c.Int("to"), dryRun, c.Bool("force"))
+	for _, step := range steps {
+		fmt.Println(step)
+		for _, deleted := range step.Deleted {
+			fmt.Printf("  deletes %s\n", deleted)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch {
+	case len(steps) == 0:
+		fmt.Println("nothing to migrate")
+	case dryRun:
+		fmt.Printf("dry run: %d migrations would be applied\n", len(steps))
+	}
+}
+
+// configPath - exits when the config file is missing
+func configPath(config string) string {
+	if config != "" {
+		if _, err := os.Stat(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't find config file `%s`\n", config)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Can't find config file\n")
+		os.Exit(1)
+	}
+
+	return config
+}
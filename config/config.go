@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/denisschmidt/uploader/constants"
 	"github.com/spf13/viper"
+	"time"
 )
 
 type Options struct {
@@ -15,16 +16,41 @@ type Options struct {
 	EnablePrometheus   bool     `mapstructure:"enable_prometheus"`
 }
 
+// S3Options of the "s3" storage, any S3 compatible object storage can be used
+type S3Options struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	UseSSL    bool   `mapstructure:"use_ssl"`
+	PartSize  int64  `mapstructure:"part_size"`
+}
+
 type Config struct {
-	Debug          bool
-	Port           int
-	DBPath         string
-	DBChunkSize    int
-	SecretKey      string   `mapstructure:"secret_key"`
-	AllowedHeaders []string `mapstructure:"allowed_headers"`
-	AllowedMethods []string `mapstructure:"allowed_methods"`
-	AllowedOrigins []string `mapstructure:"allowed_origins"`
-	Options        *Options
+	Debug             bool
+	Port              int
+	DBPath            string
+	DBChunkSize       int
+	DBDedup           bool          `mapstructure:"db_dedup"`
+	DBCompression     string        `mapstructure:"db_compression"`
+	Storage           string        `mapstructure:"storage"`
+	StoragePath       string        `mapstructure:"storage_path"`
+	S3                S3Options     `mapstructure:"s3"`
+	EncryptionKey     string        `mapstructure:"encryption_key"`
+	EncryptionKeyFile string        `mapstructure:"encryption_key_file"`
+	UploadExpiration  time.Duration `mapstructure:"upload_expiration"`
+	ReaperInterval    time.Duration `mapstructure:"reaper_interval"`
+	ReaperBatchSize   int           `mapstructure:"reaper_batch_size"`
+	TrashRetention    time.Duration `mapstructure:"trash_retention"`
+	MaxFileSize       int64         `mapstructure:"max_file_size"`
+	StorageCapacity   int64         `mapstructure:"storage_capacity"`
+	SecretKey         string        `mapstructure:"secret_key"`
+	AllowedHeaders    []string      `mapstructure:"allowed_headers"`
+	AllowedMethods    []string      `mapstructure:"allowed_methods"`
+	AllowedOrigins    []string      `mapstructure:"allowed_origins"`
+	Options           *Options
 }
 
 func DefaultConfig() *Config {
@@ -32,6 +58,16 @@ func DefaultConfig() *Config {
 		Port:        DefaultPort,
 		DBPath:      DefaultDBPath,
 		DBChunkSize: DefaultChunkSize,
+		Storage:     DefaultStorage,
+		StoragePath: DefaultStoragePath,
+		S3: S3Options{
+			UseSSL:   true,
+			PartSize: DefaultS3PartSize,
+		},
+		UploadExpiration: DefaultUploadExpiration,
+		ReaperInterval:   DefaultReaperInterval,
+		ReaperBatchSize:  DefaultReaperBatchSize,
+		TrashRetention:   DefaultTrashRetention,
 		Options: &Options{
 			DefaultUserAgent: fmt.Sprint(DefaultUserAgent, "/", constants.Version),
 		},
@@ -47,6 +83,18 @@ func load(content string, isPath bool) (*Config, error) {
 	viper.SetDefault("port", defaultConfig.Port)
 	viper.SetDefault("dbPath", defaultConfig.DBPath)
 	viper.SetDefault("dbChunkSize", defaultConfig.DBChunkSize)
+	viper.SetDefault("db_dedup", defaultConfig.DBDedup)
+	viper.SetDefault("db_compression", defaultConfig.DBCompression)
+	viper.SetDefault("storage", defaultConfig.Storage)
+	viper.SetDefault("storage_path", defaultConfig.StoragePath)
+	viper.SetDefault("s3.use_ssl", defaultConfig.S3.UseSSL)
+	viper.SetDefault("s3.part_size", defaultConfig.S3.PartSize)
+	viper.SetDefault("upload_expiration", defaultConfig.UploadExpiration)
+	viper.SetDefault("reaper_interval", defaultConfig.ReaperInterval)
+	viper.SetDefault("reaper_batch_size", defaultConfig.ReaperBatchSize)
+	viper.SetDefault("trash_retention", defaultConfig.TrashRetention)
+	viper.SetDefault("max_file_size", defaultConfig.MaxFileSize)
+	viper.SetDefault("storage_capacity", defaultConfig.StorageCapacity)
 	viper.SetEnvPrefix("uploader")
 
 	var err error
@@ -1,5 +1,14 @@
 package config
 
+import "time"
+
+// The storages the content of the records can be kept in, the metadata is always in SQLite
+const (
+	StorageSQLite = "sqlite"
+	StorageFS     = "fs"
+	StorageS3     = "s3"
+)
+
 const (
 	// DefaultPort is the default port of the application server
 	DefaultPort = 4001
@@ -7,6 +16,23 @@ const (
 	DefaultDBPath    = "data/database.db"
 	DefaultChunkSize = 327680
 
+	// DefaultStorage keeps the content of the records as chunks in the SQLite database
+	DefaultStorage = StorageSQLite
+	// DefaultStoragePath is the root of the directory tree used by the "fs" storage
+	DefaultStoragePath = "data/blobs"
+	// DefaultS3PartSize is the size of the multipart upload parts of the "s3" storage
+	DefaultS3PartSize = 16 << 20
+
+	// DefaultUploadExpiration is how long an unfinished resumable upload is kept
+	DefaultUploadExpiration = 24 * time.Hour
+
+	// DefaultReaperInterval is how often expired records and uploads are deleted
+	DefaultReaperInterval = 10 * time.Minute
+	// DefaultReaperBatchSize is the number of records deleted in a single transaction
+	DefaultReaperBatchSize = 500
+	// DefaultTrashRetention is how long the deleted records are kept in the trash before they are purged
+	DefaultTrashRetention = 30 * 24 * time.Hour
+
 	// DefaultUserAgent is the default user-agent header
 	DefaultUserAgent = "uploader"
 )
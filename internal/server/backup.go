@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// snapshotter - the stores which can write a consistent copy of themselves
+type snapshotter interface {
+	WriteSnapshot(w io.Writer, gzipped bool) error
+}
+
+// snapshotGet - streams the snapshot of the database, ?gzip=true compresses it
+func (h handlers) snapshotGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store, ok := h.db.(snapshotter)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "The store doesn't support snapshots"})
+			return
+		}
+
+		gzipped := c.Query("gzip") == "true"
+
+		filename := fmt.Sprintf("database-%s.db", time.Now().UTC().Format("20060102-150405"))
+		contentType := "application/vnd.sqlite3"
+		if gzipped {
+			filename += ".gz"
+			contentType = "application/gzip"
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		if err := store.WriteSnapshot(c.Writer, gzipped); err != nil {
+			log.Printf("failed to write snapshot: %v", err)
+			// the status can't be changed once the snapshot is being streamed
+			if !c.Writer.Written() {
+				c.Header("Content-Type", "")
+				c.Header("Content-Disposition", "")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to write snapshot: %v", err)})
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"errors"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// snapshotStore - the memory store which writes a fixed snapshot
+type snapshotStore struct {
+	*memory.Store
+	err error
+}
+
+func (s snapshotStore) WriteSnapshot(w io.Writer, gzipped bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	if gzipped {
+		_, err := w.Write([]byte("gzipped snapshot"))
+		return err
+	}
+	_, err := w.Write([]byte("snapshot"))
+	return err
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, row := range []struct {
+		description string
+		store       store.Store
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			description: "plain",
+			store:       snapshotStore{Store: memory.New()},
+			path:        "/api/admin/snapshot",
+			status:      http.StatusOK,
+			contentType: "application/vnd.sqlite3",
+			body:        "snapshot",
+		},
+		{
+			description: "gzipped",
+			store:       snapshotStore{Store: memory.New()},
+			path:        "/api/admin/snapshot?gzip=true",
+			status:      http.StatusOK,
+			contentType: "application/gzip",
+			body:        "gzipped snapshot",
+		},
+		{
+			description: "failed",
+			store:       snapshotStore{Store: memory.New(), err: errors.New("disk full")},
+			path:        "/api/admin/snapshot",
+			status:      http.StatusInternalServerError,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"error":"Failed to write snapshot: disk full"}`,
+		},
+		{
+			description: "not supported",
+			store:       memory.New(),
+			path:        "/api/admin/snapshot",
+			status:      http.StatusNotImplemented,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"error":"The store doesn't support snapshots"}`,
+		},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			defaultConfig := config.DefaultConfig()
+			defaultConfig.SecretKey = "hello"
+
+			s, err := server.New(defaultConfig, row.store, fake_auth.FakeAuth{})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", row.path, nil)
+			require.NoError(t, err)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, row.status, rec.Code)
+			require.Equal(t, row.contentType, rec.Header().Get("Content-Type"))
+			require.Equal(t, row.body, rec.Body.String())
+			if row.status == http.StatusOK {
+				require.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+			} else {
+				require.Empty(t, rec.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
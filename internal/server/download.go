@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultContentType = "application/octet-stream"
+
+	dispositionAttachment = "attachment"
+	dispositionInline     = "inline"
+)
+
+// fileGet - streams the record content back to the client as an attachment,
+// also serves HEAD, Range and conditional requests
+func (h handlers) fileGet() gin.HandlerFunc {
+	return h.serveFile(dispositionAttachment)
+}
+
+// fileView - public view of the record, the browser is allowed to render the content in place
+func (h handlers) fileView() gin.HandlerFunc {
+	return h.serveFile(dispositionInline)
+}
+
+func (h handlers) serveFile(disposition string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad record ID: %v", err),
+			})
+			return
+		}
+
+		version, err := parseVersion(c.Query("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+
+		record, err := h.db.GetRecordVersion(id, version)
+		if err != nil {
+			switch err.(type) {
+			case types.ErrFileNotExists:
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found ID: %v", id),
+				})
+				return
+			case types.ErrVersionNotExists:
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			log.Printf("failed to read record %v: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read record: %v", err),
+			})
+			return
+		}
+		if closer, ok := record.Reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		contentType := string(record.ContentType)
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+
+		// the view shares the origin with the API, so the content able to run script is never rendered in place
+		recordDisposition := disposition
+		if isActiveContent(contentType) {
+			recordDisposition = dispositionAttachment
+			c.Header("Content-Security-Policy", "sandbox")
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Disposition", contentDisposition(recordDisposition, record.Filename))
+		c.Header("ETag", recordETag(record.Metadata))
+		if digest := recordDigest(record.Metadata); digest != "" {
+			c.Header("Digest", digest)
+		}
+
+		// ServeContent takes care of HEAD, Range (single and multipart) and conditional requests,
+		// the reader is seekable so only the requested ranges are loaded from the store
+		modifiedAt := record.ModifiedAt
+		if modifiedAt.IsZero() {
+			modifiedAt = record.CreateAt
+		}
+		http.ServeContent(c.Writer, c.Request, string(record.Filename), modifiedAt, record.Reader)
+	}
+}
+
+// isActiveContent - the content type the browser may run script from, the malformed types are taken as active
+func isActiveContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+
+	switch mediaType {
+	case "text/html", "text/xml", "text/xsl", "text/javascript", "text/ecmascript",
+		"application/xml", "application/javascript", "application/ecmascript":
+		return true
+	}
+	// application/xhtml+xml, image/svg+xml and the other XML documents
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// recordETag - the SHA-256 of the content is its strong validator, the ID can be reused by another content
+// when a record is replaced on import. The records stored before the digest was recorded are told apart
+// by the ID together with the creation time and the version, the content of a record version never changes
+func recordETag(metadata types.Metadata) string {
+	if metadata.SHA256 != "" {
+		return fmt.Sprintf(`"%s"`, metadata.SHA256)
+	}
+	if metadata.Version > 1 {
+		return fmt.Sprintf(`"%s-%x-%d"`, metadata.ID, metadata.CreateAt.UnixNano(), metadata.Version)
+	}
+	return fmt.Sprintf(`"%s-%x"`, metadata.ID, metadata.CreateAt.UnixNano())
+}
+
+// recordDigest - the SHA-256 of the whole content in the RFC 3230 `Digest` header format,
+// empty for the records stored before the digest was recorded
+func recordDigest(metadata types.Metadata) string {
+	sum, err := hex.DecodeString(metadata.SHA256)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+func contentDisposition(disposition string, filename types.Filename) string {
+	if filename == "" {
+		return disposition
+	}
+	return mime.FormatMediaType(disposition, map[string]string{
+		"filename": string(filename),
+	})
+}
@@ -0,0 +1,299 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetRecord(t *testing.T) {
+	mockRecord := types.Metadata{
+		ID:          types.ID(strings.Repeat("X", 10)),
+		Filename:    types.Filename("test_init_name.txt"),
+		ContentType: types.ContentType("text/plain"),
+		Note:        types.Note("test init note"),
+	}
+	contents := "file data spread across several chunks"
+
+	for _, row := range []struct {
+		description string
+		path        string
+		status      int
+		disposition string
+	}{
+		{
+			description: "download existing record",
+			path:        "/api/file/" + strings.Repeat("X", 10),
+			status:      http.StatusOK,
+			disposition: `attachment; filename=test_init_name.txt`,
+		},
+		{
+			description: "public view of existing record",
+			path:        "/" + strings.Repeat("X", 10),
+			status:      http.StatusOK,
+			disposition: `inline; filename=test_init_name.txt`,
+		},
+		{
+			description: "record does not exist",
+			path:        "/api/file/" + strings.Repeat("B", 10),
+			status:      http.StatusNotFound,
+		},
+		{
+			description: "invalid record ID",
+			path:        "/api/file/bad",
+			status:      http.StatusBadRequest,
+		},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			defaultConfig := config.DefaultConfig()
+			defaultConfig.SecretKey = "hello"
+			database := memory.New()
+
+			err := database.InsertRecord(strings.NewReader(contents), mockRecord)
+			require.NoError(t, err)
+
+			s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", row.path, nil)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			require.Equal(t, row.status, rec.Code)
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, contents, rec.Body.String())
+			require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+			require.Equal(t, "38", rec.Header().Get("Content-Length"))
+			require.Equal(t, row.disposition, rec.Header().Get("Content-Disposition"))
+			require.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+			require.Empty(t, rec.Header().Get("Content-Security-Policy"))
+
+			digest := sha256.Sum256([]byte(contents))
+			require.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(digest[:]), rec.Header().Get("Digest"))
+		})
+	}
+}
+
+func TestViewActiveContent(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	for i, row := range []struct {
+		contentType string
+		disposition string
+	}{
+		{contentType: "text/html; charset=utf-8", disposition: "attachment"},
+		{contentType: "image/svg+xml", disposition: "attachment"},
+		{contentType: "application/xhtml+xml", disposition: "attachment"},
+		{contentType: "text/javascript", disposition: "attachment"},
+		{contentType: "TEXT/HTML", disposition: "attachment"},
+		{contentType: "text/html;;", disposition: "attachment"},
+		{contentType: "image/png", disposition: "inline"},
+	} {
+		id := types.ID(strings.Repeat(string(rune('a'+i)), 10))
+		err := database.InsertRecord(strings.NewReader("<script>alert(1)</script>"), types.Metadata{
+			ID:          id,
+			Filename:    "page",
+			ContentType: types.ContentType(row.contentType),
+			CreateAt:    time.Now(),
+		})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("GET", "/"+string(id), nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, row.contentType)
+
+		require.Equal(t, row.disposition+"; filename=page", rec.Header().Get("Content-Disposition"), row.contentType)
+		require.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"), row.contentType)
+		if row.disposition == "attachment" {
+			require.Equal(t, "sandbox", rec.Header().Get("Content-Security-Policy"), row.contentType)
+		} else {
+			require.Empty(t, rec.Header().Get("Content-Security-Policy"), row.contentType)
+		}
+	}
+}
+
+func TestUploadThenDownload(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	formData, contentType := createMultipartFormBody("report.txt", "", bytes.NewBufferString("round trip content"))
+
+	req, err := http.NewRequest("POST", "/api/file", formData)
+	require.NoError(t, err)
+	req.Header.Add("Content-Type", contentType)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response types.RecordPostResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	req, err = http.NewRequest("GET", "/api/file/"+response.ID, nil)
+	require.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "round trip content", rec.Body.String())
+	require.Equal(t, "attachment; filename=report.txt", rec.Header().Get("Content-Disposition"))
+}
+
+func TestGetRecordPartialAndConditional(t *testing.T) {
+	createAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	mockRecord := types.Metadata{
+		ID:          types.ID(strings.Repeat("X", 10)),
+		Filename:    types.Filename("numbers.txt"),
+		ContentType: types.ContentType("text/plain"),
+		CreateAt:    createAt,
+	}
+	contents := "0123456789abcdefghij"
+
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	err := database.InsertRecord(strings.NewReader(contents), mockRecord)
+	require.NoError(t, err)
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	get := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/api/file/"+string(mockRecord.ID), nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("GET", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	require.Equal(t, createAt.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+
+	t.Run("single range crossing chunk boundaries", func(t *testing.T) {
+		rec := get("GET", map[string]string{"Range": "bytes=4-11"})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		require.Equal(t, "456789ab", rec.Body.String())
+		require.Equal(t, "bytes 4-11/20", rec.Header().Get("Content-Range"))
+	})
+
+	t.Run("suffix range", func(t *testing.T) {
+		rec := get("GET", map[string]string{"Range": "bytes=-3"})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		require.Equal(t, "hij", rec.Body.String())
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		rec := get("GET", map[string]string{"Range": "bytes=0-1,18-19"})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges"))
+		require.Contains(t, rec.Body.String(), "01")
+		require.Contains(t, rec.Body.String(), "ij")
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		rec := get("GET", map[string]string{"Range": "bytes=50-60"})
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+	})
+
+	t.Run("head returns size without body", func(t *testing.T) {
+		rec := get("HEAD", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "20", rec.Header().Get("Content-Length"))
+		require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+		require.Equal(t, 0, rec.Body.Len())
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		rec := get("GET", map[string]string{"If-None-Match": etag})
+		require.Equal(t, http.StatusNotModified, rec.Code)
+	})
+
+	t.Run("if-modified-since", func(t *testing.T) {
+		rec := get("GET", map[string]string{"If-Modified-Since": createAt.Add(time.Hour).Format(http.TimeFormat)})
+		require.Equal(t, http.StatusNotModified, rec.Code)
+
+		rec = get("GET", map[string]string{"If-Modified-Since": createAt.Add(-time.Hour).Format(http.TimeFormat)})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, contents, rec.Body.String())
+	})
+
+	t.Run("if-range with stale validator returns full content", func(t *testing.T) {
+		rec := get("GET", map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, contents, rec.Body.String())
+	})
+}
+
+func TestETagOfReplacedRecord(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	// an overwriting import stores the new content under the same ID and creation time
+	metadata := types.Metadata{ID: "abcdefghij", Filename: "replaced.txt", CreateAt: time.Now()}
+	get := func(content string, headers map[string]string) *httptest.ResponseRecorder {
+		require.NoError(t, database.InsertRecord(strings.NewReader(content), metadata))
+
+		req, err := http.NewRequest("GET", "/api/file/"+string(metadata.ID), nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		require.NoError(t, database.DeleteRecord(metadata.ID))
+		require.NoError(t, database.PurgeRecord(metadata.ID))
+		return rec
+	}
+
+	first := get("first content", nil)
+	require.Equal(t, http.StatusOK, first.Code)
+	digest := sha256.Sum256([]byte("first content"))
+	require.Equal(t, `"`+hex.EncodeToString(digest[:])+`"`, first.Header().Get("ETag"))
+
+	rec := get("second content", map[string]string{"If-None-Match": first.Header().Get("ETag")})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "second content", rec.Body.String())
+	require.NotEqual(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+}
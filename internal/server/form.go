@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// uploadForm reads the multipart upload form in a single pass, nothing of it is spooled to temporary files.
+// The fields are kept in memory and validated as they arrive, the file parts are streamed to the store as they are read.
+// The fields apply to every file of the form wherever they are, the files stored before some of the fields
+// arrived are updated once the form is over
+type uploadForm struct {
+	reader *multipart.Reader
+	values url.Values
+	// size - the bytes of the field values read so far, they can't take more than MULTI_PART_MAX_MEMORY
+	size int64
+	// revision - the number of the fields read so far
+	revision int
+	// fields - the fields parsed the last time the form was checked
+	fields uploadFields
+}
+
+// uploadFields - the metadata of the record from the fields of the form
+type uploadFields struct {
+	note             string
+	expirationInDays int
+	attributes       map[string]string
+	tags             []string
+}
+
+// formError - the form is malformed or cut, it fails the content read by the store
+type formError struct {
+	Err error
+}
+
+func (fe formError) Error() string {
+	return fmt.Sprintf("bad upload form: %s", fe.Err)
+}
+
+func (fe formError) Unwrap() error {
+	return fe.Err
+}
+
+func newUploadForm(r *http.Request) (*uploadForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadForm{
+		reader: reader,
+		values: url.Values{},
+	}, nil
+}
+
+// nextFile - reads the fields up to the next file part, io.EOF at the end of the form
+func (f *uploadForm) nextFile() (*multipart.Part, error) {
+	for {
+		part, err := f.reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == FILE_FIELD {
+			return part, nil
+		}
+		if err := f.readField(part); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readField - keeps the value of the field part, the `metadata` part may be sent as a file as well.
+// The parts without a name are skipped
+func (f *uploadForm) readField(part *multipart.Part) error {
+	name := part.FormName()
+	if name == "" {
+		return nil
+	}
+
+	value, err := io.ReadAll(io.LimitReader(part, MULTI_PART_MAX_MEMORY-f.size+1))
+	if err != nil {
+		return err
+	}
+	f.size += int64(len(value))
+	if f.size > MULTI_PART_MAX_MEMORY {
+		return fmt.Errorf("form fields exceed %d bytes", MULTI_PART_MAX_MEMORY)
+	}
+
+	switch name {
+	case NOTE_FIELD:
+		err = validateFileNote(string(value))
+	case EXPIRATION_FIELD:
+		_, err = parseExpirationInDays(string(value))
+	}
+	if err != nil {
+		return err
+	}
+
+	f.values.Add(name, string(value))
+	f.revision++
+	return nil
+}
+
+// check - parses the fields read so far, the labels are only validated together
+func (f *uploadForm) check() error {
+	expirationInDays, err := parseExpirationInDays(f.values.Get(EXPIRATION_FIELD))
+	if err != nil {
+		return err
+	}
+
+	attributes, tags, err := parseUploadLabels(f.values)
+	if err != nil {
+		return err
+	}
+
+	f.fields = uploadFields{
+		note:             f.values.Get(NOTE_FIELD),
+		expirationInDays: expirationInDays,
+		attributes:       attributes,
+		tags:             tags,
+	}
+	return nil
+}
+
+// expiresAt - the expiration requested by the fields, zero for the default one
+func (fields uploadFields) expiresAt(now time.Time) time.Time {
+	if fields.expirationInDays == 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, fields.expirationInDays)
+}
+
+// fileContent - the reader of the file part, fails when the file is empty
+func fileContent(part *multipart.Part) (io.Reader, error) {
+	r := bufio.NewReader(part)
+	if _, err := r.Peek(1); err == io.EOF {
+		return nil, errors.New("file is empty")
+	} else if err != nil {
+		return nil, formError{err}
+	}
+
+	return formContent{r}, nil
+}
+
+// formContent reads the file part, the failures to read it mean the form is malformed or cut
+type formContent struct {
+	r io.Reader
+}
+
+func (c formContent) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		return n, formError{err}
+	}
+	return n, err
+}
+
+// filename - the name of the file part as it was sent, multipart.Part.FileName drops the directories of it
+func filename(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
+}
@@ -0,0 +1,221 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formPart is a part of the multipart form, a file one when filename is set
+type formPart struct {
+	name     string
+	filename string
+	value    string
+}
+
+func createMultipartForm(t *testing.T, parts ...formPart) (*bytes.Buffer, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, part := range parts {
+		var w io.Writer
+		var err error
+		if part.filename != "" {
+			w, err = mw.CreateFormFile(part.name, part.filename)
+		} else {
+			w, err = mw.CreateFormField(part.name)
+		}
+		require.NoError(t, err)
+		_, err = w.Write([]byte(part.value))
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	return &b, mw.FormDataContentType()
+}
+
+func TestUploadFormOrder(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	upload := func(parts ...formPart) (*httptest.ResponseRecorder, types.ID) {
+		body, contentType := createMultipartForm(t, parts...)
+		req, err := http.NewRequest("POST", "/api/file", body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		var response types.RecordPostResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec, types.ID(response.ID)
+	}
+
+	large := strings.Repeat("0123456789", 300000)
+	for _, row := range []struct {
+		description string
+		parts       []formPart
+	}{
+		{
+			description: "fields before the file",
+			parts: []formPart{
+				{name: "note", value: "leading"},
+				{name: "expires_in_days", value: "3"},
+				{name: "file", filename: "large.txt", value: large},
+			},
+		},
+		{
+			description: "fields after the file",
+			parts: []formPart{
+				{name: "file", filename: "large.txt", value: large},
+				{name: "expires_in_days", value: "3"},
+				{name: "note", value: "trailing"},
+			},
+		},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			rec, id := upload(row.parts...)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			record, err := database.GetRecord(id)
+			require.NoError(t, err)
+			content, err := io.ReadAll(record.Reader)
+			require.NoError(t, err)
+			require.Equal(t, large, string(content))
+			require.Equal(t, types.Filename("large.txt"), record.Filename)
+			require.NotEmpty(t, record.Note)
+			require.WithinDuration(t, time.Now().AddDate(0, 0, 3), record.ExpiresAt, time.Minute)
+		})
+	}
+
+	for _, row := range []struct {
+		description string
+		parts       []formPart
+	}{
+		{
+			description: "invalid note after the file",
+			parts: []formPart{
+				{name: "file", filename: "test.txt", value: "content"},
+				{name: "note", value: strings.Repeat("X", 501)},
+			},
+		},
+		{
+			description: "invalid labels after the file",
+			parts: []formPart{
+				{name: "file", filename: "test.txt", value: "content"},
+				{name: "metadata", value: "{"},
+			},
+		},
+		{
+			description: "invalid note between the files",
+			parts: []formPart{
+				{name: "file", filename: "first.txt", value: "content"},
+				{name: "note", value: strings.Repeat("X", 501)},
+				{name: "file", filename: "second.txt", value: "content"},
+			},
+		},
+		{
+			description: "no file",
+			parts: []formPart{
+				{name: "note", value: "no file"},
+			},
+		},
+		{
+			description: "fields too large",
+			parts: []formPart{
+				{name: "tags", value: strings.Repeat("X", server.MULTI_PART_MAX_MEMORY+1)},
+				{name: "file", filename: "test.txt", value: "content"},
+			},
+		},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			before, err := database.Stats()
+			require.NoError(t, err)
+
+			rec, _ := upload(row.parts...)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+
+			// nothing is stored of the rejected upload
+			after, err := database.Stats()
+			require.NoError(t, err)
+			require.Equal(t, before, after)
+		})
+	}
+}
+
+// insertSignal tells when the store starts to read the content of the record
+type insertSignal struct {
+	store.Store
+	inserting chan struct{}
+}
+
+func (s insertSignal) InsertRecord(reader io.Reader, metadata types.Metadata) error {
+	close(s.inserting)
+	return s.Store.InsertRecord(reader, metadata)
+}
+
+func TestUploadIsStreamed(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := insertSignal{Store: memory.New(), inserting: make(chan struct{})}
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		f, err := mw.CreateFormFile("file", "stream.bin")
+		if err == nil {
+			_, err = f.Write(bytes.Repeat([]byte("a"), 2*server.MULTI_PART_MAX_MEMORY))
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		// the rest of the body is sent only once the store reads the content
+		select {
+		case <-database.inserting:
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(errors.New("the content isn't streamed to the store"))
+			return
+		}
+
+		f.Write([]byte("b"))
+		mw.WriteField("note", "streamed")
+		pw.CloseWithError(mw.Close())
+	}()
+
+	req, err := http.NewRequest("POST", "/api/file", pr)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response types.RecordPostResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	metadata, err := database.GetMetadata(types.ID(response.ID))
+	require.NoError(t, err)
+	require.Equal(t, int64(2*server.MULTI_PART_MAX_MEMORY+1), metadata.Size)
+	require.Equal(t, types.Note("streamed"), metadata.Note)
+}
@@ -10,11 +10,26 @@ import (
 	"github.com/denisschmidt/uploader/internal/types"
 	"github.com/gin-gonic/contrib/cors"
 	"github.com/gin-gonic/gin"
-	"net/http"
 	"strconv"
 	"time"
 )
 
+// exposedHeaders - response headers the browser clients need to read for downloads and resumable uploads
+var exposedHeaders = []string{
+	"Content-Disposition",
+	"Content-Range",
+	"Digest",
+	"ETag",
+	"Location",
+	"Tus-Resumable",
+	"Tus-Version",
+	"Tus-Extension",
+	"Tus-Max-Size",
+	"Upload-Offset",
+	"Upload-Length",
+	"Upload-Expires",
+}
+
 type dbError struct {
 	Err error
 }
@@ -25,8 +40,10 @@ type HttpServer struct {
 }
 
 type handlers struct {
-	auth types.Authorizer
-	db   store.Store
+	auth             types.Authorizer
+	db               store.Store
+	uploadExpiration time.Duration
+	quota            quota
 }
 
 func (dbe dbError) Error() string {
@@ -49,10 +66,19 @@ func NewHTTPServer(config *config.Config, database store.Store, authenticator ty
 }
 
 func (s *HttpServer) Init(database store.Store, authenticator types.Authorizer) error {
+	if s.config.MaxFileSize < 0 || s.config.StorageCapacity < 0 {
+		return fmt.Errorf("max_file_size and storage_capacity can't be negative")
+	}
+
 	router := gin.Default()
 	handlder := &handlers{
-		auth: authenticator,
-		db:   database,
+		auth:             authenticator,
+		db:               database,
+		uploadExpiration: s.config.UploadExpiration,
+		quota: quota{
+			maxFileSize: s.config.MaxFileSize,
+			capacity:    s.config.StorageCapacity,
+		},
 	}
 
 	if s.config.Debug {
@@ -71,6 +97,7 @@ func (s *HttpServer) Init(database store.Store, authenticator types.Authorizer)
 			AllowedOrigins:  allowedOrigins,
 			AllowedMethods:  s.config.AllowedMethods,
 			AllowedHeaders:  s.config.AllowedHeaders,
+			ExposedHeaders:  exposedHeaders,
 		}))
 	}
 
@@ -85,9 +112,7 @@ func (s *HttpServer) Init(database store.Store, authenticator types.Authorizer)
 			stat.EndRecording(startTime, recorder)
 		})
 
-		router.GET("/sys/stats", func(c *gin.Context) {
-			c.JSON(http.StatusOK, stat.GatherData())
-		})
+		router.GET("/sys/stats", handlder.storeStats(stat))
 	}
 
 	restrictIPAddresses := RestrictIPAddresses(s.config.Options.AllowedIPAddresses)
@@ -103,13 +128,41 @@ func (s *HttpServer) Init(database store.Store, authenticator types.Authorizer)
 	protectedApi := router.Group("api")
 	protectedApi.Use(handlder.requireAuth())
 	{
+		protectedApi.GET("/files", handlder.filesList())
+		protectedApi.GET("/search", handlder.searchGet())
+		protectedApi.GET("/file/:id", handlder.fileGet())
+		protectedApi.HEAD("/file/:id", handlder.fileGet())
 		protectedApi.POST("/file", handlder.filePost())
 		protectedApi.PUT("/file/:id", handlder.filePut())
+		protectedApi.PUT("/file/:id/content", handlder.fileContentPut())
+		protectedApi.GET("/file/:id/versions", handlder.fileVersionsGet())
 		protectedApi.DELETE("/file/:id", handlder.fileDelete())
+		protectedApi.GET("/trash", handlder.trashList())
+		protectedApi.POST("/trash/:id/restore", handlder.trashRestore())
+		protectedApi.DELETE("/trash/:id", handlder.trashDelete())
+		protectedApi.DELETE("/trash", handlder.trashEmpty())
+		protectedApi.GET("/usage", handlder.usageGet())
+		protectedApi.GET("/settings", handlder.settingsGet())
+		protectedApi.PUT("/settings", handlder.settingsPut())
+		protectedApi.GET("/admin/snapshot", restrictIPAddresses, handlder.snapshotGet())
+	}
+
+	uploads := protectedApi.Group("/upload")
+	uploads.Use(handlder.tusResumable())
+	{
+		uploads.OPTIONS("", handlder.uploadOptions())
+		uploads.POST("", handlder.uploadPost())
+		uploads.HEAD("/:id", handlder.uploadHead())
+		uploads.PATCH("/:id", handlder.uploadPatch())
+		uploads.DELETE("/:id", handlder.uploadDelete())
 	}
 
 	view := router.Group("/")
 	view.Use(middleware.UpgradeToHttps())
+	{
+		view.GET("/:id", handlder.fileView())
+		view.HEAD("/:id", handlder.fileView())
+	}
 
 	s.engine = router
 
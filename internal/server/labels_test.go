@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordLabels(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	upload := func(fields map[string][]string) (int, types.ID) {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		f, err := mw.CreateFormFile("file", "build.zip")
+		require.NoError(t, err)
+		f.Write([]byte("build output"))
+		for name, values := range fields {
+			for _, value := range values {
+				require.NoError(t, mw.WriteField(name, value))
+			}
+		}
+		require.NoError(t, mw.Close())
+
+		req, err := http.NewRequest("POST", "/api/file", &b)
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		var response types.RecordPostResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec.Code, types.ID(response.ID)
+	}
+
+	list := func(query string) (int, []types.ID) {
+		req, err := http.NewRequest("GET", "/api/files"+query, nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		ids := []types.ID{}
+		if rec.Code == http.StatusOK {
+			var page types.RecordsPage
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+			for _, record := range page.Records {
+				ids = append(ids, record.ID)
+			}
+		}
+		return rec.Code, ids
+	}
+
+	// the fields take precedence over the JSON part
+	status, nightly := upload(map[string][]string{
+		"metadata":        {`{"attributes": {"project": "uploader", "build": "1"}, "tags": ["nightly"]}`},
+		"attr.build":      {"42"},
+		"attr.owner":      {"ci"},
+		"tags":            {"linux, amd64", "nightly"},
+		"expires_in_days": {"7"},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	metadata, err := database.GetMetadata(nightly)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"project": "uploader", "build": "42", "owner": "ci"}, metadata.Attributes)
+	require.Equal(t, []string{"amd64", "linux", "nightly"}, metadata.Tags)
+
+	status, release := upload(map[string][]string{
+		"attr.project": {"uploader"},
+		"tags":         {"release"},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	for _, fields := range []map[string][]string{
+		{"metadata": {`{"tags": ["bad tag"]}`}},
+		{"metadata": {`{"unknown": true}`}},
+		{"attr.build": {"1", "2"}},
+		{"tags": {"<script>"}},
+		{"attr.note": {strings.Repeat("x", 257)}},
+	} {
+		status, _ := upload(fields)
+		require.Equal(t, http.StatusBadRequest, status, fields)
+	}
+
+	status, ids := list("?attr.project=uploader&sort=filename")
+	require.Equal(t, http.StatusOK, status)
+	require.ElementsMatch(t, []types.ID{nightly, release}, ids)
+
+	status, ids = list("?attr.project=uploader&tags=linux,nightly")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []types.ID{nightly}, ids)
+
+	status, _ = list("?tags=bad%20tag")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	put := func(id types.ID, body string) int {
+		req, err := http.NewRequest("PUT", "/api/file/"+string(id), strings.NewReader(body))
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the labels left out are kept
+	require.Equal(t, http.StatusOK, put(release, `{"filename": "release.zip", "tags": ["release", "stable"]}`))
+	metadata, err = database.GetMetadata(release)
+	require.NoError(t, err)
+	require.Equal(t, types.Filename("release.zip"), metadata.Filename)
+	require.Equal(t, map[string]string{"project": "uploader"}, metadata.Attributes)
+	require.Equal(t, []string{"release", "stable"}, metadata.Tags)
+
+	require.Equal(t, http.StatusOK, put(release, `{"filename": "release.zip", "attributes": {}}`))
+	metadata, err = database.GetMetadata(release)
+	require.NoError(t, err)
+	require.Nil(t, metadata.Attributes)
+	require.Equal(t, []string{"release", "stable"}, metadata.Tags)
+
+	require.Equal(t, http.StatusBadRequest, put(release, `{"filename": "release.zip", "attributes": {"": "empty key"}}`))
+
+	status, ids = list("?tags=stable")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []types.ID{release}, ids)
+}
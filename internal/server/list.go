@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+func (h handlers) filesList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, err := parseListOptions(c.Request.URL.Query())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+
+		page, err := h.db.ListRecords(options)
+		if err != nil {
+			if _, ok := err.(types.ErrInvalidCursor); ok {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Bad request: %v", err),
+				})
+				return
+			}
+			log.Printf("failed to list records: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to list records: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
@@ -0,0 +1,79 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListRecords(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+	now := time.Now()
+
+	for i, filename := range []string{"a.txt", "b.txt", "c.png"} {
+		err := database.InsertRecord(strings.NewReader(strings.Repeat("x", i+1)), types.Metadata{
+			ID:          types.ID(strings.Repeat(string("abc"[i]), 10)),
+			Filename:    types.Filename(filename),
+			ContentType: "text/plain",
+			CreateAt:    now.Add(time.Duration(i) * time.Second),
+		})
+		require.NoError(t, err)
+	}
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	list := func(query string) (int, types.RecordsPage) {
+		req, err := http.NewRequest("GET", "/api/files"+query, nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		var page types.RecordsPage
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		}
+		return rec.Code, page
+	}
+
+	status, page := list("?limit=2&order=desc")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Records, 2)
+	require.Equal(t, types.Filename("c.png"), page.Records[0].Filename)
+	require.Equal(t, int64(3), page.Records[0].Size)
+	require.NotEmpty(t, page.NextCursor)
+
+	status, page = list("?limit=2&order=desc&cursor=" + page.NextCursor)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Records, 1)
+	require.Equal(t, types.Filename("a.txt"), page.Records[0].Filename)
+	require.Empty(t, page.NextCursor)
+
+	status, page = list("?filename_prefix=b&min_size=2&max_size=2")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, page.Records, 1)
+	require.Equal(t, types.ID("bbbbbbbbbb"), page.Records[0].ID)
+
+	for _, query := range []string{
+		"?limit=-1",
+		"?sort=unknown",
+		"?order=sideways",
+		"?created_after=yesterday",
+		"?min_size=10&max_size=5",
+		"?cursor=garbage",
+	} {
+		status, _ := list(query)
+		require.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
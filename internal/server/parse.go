@@ -1,17 +1,42 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
 	"github.com/denisschmidt/uploader/internal/types"
+	"math/big"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	MULTI_PART_MAX_MEMORY = 1048576
 	MAX_NOTE_LEN          = 500
 	MAX_FILE_NAME_LEN     = 255
+	MAX_EXPIRATION_DAYS   = 3650
 	RECORD_ID_LEN         = 10
+	MAX_ATTRIBUTES        = 32
+	MAX_TAGS              = 32
+	MAX_LABEL_LEN         = 64
+	MAX_ATTRIBUTE_LEN     = 256
+	MAX_VERSIONS          = 1000
+	MAX_PATH_LEN          = 1024
+	// ATTRIBUTE_FIELD_PREFIX - the form fields and query parameters `attr.<key>` hold the attributes,
+	// the comma separated `tags` ones hold the tags
+	ATTRIBUTE_FIELD_PREFIX = "attr."
+	TAGS_FIELD             = "tags"
+	// METADATA_FIELD - the JSON part of the upload form with the attributes and tags
+	METADATA_FIELD = "metadata"
+	// FILE_FIELD, NOTE_FIELD and EXPIRATION_FIELD - the parts of the upload form besides the labels
+	FILE_FIELD       = "file"
+	NOTE_FIELD       = "note"
+	EXPIRATION_FIELD = "expires_in_days"
 )
 
 var (
@@ -45,6 +70,47 @@ func validateFileNote(s string) error {
 	return nil
 }
 
+// parseExpirationInDays - empty value means the default expiration from the settings
+func parseExpirationInDays(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("expiration must be a number of days: %v", err)
+	}
+
+	if days < 1 || days > MAX_EXPIRATION_DAYS {
+		return 0, fmt.Errorf("expiration must be between 1 and %d days", MAX_EXPIRATION_DAYS)
+	}
+
+	return days, nil
+}
+
+func validateSettings(settings types.Settings) error {
+	if settings.DefaultExpirationInDays < 0 || settings.DefaultExpirationInDays > MAX_EXPIRATION_DAYS {
+		return fmt.Errorf("default expiration must be between 0 and %d days", MAX_EXPIRATION_DAYS)
+	}
+	if settings.MaxVersions < 0 || settings.MaxVersions > MAX_VERSIONS {
+		return fmt.Errorf("max versions must be between 0 and %d", MAX_VERSIONS)
+	}
+	return nil
+}
+
+// parseVersion - the `version` query parameter, empty is the latest version
+func parseVersion(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("version must be a positive number, got %q", s)
+	}
+	return version, nil
+}
+
 var windowsReservedWords = map[string]bool{
 	"CON": true, "PRN": true, "AUX": true, "NUL": true,
 	"COM1": true, "COM2": true, "COM3": true, "COM4": true,
@@ -76,15 +142,56 @@ func validateFilename(s string) error {
 	return nil
 }
 
+// parseUploadPath - splits the name of the uploaded file into the filename and the directory relative to the upload,
+// the browsers send the relative path when a folder is uploaded. Both separators are accepted,
+// every element of the path must be a valid filename
+func parseUploadPath(name string) (string, string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if len(name) > MAX_PATH_LEN {
+		return "", "", errors.New("path exceeds maximum length")
+	}
+	if strings.HasPrefix(name, "/") {
+		return "", "", errors.New("path must be relative")
+	}
+
+	elements := strings.Split(name, "/")
+	for _, element := range elements {
+		if err := validateFilename(element); err != nil {
+			return "", "", err
+		}
+	}
+
+	last := len(elements) - 1
+	return elements[last], strings.Join(elements[:last], "/"), nil
+}
+
+// recordIdChars - alphabet of the record ID, ambiguous characters (l, I, O, 0, 1) are excluded
+const recordIdChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
 func getAllowCharsMapping() map[rune]bool {
 	charsMapping := map[rune]bool{}
-	for _, r := range []rune("abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789") {
+	for _, r := range []rune(recordIdChars) {
 		charsMapping[r] = true
 	}
 	return charsMapping
 
 }
 
+// generateRecordId - creates a random ID that passes parseRecordId,
+// so the uploaded record can be addressed by the rest of the API
+func generateRecordId() (types.ID, error) {
+	id := make([]byte, RECORD_ID_LEN)
+	max := big.NewInt(int64(len(recordIdChars)))
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return types.ID(""), err
+		}
+		id[i] = recordIdChars[n.Int64()]
+	}
+	return types.ID(id), nil
+}
+
 func parseRecordId(s string) (types.ID, error) {
 	if len(s) != RECORD_ID_LEN {
 		return types.ID(""), fmt.Errorf("ID (%s) has invalid length: got %d, want %d", s, len(s), RECORD_ID_LEN)
@@ -112,9 +219,283 @@ func parseMetadataFromRequest(payload types.MetadataRequest) (types.Metadata, er
 		return types.Metadata{}, err
 	}
 
+	err = validateLabels(payload.Attributes, payload.Tags)
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	return types.Metadata{
+		Filename:   types.Filename(payload.Filename),
+		Note:       types.Note(payload.Note),
+		Attributes: payload.Attributes,
+		Tags:       payload.Tags,
+	}, nil
+
+}
+
+// validateLabel - the attribute keys and the tags are short words of letters, digits and `.-_:`
+func validateLabel(s string) error {
+	if s == "" {
+		return errors.New("attribute keys and tags cannot be empty")
+	}
+	if len(s) > MAX_LABEL_LEN {
+		return fmt.Errorf("%q exceeds maximum length of %d", s, MAX_LABEL_LEN)
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_:", c)) {
+			return fmt.Errorf("%q contains illegal characters", s)
+		}
+	}
+	return nil
+}
+
+func validateLabels(attributes map[string]string, tags []string) error {
+	if len(attributes) > MAX_ATTRIBUTES {
+		return fmt.Errorf("too many attributes: got %d, want at most %d", len(attributes), MAX_ATTRIBUTES)
+	}
+	for key, value := range attributes {
+		if err := validateLabel(key); err != nil {
+			return fmt.Errorf("bad attribute key: %v", err)
+		}
+		if len(value) > MAX_ATTRIBUTE_LEN {
+			return fmt.Errorf("attribute %q exceeds maximum length of %d", key, MAX_ATTRIBUTE_LEN)
+		}
+	}
+
+	if len(tags) > MAX_TAGS {
+		return fmt.Errorf("too many tags: got %d, want at most %d", len(tags), MAX_TAGS)
+	}
+	for _, tag := range tags {
+		if err := validateLabel(tag); err != nil {
+			return fmt.Errorf("bad tag: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// labelsRequest - the JSON `metadata` part of the upload form
+type labelsRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Tags       []string          `json:"tags"`
+}
+
+// parseUploadLabels - the attributes and tags of the upload form, from its JSON `metadata` part
+// sent as a field or a file, and from the label fields which take precedence over it
+func parseUploadLabels(values url.Values) (map[string]string, []string, error) {
+	var payload labelsRequest
+
+	if document, ok := values[METADATA_FIELD]; ok {
+		decoder := json.NewDecoder(strings.NewReader(document[0]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&payload); err != nil {
+			return nil, nil, fmt.Errorf("bad %s part: %v", METADATA_FIELD, err)
+		}
+	}
+
+	attributes, tags, err := parseLabelFields(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, value := range attributes {
+		if payload.Attributes == nil {
+			payload.Attributes = make(map[string]string)
+		}
+		payload.Attributes[key] = value
+	}
+	payload.Tags = append(payload.Tags, tags...)
+
+	if err := validateLabels(payload.Attributes, payload.Tags); err != nil {
+		return nil, nil, err
+	}
+	return payload.Attributes, payload.Tags, nil
+}
+
+// parseLabelFields - reads the `attr.<key>` and the comma separated `tags` fields, nil when there are none
+func parseLabelFields(values url.Values) (map[string]string, []string, error) {
+	var attributes map[string]string
+	var tags []string
+
+	for field, fieldValues := range values {
+		if field == TAGS_FIELD {
+			for _, value := range fieldValues {
+				for _, tag := range strings.Split(value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						tags = append(tags, tag)
+					}
+				}
+			}
+			continue
+		}
+
+		key, ok := strings.CutPrefix(field, ATTRIBUTE_FIELD_PREFIX)
+		if !ok {
+			continue
+		}
+		if len(fieldValues) > 1 {
+			return nil, nil, fmt.Errorf("attribute %q is set more than once", key)
+		}
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		attributes[key] = fieldValues[0]
+	}
+
+	if err := validateLabels(attributes, tags); err != nil {
+		return nil, nil, err
+	}
+	return attributes, tags, nil
+}
+
+// parseUploadMetadata - decodes tus Upload-Metadata header, comma separated list of `key base64(value)` pairs.
+// Known keys are filename (or name), filetype (or type), note, tags and the attr.<key> attributes
+func parseUploadMetadata(header string) (types.Metadata, error) {
+	values := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		if _, ok := values[key]; ok {
+			return types.Metadata{}, fmt.Errorf("duplicate Upload-Metadata key %q", key)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return types.Metadata{}, fmt.Errorf("bad Upload-Metadata value of %q: %v", key, err)
+		}
+		values[key] = string(value)
+	}
+
+	filename := firstNonEmpty(values["filename"], values["name"])
+	if err := validateFilename(filename); err != nil {
+		return types.Metadata{}, err
+	}
+
+	note := values["note"]
+	if err := validateFileNote(note); err != nil {
+		return types.Metadata{}, err
+	}
+
+	fields := url.Values{}
+	for key, value := range values {
+		fields.Set(key, value)
+	}
+	attributes, tags, err := parseLabelFields(fields)
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
 	return types.Metadata{
-		Filename: types.Filename(payload.Filename),
-		Note:     types.Note(payload.Note),
+		Filename:    types.Filename(filename),
+		Note:        types.Note(note),
+		ContentType: types.ContentType(firstNonEmpty(values["filetype"], values["type"])),
+		Attributes:  attributes,
+		Tags:        tags,
 	}, nil
+}
 
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseListOptions - reads the listing filters, pagination and sort order from the query string
+func parseListOptions(query url.Values) (types.ListOptions, error) {
+	options := types.ListOptions{
+		Cursor:         query.Get("cursor"),
+		ContentType:    types.ContentType(query.Get("content_type")),
+		FilenamePrefix: query.Get("filename_prefix"),
+	}
+
+	var err error
+
+	if options.Attributes, options.Tags, err = parseLabelFields(query); err != nil {
+		return types.ListOptions{}, err
+	}
+
+	if batchID := query.Get("batch_id"); batchID != "" {
+		if options.BatchID, err = parseRecordId(batchID); err != nil {
+			return types.ListOptions{}, fmt.Errorf("bad batch ID: %v", err)
+		}
+	}
+
+	if options.Limit, err = parseOptionalInt(query, "limit"); err != nil {
+		return types.ListOptions{}, err
+	}
+	if options.Limit < 0 || options.Limit > store.MaxListLimit {
+		return types.ListOptions{}, fmt.Errorf("limit must be between 1 and %d", store.MaxListLimit)
+	}
+
+	if options.CreatedAfter, err = parseOptionalTime(query, "created_after"); err != nil {
+		return types.ListOptions{}, err
+	}
+	if options.CreatedBefore, err = parseOptionalTime(query, "created_before"); err != nil {
+		return types.ListOptions{}, err
+	}
+
+	minSize, err := parseOptionalInt(query, "min_size")
+	if err != nil {
+		return types.ListOptions{}, err
+	}
+	maxSize, err := parseOptionalInt(query, "max_size")
+	if err != nil {
+		return types.ListOptions{}, err
+	}
+	if minSize < 0 || maxSize < 0 || (maxSize > 0 && minSize > maxSize) {
+		return types.ListOptions{}, errors.New("invalid size range")
+	}
+	options.MinSize = int64(minSize)
+	options.MaxSize = int64(maxSize)
+
+	switch sortBy := types.SortField(query.Get("sort")); sortBy {
+	case "", types.SortByCreateAt, types.SortByFilename, types.SortBySize:
+		options.SortBy = sortBy
+	default:
+		return types.ListOptions{}, fmt.Errorf("unsupported sort field %q", sortBy)
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		return types.ListOptions{}, fmt.Errorf("order must be asc or desc, got %q", order)
+	}
+
+	return options, nil
+}
+
+func parseOptionalInt(query url.Values, key string) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %v", key, err)
+	}
+	return n, nil
+}
+
+func parseOptionalTime(query url.Values, key string) (time.Time, error) {
+	value := query.Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be RFC3339 time: %v", key, err)
+	}
+	return t, nil
 }
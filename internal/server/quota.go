@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+)
+
+// MAX_FORM_OVERHEAD - the room left in the multipart request for the boundaries and the form fields besides the file
+const MAX_FORM_OVERHEAD = 2 * MULTI_PART_MAX_MEMORY
+
+// quota - the limits of the uploads from the config, zero is unlimited.
+// The capacity is checked against the space used when the upload starts, so concurrent uploads may overshoot it a bit
+type quota struct {
+	maxFileSize int64
+	capacity    int64
+}
+
+// usage - the space taken by the records against the limits
+func (h handlers) usage() (types.Usage, error) {
+	stats, err := h.db.Stats()
+	if err != nil {
+		return types.Usage{}, err
+	}
+
+	usage := types.Usage{
+		Records:     stats.Records,
+		UsedBytes:   stats.StoredBytes,
+		Capacity:    h.quota.capacity,
+		Remaining:   -1,
+		MaxFileSize: h.quota.maxFileSize,
+	}
+	if h.quota.capacity > 0 {
+		usage.Remaining = max(h.quota.capacity-stats.StoredBytes, 0)
+	}
+	return usage, nil
+}
+
+// uploadLimit - the number of bytes the upload may take and the error it fails with when it takes more,
+// remaining is the space left in the store. Both are -1 when unlimited
+type uploadLimit struct {
+	n         int64
+	err       error
+	remaining int64
+}
+
+// limitUpload - the limit of the next upload, the store without any space left fails it right away
+func (h handlers) limitUpload() (uploadLimit, error) {
+	limit := uploadLimit{n: -1, remaining: -1}
+
+	if h.quota.maxFileSize > 0 {
+		limit.n = h.quota.maxFileSize
+		limit.err = types.ErrFileTooLarge{Limit: h.quota.maxFileSize}
+	}
+
+	if h.quota.capacity > 0 {
+		usage, err := h.usage()
+		if err != nil {
+			return uploadLimit{}, &dbError{err}
+		}
+
+		exceeded := types.ErrCapacityExceeded{Capacity: h.quota.capacity}
+		if usage.Remaining == 0 {
+			return uploadLimit{}, exceeded
+		}
+		limit.remaining = usage.Remaining
+		if limit.n < 0 || usage.Remaining < limit.n {
+			limit.n = usage.Remaining
+			limit.err = exceeded
+		}
+	}
+
+	return limit, nil
+}
+
+// check - fails the upload of the size known up front when it is beyond the limit
+func (l uploadLimit) check(size int64) error {
+	if l.n >= 0 && size > l.n {
+		return l.err
+	}
+	return nil
+}
+
+// reader - the reader failing once the content is beyond the limit
+func (l uploadLimit) reader(r io.Reader) io.Reader {
+	if l.n < 0 {
+		return r
+	}
+	return &limitedReader{r: r, n: l.n, err: l.err}
+}
+
+// checkRequest - fails the multipart request declaring the body which doesn't fit into the space left in the store,
+// so it is rejected before the body is read. The request may carry many files, their sizes are checked as they are read
+func (h handlers) checkRequest(r *http.Request, limit uploadLimit) error {
+	if limit.remaining >= 0 && r.ContentLength > limit.remaining+MAX_FORM_OVERHEAD {
+		return types.ErrCapacityExceeded{Capacity: h.quota.capacity}
+	}
+	return nil
+}
+
+// limitedReader reads up to n bytes and fails with err on the following ones
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	err      error
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, l.err
+	}
+
+	// one more byte than allowed tells that the content is beyond the limit
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		l.exceeded = true
+		return int(l.n), l.err
+	}
+
+	l.n -= int64(n)
+	return n, err
+}
+
+// quotaStatus - the response status of the upload rejected by the limits, 0 for the other errors
+func quotaStatus(err error) int {
+	var tooLarge types.ErrFileTooLarge
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	var exceeded types.ErrCapacityExceeded
+	if errors.As(err, &exceeded) {
+		return http.StatusInsufficientStorage
+	}
+
+	return 0
+}
+
+// usageGet - the space taken by the records and the limits of the uploads
+func (h handlers) usageGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usage, err := h.usage()
+		if err != nil {
+			log.Printf("failed to read usage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read usage: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, usage)
+	}
+}
@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadLimits(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	defaultConfig.MaxFileSize = 10
+	defaultConfig.StorageCapacity = 25
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	post := func(path string, method string, content string) *httptest.ResponseRecorder {
+		formData, contentType := createMultipartFormBody("test.txt", "", strings.NewReader(content))
+		req, err := http.NewRequest(method, path, formData)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		return serve(req)
+	}
+
+	usage := func() types.Usage {
+		req, err := http.NewRequest("GET", "/api/usage", nil)
+		require.NoError(t, err)
+		rec := serve(req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var usage types.Usage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &usage))
+		return usage
+	}
+
+	require.Equal(t, types.Usage{Capacity: 25, Remaining: 25, MaxFileSize: 10}, usage())
+
+	rec := post("/api/file", "POST", "01234567890")
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Contains(t, rec.Body.String(), "maximum size of 10 bytes")
+
+	// the declared length beyond the space left is rejected before the body is read
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	f, err := mw.CreateFormFile("file", "large.bin")
+	require.NoError(t, err)
+	f.Write(bytes.Repeat([]byte("x"), server.MAX_FORM_OVERHEAD+11))
+	require.NoError(t, mw.Close())
+	req, err := http.NewRequest("POST", "/api/file", &b)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	require.Equal(t, http.StatusInsufficientStorage, serve(req).Code)
+
+	require.Equal(t, http.StatusOK, post("/api/file", "POST", "0123456789").Code)
+	require.Equal(t, types.Usage{Records: 1, UsedBytes: 10, Capacity: 25, Remaining: 15, MaxFileSize: 10}, usage())
+
+	err = database.InsertRecord(bytes.NewBufferString("0123456789"), types.Metadata{
+		ID:       "abcdefghij",
+		Filename: "notes.txt",
+		CreateAt: time.Now(),
+	})
+	require.NoError(t, err)
+
+	// the earlier versions take the space as well
+	require.Equal(t, http.StatusInsufficientStorage, post("/api/file/abcdefghij/content", "PUT", "0123456").Code)
+	require.Equal(t, http.StatusOK, post("/api/file/abcdefghij/content", "PUT", "01234").Code)
+	require.Equal(t, types.Usage{Records: 2, UsedBytes: 25, Capacity: 25, Remaining: 0, MaxFileSize: 10}, usage())
+
+	rec = post("/api/file", "POST", "0")
+	require.Equal(t, http.StatusInsufficientStorage, rec.Code)
+	require.Contains(t, rec.Body.String(), "capacity of 25 bytes is exceeded")
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Length": "1",
+	})
+	require.Equal(t, http.StatusInsufficientStorage, rec.Code)
+}
+
+func TestBatchUploadLimits(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	defaultConfig.MaxFileSize = 10
+	defaultConfig.StorageCapacity = 25
+
+	s, err := server.New(defaultConfig, memory.New(), fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	upload := func(parts ...formPart) (*httptest.ResponseRecorder, types.UploadResponse) {
+		body, contentType := createMultipartForm(t, parts...)
+		req, err := http.NewRequest("POST", "/api/file", body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		var response types.UploadResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return rec, response
+	}
+
+	// the oversized file fails on its own
+	rec, response := upload(
+		formPart{name: "file", filename: "small.txt", value: "01234"},
+		formPart{name: "file", filename: "large.txt", value: "01234567890"},
+	)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, response.Files, 2)
+	require.NotEmpty(t, response.Files[0].ID)
+	require.Equal(t, "File exceeds the maximum size of 10 bytes", response.Files[1].Error)
+
+	rec, response = upload(formPart{name: "file", filename: "large.txt", value: "01234567890"})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Equal(t, "File exceeds the maximum size of 10 bytes", response.Files[0].Error)
+
+	// the files beyond the space left fail on their own too
+	rec, response = upload(
+		formPart{name: "file", filename: "a.txt", value: "0123456789"},
+		formPart{name: "file", filename: "b.txt", value: "0123456789"},
+		formPart{name: "file", filename: "c.txt", value: "0123456789"},
+	)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotEmpty(t, response.Files[0].ID)
+	require.NotEmpty(t, response.Files[1].ID)
+	require.Equal(t, "Store capacity of 25 bytes is exceeded", response.Files[2].Error)
+
+	// the full store rejects the batch before the form is read
+	rec, _ = upload(formPart{name: "file", filename: "d.txt", value: "0123456789"})
+	require.Equal(t, http.StatusInsufficientStorage, rec.Code)
+	require.Contains(t, rec.Body.String(), "capacity of 25 bytes is exceeded")
+}
+
+func TestTusMaxSize(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	defaultConfig.MaxFileSize = 10
+
+	s, err := server.New(defaultConfig, memory.New(), fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	rec := tusRequest(t, s, "OPTIONS", "/api/upload", nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "10", rec.Header().Get("Tus-Max-Size"))
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Length": "11",
+	})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	createTusUpload(t, s, 10, "limit.txt")
+}
+
+func TestNegativeLimits(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.StorageCapacity = -1
+
+	_, err := server.New(defaultConfig, memory.New(), fake_auth.FakeAuth{})
+	require.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// searcher - the stores with a full-text search over the filenames, notes and text contents
+type searcher interface {
+	Search(query string, limit int) ([]types.SearchResult, error)
+}
+
+// searchGet - the records matching ?q= the best match first, ?limit= caps the number of results
+func (h handlers) searchGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, ok := h.db.(searcher)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "The store doesn't support search"})
+			return
+		}
+
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: q is required"})
+			return
+		}
+
+		limit, err := parseOptionalInt(c.Request.URL.Query(), "limit")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Bad request: %v", err)})
+			return
+		}
+		if limit < 0 || limit > store.MaxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Bad request: limit must be between 1 and %d", store.MaxSearchLimit)})
+			return
+		}
+
+		results, err := s.Search(query, limit)
+		if err != nil {
+			if _, ok := err.(types.ErrSearchUnavailable); ok {
+				c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+				return
+			}
+			log.Printf("failed to search records: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to search records: %v", err)})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.SearchResults{Results: results})
+	}
+}
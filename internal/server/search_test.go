@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchRecords(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	for _, record := range []struct {
+		metadata types.Metadata
+		content  string
+	}{
+		{types.Metadata{ID: "aaaaaaaaaa", Filename: "server.log", ContentType: "text/plain"}, "panic: connection refused by upstream"},
+		{types.Metadata{ID: "bbbbbbbbbb", Filename: "connection.json", ContentType: "application/json"}, "{}"},
+		{types.Metadata{ID: "cccccccccc", Filename: "dump.bin", ContentType: "application/octet-stream"}, "connection refused"},
+	} {
+		record.metadata.CreateAt = time.Now()
+		require.NoError(t, database.InsertRecord(strings.NewReader(record.content), record.metadata))
+	}
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	search := func(query string) (int, types.SearchResults) {
+		req, err := http.NewRequest("GET", "/api/search"+query, nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		var results types.SearchResults
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		}
+		return rec.Code, results
+	}
+
+	status, results := search("?q=connection")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, results.Results, 2)
+	require.Equal(t, types.ID("bbbbbbbbbb"), results.Results[0].ID)
+	require.Equal(t, "<mark>connection</mark>.json", results.Results[0].Snippet)
+	require.Equal(t, types.ID("aaaaaaaaaa"), results.Results[1].ID)
+	require.Equal(t, "panic: <mark>connection</mark> refused by upstream", results.Results[1].Snippet)
+
+	status, results = search("?q=connection&limit=1")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, results.Results, 1)
+
+	status, results = search("?q=missing")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, results.Results)
+
+	for _, query := range []string{"", "?q=%20", "?q=x&limit=1000", "?q=x&limit=many"} {
+		status, _ := search(query)
+		require.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
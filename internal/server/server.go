@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"github.com/denisschmidt/uploader/config"
 	"github.com/denisschmidt/uploader/internal/auth"
 	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/archive"
 	"github.com/denisschmidt/uploader/internal/store/db"
+	"github.com/denisschmidt/uploader/internal/store/db/blob"
+	"github.com/denisschmidt/uploader/internal/store/db/file"
 	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -36,14 +43,324 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func initDatabase(cfg *config.Config) (store.Store, error) {
+	// the reaper runs in its own goroutine, a bad config would crash the server from there
+	if cfg.ReaperInterval <= 0 {
+		return nil, fmt.Errorf("reaper_interval must be positive, got %v", cfg.ReaperInterval)
+	}
+	if cfg.ReaperBatchSize <= 0 {
+		return nil, fmt.Errorf("reaper_batch_size must be positive, got %d", cfg.ReaperBatchSize)
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	database.StartReaper(cfg.ReaperInterval, cfg.ReaperBatchSize, cfg.TrashRetention)
+
+	return database, nil
+}
+
+// openDatabase - opens the SQLite store with the storage options from the config
+func openDatabase(cfg *config.Config) (*db.DB, error) {
+	compression, err := file.ParseCodec(cfg.DBCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey, err := loadMasterKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DBDedup && masterKey != nil {
+		return nil, fmt.Errorf("db_dedup can't be enabled together with the encryption")
+	}
+
+	blobs, err := openBlobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if blobs != nil && (cfg.DBDedup || compression != file.CodecNone || masterKey != nil) {
+		return nil, fmt.Errorf("db_dedup, db_compression and the encryption apply to the %q storage only", config.StorageSQLite)
+	}
+
 	if _, err := os.Stat(filepath.Dir(cfg.DBPath)); os.IsNotExist(err) {
 		if err := os.Mkdir(filepath.Dir(cfg.DBPath), os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
-	database := db.New(cfg.DBPath, cfg.DBChunkSize, true)
+	return db.NewWithOptions(cfg.DBPath, db.Options{
+		ChunkSize:             cfg.DBChunkSize,
+		OptimizeForLiteStream: true,
+		Dedup:                 cfg.DBDedup,
+		Compression:           compression,
+		MasterKey:             masterKey,
+		Blobs:                 blobs,
+	})
+}
 
-	return database, nil
+// openBlobs - the blob store keeping the content of the records, nil when it is kept in SQLite
+func openBlobs(cfg *config.Config) (blob.Store, error) {
+	switch cfg.Storage {
+	case "", config.StorageSQLite:
+		return nil, nil
+	case config.StorageFS:
+		return blob.NewFS(cfg.StoragePath)
+	case config.StorageS3:
+		return openS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported storage %q", cfg.Storage)
+	}
+}
+
+// openS3 - the S3 store, the bucket must already exist
+func openS3(options config.S3Options) (blob.Store, error) {
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("s3.bucket must be set for the %q storage", config.StorageS3)
+	}
+	if options.PartSize < blob.MinS3PartSize {
+		return nil, fmt.Errorf("s3.part_size must be at least %d bytes", blob.MinS3PartSize)
+	}
+
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
+		Secure: options.UseSSL,
+		Region: options.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), options.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("s3 bucket %q doesn't exist", options.Bucket)
+	}
+
+	return blob.NewS3(client, blob.S3Options{
+		Bucket:   options.Bucket,
+		Prefix:   options.Prefix,
+		PartSize: options.PartSize,
+	}), nil
+}
+
+// loadMasterKey - the master key from the config or the key file, nil when the encryption is disabled
+func loadMasterKey(cfg *config.Config) (*db.MasterKey, error) {
+	switch {
+	case cfg.EncryptionKey != "" && cfg.EncryptionKeyFile != "":
+		return nil, fmt.Errorf("only one of encryption_key and encryption_key_file can be set")
+	case cfg.EncryptionKey != "":
+		return db.ParseMasterKey(cfg.EncryptionKey)
+	case cfg.EncryptionKeyFile != "":
+		return db.ReadMasterKeyFile(cfg.EncryptionKeyFile)
+	default:
+		return nil, nil
+	}
+}
+
+// RotateMasterKey re-wraps the data keys of the database from the config with the master key from newKeyPath.
+// The config must be switched to the new key once it succeeds
+func RotateMasterKey(path string, newKeyPath string) (int, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return 0, err
+	}
+
+	newKey, err := db.ReadMasterKeyFile(newKeyPath)
+	if err != nil {
+		return 0, err
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer database.Close()
+
+	return database.RotateMasterKey(newKey)
+}
+
+// Fsck verifies every record of the database from the config, see db.DB.Fsck
+func Fsck(path string, quarantine bool) (db.FsckReport, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return db.FsckReport{}, err
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return db.FsckReport{}, err
+	}
+	defer database.Close()
+
+	return database.Fsck(quarantine)
+}
+
+// Backup writes the snapshot of the database from the config to output, "-" is the standard output.
+// It only reads the database, so the server can keep running
+func Backup(path string, output string, gzipped bool) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		return db.Backup(cfg.DBPath, os.Stdout, gzipped)
+	}
+
+	// the backup appears under its name only once it is complete
+	tmp := output + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := db.Backup(cfg.DBPath, f, gzipped); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, output)
+}
+
+// Restore replaces the database from the config with the snapshot from input, see db.Restore.
+// An existing database is replaced only with force
+func Restore(path string, input string, force bool) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(cfg.DBPath); err == nil && !force {
+		return fmt.Errorf("database %s already exists, stop the server and use --force to replace it", cfg.DBPath)
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	return db.Restore(f, cfg.DBPath)
+}
+
+// Export writes the records of the database from the config to output as a tar archive, "-" is the standard output
+func Export(path string, output string) (int, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return 0, err
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer database.Close()
+
+	if output == "-" {
+		return archive.Export(database, os.Stdout)
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, err
+	}
+
+	exported, err := archive.Export(database, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return exported, err
+}
+
+// Import inserts the records from the tar archive at input into the database from the config, "-" is the standard input.
+// The renamed records get new random IDs, so the API can address them
+func Import(path string, input string, conflict string) (archive.ImportReport, error) {
+	policy, err := archive.ParseConflict(conflict)
+	if err != nil {
+		return archive.ImportReport{}, err
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return archive.ImportReport{}, err
+	}
+
+	in := os.Stdin
+	if input != "-" {
+		if in, err = os.Open(input); err != nil {
+			return archive.ImportReport{}, err
+		}
+		defer in.Close()
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return archive.ImportReport{}, err
+	}
+	defer database.Close()
+
+	return archive.Import(database, in, archive.ImportOptions{
+		Conflict: policy,
+		NewID:    generateRecordId,
+	})
+}
+
+// MigrationStatus reports the version of the database and the migrations known to this build
+func MigrationStatus(path string) (db.MigrationStatus, error) {
+	migrator, err := openMigrator(path, false)
+	if err != nil {
+		return db.MigrationStatus{}, err
+	}
+	defer migrator.Close()
+
+	return migrator.Status()
+}
+
+// Migrate moves the database up or down to the target version, 0 migrates up to the latest one.
+// The dry run reports the steps after checking that they apply, and leaves the database as it was.
+// The down steps deleting any rows are applied only when forced
+func Migrate(path string, down bool, target int, dryRun bool, force bool) ([]db.MigrationStep, error) {
+	// only migrating up can create the database
+	migrator, err := openMigrator(path, !down && !dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer migrator.Close()
+
+	if down {
+		return migrator.Down(target, dryRun, force)
+	}
+	return migrator.Up(target, dryRun)
+}
+
+func openMigrator(path string, create bool) (*db.Migrator, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(cfg.DBPath); os.IsNotExist(err) {
+		if !create {
+			return nil, fmt.Errorf("database %s doesn't exist", cfg.DBPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.DBPath), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.OpenMigrator(cfg.DBPath)
 }
 
 func Run(path string) error {
@@ -1,13 +1,16 @@
 package server_test
 
 import (
+	"fmt"
 	"github.com/denisschmidt/uploader/config"
 	"github.com/denisschmidt/uploader/internal/auth"
 	"github.com/denisschmidt/uploader/internal/server"
-	"github.com/denisschmidt/uploader/internal/store/db/fake_db"
+	"github.com/denisschmidt/uploader/internal/store/memory"
 	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -28,10 +31,9 @@ func TestServer(t *testing.T) {
 
 func setupTest(t *testing.T) (*server.Server, func()) {
 	t.Helper()
-	chunkSize := 5
 	defaultConfig := config.DefaultConfig()
 	defaultConfig.SecretKey = "hello"
-	database := fake_db.NewSqlWithChunk(chunkSize)
+	database := memory.New()
 	authenticator, err := auth.New(defaultConfig.SecretKey)
 	s, err := server.New(defaultConfig, database, &authenticator)
 	require.NoError(t, err)
@@ -80,3 +82,23 @@ func testBadRequestAuthorized(t *testing.T, s *server.Server) {
 	status := w.Code
 	require.Equal(t, status, http.StatusBadRequest)
 }
+
+func TestReaperConfig(t *testing.T) {
+	for _, settings := range []string{
+		`"reaper_interval": "0s"`,
+		`"reaper_interval": "-1m"`,
+		`"reaper_batch_size": 0`,
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.json")
+		content := fmt.Sprintf(`{"secret_key": "hello", "dbPath": %q, %s}`, filepath.Join(dir, "uploader.db"), settings)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		// the server refuses to start instead of crashing in the reaper
+		err := server.Run(path)
+		require.ErrorContains(t, err, "must be positive", settings)
+
+		_, err = os.Stat(filepath.Join(dir, "uploader.db"))
+		require.True(t, os.IsNotExist(err), settings)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+func (h handlers) settingsGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := h.db.GetSettings()
+		if err != nil {
+			log.Printf("failed to read settings: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read settings: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+func (h handlers) settingsPut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request types.SettingsRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+
+		settings, err := h.db.GetSettings()
+		if err != nil {
+			log.Printf("failed to read settings: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read settings: %v", err),
+			})
+			return
+		}
+
+		if request.DefaultExpirationInDays != nil {
+			settings.DefaultExpirationInDays = *request.DefaultExpirationInDays
+		}
+		if request.MaxVersions != nil {
+			settings.MaxVersions = *request.MaxVersions
+		}
+
+		if err := validateSettings(settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+
+		if err := h.db.UpdateSettings(settings); err != nil {
+			log.Printf("failed to update settings: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to update settings: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
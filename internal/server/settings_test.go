@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSettings(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	getSettings := func() types.Settings {
+		req, err := http.NewRequest("GET", "/api/settings", nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var settings types.Settings
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &settings))
+		return settings
+	}
+
+	require.Equal(t, 30, getSettings().DefaultExpirationInDays)
+
+	for _, row := range []struct {
+		description string
+		body        string
+		status      int
+		days        int
+	}{
+		{
+			description: "update default expiration",
+			body:        `{"default_expiration_in_days": 7}`,
+			status:      http.StatusOK,
+			days:        7,
+		},
+		{
+			description: "disable expiration",
+			body:        `{"default_expiration_in_days": 0}`,
+			status:      http.StatusOK,
+			days:        0,
+		},
+		{
+			description: "negative expiration",
+			body:        `{"default_expiration_in_days": -1}`,
+			status:      http.StatusBadRequest,
+			days:        0,
+		},
+		{
+			description: "malformed body",
+			body:        `{"default_expiration_in_days": "x"}`,
+			status:      http.StatusBadRequest,
+			days:        0,
+		},
+	} {
+		req, err := http.NewRequest("PUT", "/api/settings", strings.NewReader(row.body))
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		require.Equal(t, row.status, rec.Code, row.description)
+
+		require.Equal(t, row.days, getSettings().DefaultExpirationInDays, row.description)
+	}
+}
+
+func TestPartialSettings(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	s, err := server.New(defaultConfig, memory.New(), fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	putSettings := func(body string) types.Settings {
+		req, err := http.NewRequest("PUT", "/api/settings", strings.NewReader(body))
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var settings types.Settings
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &settings))
+		return settings
+	}
+
+	require.Equal(t, types.Settings{DefaultExpirationInDays: 7, MaxVersions: 3}, putSettings(`{"default_expiration_in_days": 7, "max_versions": 3}`))
+
+	// the settings left out keep their values
+	require.Equal(t, types.Settings{DefaultExpirationInDays: 14, MaxVersions: 3}, putSettings(`{"default_expiration_in_days": 14}`))
+	require.Equal(t, types.Settings{DefaultExpirationInDays: 14, MaxVersions: 0}, putSettings(`{"max_versions": 0}`))
+	require.Equal(t, types.Settings{DefaultExpirationInDays: 14, MaxVersions: 0}, putSettings(`{}`))
+}
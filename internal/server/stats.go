@@ -1,6 +1,9 @@
 package server
 
 import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/stats"
+	"github.com/denisschmidt/uploader/internal/types"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"runtime"
@@ -29,3 +32,24 @@ func (h handlers) sysStats() gin.HandlerFunc {
 		})
 	}
 }
+
+type storeStatsResponse struct {
+	*stats.StatisticData
+	Store types.StoreStats `json:"store"`
+}
+
+// storeStats - the request statistics together with the space used by the records
+func (h handlers) storeStats(stat *stats.Statistic) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeStats, err := h.db.Stats()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read store stats: %v", err)})
+			return
+		}
+
+		c.JSON(http.StatusOK, storeStatsResponse{
+			StatisticData: stat.GatherData(),
+			Store:         storeStats,
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"time"
+)
+
+// trashList - the deleted records which are not purged yet, takes the same query as filesList
+func (h handlers) trashList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, err := parseListOptions(c.Request.URL.Query())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+		options.Trashed = true
+
+		page, err := h.db.ListRecords(options)
+		if err != nil {
+			if _, ok := err.(types.ErrInvalidCursor); ok {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Bad request: %v", err),
+				})
+				return
+			}
+			log.Printf("failed to list trashed records: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to list trashed records: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func (h handlers) trashRestore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad record ID: %v", err),
+			})
+			return
+		}
+
+		if err := h.db.RestoreRecord(id); err != nil {
+			if _, ok := err.(types.ErrFileNotExists); ok {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found in trash ID: %v", id),
+				})
+				return
+			}
+			log.Printf("failed to restore record %v: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to restore record %v: %v", id, err),
+			})
+			return
+		}
+
+		metadata, err := h.db.GetMetadata(id)
+		if err != nil {
+			if _, ok := err.(types.ErrFileNotExists); ok {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found ID: %v", id),
+				})
+				return
+			}
+			log.Printf("failed to read restored record %v: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read restored record %v: %v", id, err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, metadata)
+	}
+}
+
+func (h handlers) trashDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad record ID: %v", err),
+			})
+			return
+		}
+
+		if err := h.db.PurgeRecord(id); err != nil {
+			if _, ok := err.(types.ErrFileNotExists); ok {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found in trash ID: %v", id),
+				})
+				return
+			}
+			log.Printf("failed to purge record %v: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to purge record %v: %v", id, err),
+			})
+		}
+	}
+}
+
+// trashEmpty - purges every record in the trash regardless of the retention
+func (h handlers) trashEmpty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		purged, err := h.db.PurgeTrash(time.Now())
+		if err != nil {
+			log.Printf("failed to empty the trash: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to empty the trash: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.TrashPurgeResponse{
+			Purged: purged,
+		})
+	}
+}
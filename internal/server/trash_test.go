@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	for _, id := range []types.ID{"abcdefghij", "kmnopqrstu"} {
+		err = database.InsertRecord(bytes.NewBufferString("content"), types.Metadata{
+			ID:          id,
+			Filename:    "notes.txt",
+			ContentType: "text/plain",
+			CreateAt:    time.Now(),
+		})
+		require.NoError(t, err)
+	}
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	trashed := func() []types.ID {
+		rec := serve("GET", "/api/trash")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var page types.RecordsPage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		ids := []types.ID{}
+		for _, metadata := range page.Records {
+			require.False(t, metadata.DeletedAt.IsZero())
+			ids = append(ids, metadata.ID)
+		}
+		return ids
+	}
+
+	require.Equal(t, []types.ID{}, trashed())
+
+	rec := serve("DELETE", "/api/file/abcdefghij")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, http.StatusNotFound, serve("GET", "/api/file/abcdefghij").Code)
+	require.Equal(t, []types.ID{"abcdefghij"}, trashed())
+
+	// only the trashed records are restored and purged
+	require.Equal(t, http.StatusNotFound, serve("POST", "/api/trash/kmnopqrstu/restore").Code)
+	require.Equal(t, http.StatusNotFound, serve("DELETE", "/api/trash/kmnopqrstu").Code)
+
+	rec = serve("POST", "/api/trash/abcdefghij/restore")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var metadata types.Metadata
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metadata))
+	require.Equal(t, types.ID("abcdefghij"), metadata.ID)
+	require.True(t, metadata.DeletedAt.IsZero())
+
+	rec = serve("GET", "/api/file/abcdefghij")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "content", rec.Body.String())
+	require.Equal(t, []types.ID{}, trashed())
+
+	require.Equal(t, http.StatusOK, serve("DELETE", "/api/file/abcdefghij").Code)
+	require.Equal(t, http.StatusOK, serve("DELETE", "/api/trash/abcdefghij").Code)
+	require.Equal(t, http.StatusNotFound, serve("POST", "/api/trash/abcdefghij/restore").Code)
+
+	require.Equal(t, http.StatusOK, serve("DELETE", "/api/file/kmnopqrstu").Code)
+	rec = serve("DELETE", "/api/trash")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var purged types.TrashPurgeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &purged))
+	require.Equal(t, types.TrashPurgeResponse{Purged: 1}, purged)
+	require.Equal(t, []types.ID{}, trashed())
+}
+
+// purgeOnRestore purges the record right after it is restored as a concurrent delete and purge would
+type purgeOnRestore struct {
+	*memory.Store
+}
+
+func (s purgeOnRestore) RestoreRecord(id types.ID) error {
+	if err := s.Store.RestoreRecord(id); err != nil {
+		return err
+	}
+	if err := s.Store.DeleteRecord(id); err != nil {
+		return err
+	}
+	return s.Store.PurgeRecord(id)
+}
+
+func TestRestorePurgedRecord(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := purgeOnRestore{Store: memory.New()}
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	err = database.InsertRecord(bytes.NewBufferString("content"), types.Metadata{
+		ID:          "abcdefghij",
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		CreateAt:    time.Now(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.DeleteRecord("abcdefghij"))
+
+	req, err := http.NewRequest("POST", "/api/trash/abcdefghij/restore", nil)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Resumable uploads, see https://tus.io/protocols/resumable-upload
+// Implemented: core protocol, creation, termination and expiration extensions
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination,expiration"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// tusResumable - every request except OPTIONS must be made with the supported protocol version
+func (h handlers) tusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{
+				"error": fmt.Sprintf("unsupported Tus-Resumable version: %q", c.GetHeader("Tus-Resumable")),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h handlers) uploadOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", tusExtensions)
+		if h.quota.maxFileSize > 0 {
+			c.Header("Tus-Max-Size", strconv.FormatInt(h.quota.maxFileSize, 10))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// uploadPost - creation extension, registers a new upload of Upload-Length bytes
+func (h handlers) uploadPost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Upload-Defer-Length") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Upload-Defer-Length is not supported",
+			})
+			return
+		}
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad Upload-Length: %q", c.GetHeader("Upload-Length")),
+			})
+			return
+		}
+
+		// the upload is checked against the limits once, when it is created
+		limit, err := h.limitUpload()
+		if err == nil {
+			err = limit.check(length)
+		}
+		if err != nil {
+			if status := quotaStatus(err); status != 0 {
+				c.JSON(status, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			log.Printf("failed to check upload limits: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Bad request: %v", err),
+			})
+			return
+		}
+
+		id, err := generateRecordId()
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		metadata.ID = id
+		metadata.CreateAt = now
+
+		upload := types.Upload{
+			Metadata:  metadata,
+			Length:    length,
+			ExpiresAt: now.Add(h.uploadExpiration),
+		}
+
+		if err := h.db.CreateUpload(upload); err != nil {
+			log.Printf("failed to create upload: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		// the empty file is complete right away, the client sends no PATCH for it
+		if length == 0 {
+			if _, err := h.db.WriteUpload(id, 0, http.NoBody); err != nil {
+				log.Printf("failed to complete empty upload %v: %v", id, err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		c.Header("Location", "/api/upload/"+string(id))
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		c.Status(http.StatusCreated)
+	}
+}
+
+func (h handlers) uploadHead() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		upload, err := h.db.GetUpload(id)
+		if err != nil {
+			abortWithUploadError(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if !upload.ExpiresAt.IsZero() {
+			c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+// uploadPatch - appends the request body to the upload starting from Upload-Offset
+func (h handlers) uploadPatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if c.ContentType() != tusContentType {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"error": fmt.Sprintf("Content-Type must be %s", tusContentType),
+			})
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad Upload-Offset: %q", c.GetHeader("Upload-Offset")),
+			})
+			return
+		}
+
+		upload, err := h.db.GetUpload(id)
+		if err != nil {
+			abortWithUploadError(c, err)
+			return
+		}
+
+		if c.Request.ContentLength > 0 && offset+c.Request.ContentLength > upload.Length {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "request body exceeds Upload-Length",
+			})
+			return
+		}
+
+		upload, err = h.db.WriteUpload(id, offset, c.Request.Body)
+		if err != nil {
+			log.Printf("failed to write upload %v: %v", id, err)
+			abortWithUploadError(c, err)
+			return
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		if upload.Offset < upload.Length {
+			c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// uploadDelete - termination extension, drops unfinished upload together with received data
+func (h handlers) uploadDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if err := h.db.DeleteUpload(id); err != nil {
+			abortWithUploadError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func abortWithUploadError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+
+	switch err.(type) {
+	case types.ErrUploadNotExists:
+		status = http.StatusNotFound
+	case types.ErrUploadOffsetMismatch:
+		status = http.StatusConflict
+	case types.ErrUploadLocked:
+		status = http.StatusLocked
+	}
+
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
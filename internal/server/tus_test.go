@@ -0,0 +1,192 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func setupTusTest(t *testing.T) *server.Server {
+	t.Helper()
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+	return s
+}
+
+func tusRequest(t *testing.T, s *server.Server, method, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, body)
+	require.NoError(t, err)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func createTusUpload(t *testing.T, s *server.Server, length int, filename string) string {
+	t.Helper()
+	rec := tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain")),
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Upload-Expires"))
+
+	location := rec.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/api/upload/"))
+	return location
+}
+
+func TestTusUpload(t *testing.T) {
+	s := setupTusTest(t)
+	contents := "resumable upload content"
+
+	location := createTusUpload(t, s, len(contents), "resumable.txt")
+
+	rec := tusRequest(t, s, "HEAD", location, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+	require.Equal(t, strconv.Itoa(len(contents)), rec.Header().Get("Upload-Length"))
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	patch := func(offset int, data string) *httptest.ResponseRecorder {
+		return tusRequest(t, s, "PATCH", location, strings.NewReader(data), map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	// the first part ends in the middle of a chunk
+	rec = patch(0, contents[:10])
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "10", rec.Header().Get("Upload-Offset"))
+
+	rec = patch(5, contents[5:])
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = tusRequest(t, s, "PATCH", location, strings.NewReader(contents[10:]), map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"Upload-Offset": "10",
+	})
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	rec = tusRequest(t, s, "HEAD", location, nil, nil)
+	require.Equal(t, "10", rec.Header().Get("Upload-Offset"))
+
+	rec = patch(10, contents[10:])
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, strconv.Itoa(len(contents)), rec.Header().Get("Upload-Offset"))
+
+	// the completed upload is still reported, so the client doesn't start over
+	rec = tusRequest(t, s, "HEAD", location, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, strconv.Itoa(len(contents)), rec.Header().Get("Upload-Offset"))
+
+	id := strings.TrimPrefix(location, "/api/upload/")
+	req, err := http.NewRequest("GET", "/api/file/"+id, nil)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, contents, rec.Body.String())
+	require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	require.Equal(t, "attachment; filename=resumable.txt", rec.Header().Get("Content-Disposition"))
+
+	// the digest is carried over the interrupted parts
+	digest := sha256.Sum256([]byte(contents))
+	require.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(digest[:]), rec.Header().Get("Digest"))
+}
+
+func TestTusTermination(t *testing.T) {
+	s := setupTusTest(t)
+
+	location := createTusUpload(t, s, 10, "terminated.txt")
+
+	rec := tusRequest(t, s, "PATCH", location, strings.NewReader("12345"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = tusRequest(t, s, "DELETE", location, nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = tusRequest(t, s, "HEAD", location, nil, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = tusRequest(t, s, "DELETE", location, nil, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestTusEmptyUpload(t *testing.T) {
+	s := setupTusTest(t)
+
+	// the empty file is complete as soon as it is created
+	location := createTusUpload(t, s, 0, "empty.txt")
+	rec := tusRequest(t, s, "HEAD", location, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+	require.Equal(t, "0", rec.Header().Get("Upload-Length"))
+
+	req, err := http.NewRequest("GET", "/api/file/"+strings.TrimPrefix(location, "/api/upload/"), nil)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Body.String())
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Length": "-1",
+	})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTusProtocolErrors(t *testing.T) {
+	s := setupTusTest(t)
+
+	rec := tusRequest(t, s, "OPTIONS", "/api/upload", nil, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "1.0.0", rec.Header().Get("Tus-Version"))
+	require.Equal(t, "creation,termination,expiration", rec.Header().Get("Tus-Extension"))
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Tus-Resumable": "0.2.2",
+		"Upload-Length": "10",
+	})
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, "1.0.0", rec.Header().Get("Tus-Version"))
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("..")),
+	})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = tusRequest(t, s, "POST", "/api/upload", nil, map[string]string{
+		"Upload-Defer-Length": "1",
+	})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	location := createTusUpload(t, s, 4, "small.txt")
+	rec = tusRequest(t, s, "PATCH", location, strings.NewReader("123456"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -5,31 +5,42 @@ import (
 	"fmt"
 	"github.com/denisschmidt/uploader/internal/types"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"time"
 )
 
+// filePost - stores every file of the form as a record of a new batch. The upload succeeds when any of the files
+// is stored, the files failing on their own are reported in their results
 func (h handlers) filePost() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id, err := h.insertFileFromRequest(c.Request)
+		response, err := h.insertFilesFromRequest(c.Request)
 		if err != nil {
 			var de *dbError
-			if errors.As(err, &de) {
+			status := quotaStatus(err)
+			switch {
+			case status != 0:
+			case errors.As(err, &de):
 				log.Printf("failed to insert uploaded file into data store: %v", err)
-				c.AbortWithStatus(http.StatusInternalServerError)
-			} else {
+				status = http.StatusInternalServerError
+			default:
 				log.Printf("invalid upload: %v", err)
-				c.AbortWithStatus(http.StatusBadRequest)
+				status = http.StatusBadRequest
+			}
+
+			body := gin.H{
+				"error": err.Error(),
+			}
+			if len(response.Files) > 0 {
+				body["files"] = response.Files
 			}
+			c.JSON(status, body)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.JSON(http.StatusOK, gin.H{
-			"ID": string(id),
-		})
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -93,50 +104,193 @@ func (h handlers) fileDelete() gin.HandlerFunc {
 	}
 }
 
-func (h handlers) insertFileFromRequest(r *http.Request) (types.ID, error) {
-	if err := r.ParseMultipartForm(MULTI_PART_MAX_MEMORY); err != nil {
-		return types.ID(""), err
+// insertFilesFromRequest - streams the file parts of the form into the records sharing a new batch ID, see uploadForm.
+// A malformed form or an invalid field fails the whole upload, the records stored until then are dropped.
+// When none of the files is stored the error of the first one is returned together with the results
+func (h handlers) insertFilesFromRequest(r *http.Request) (types.UploadResponse, error) {
+	limit, err := h.limitUpload()
+	if err != nil {
+		return types.UploadResponse{}, err
 	}
 
-	defer func() {
-		if err := r.MultipartForm.RemoveAll(); err != nil {
-			log.Printf("filed to free multipart form resources: %v", err)
-		}
-	}()
+	if err := h.checkRequest(r, limit); err != nil {
+		return types.UploadResponse{}, err
+	}
 
-	reader, metadata, err := r.FormFile("file")
+	form, err := newUploadForm(r)
 	if err != nil {
-		return types.ID(""), err
+		return types.UploadResponse{}, err
 	}
 
-	if metadata.Size == 0 {
-		return types.ID(""), errors.New("file is empty")
+	batchID, err := generateRecordId()
+	if err != nil {
+		return types.UploadResponse{}, err
 	}
 
-	err = validateFilename(metadata.Filename)
+	response := types.UploadResponse{
+		BatchID: batchID,
+		Files:   []types.UploadResult{},
+	}
+	// stored - the records of the upload with the revision of the form they were stored with
+	stored := map[types.ID]int{}
+	var records []types.Metadata
+	var failure error
+
+	now := time.Now()
+	for {
+		part, err := form.nextFile()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			// the fields before the file are checked before any of its content is stored
+			err = form.check()
+		}
+		if err != nil {
+			h.dropRecords(records)
+			return types.UploadResponse{}, err
+		}
+
+		metadata, err := h.insertFormFile(part, form.fields, batchID, now)
+		result := types.UploadResult{
+			Filename: metadata.Filename,
+			Path:     metadata.Path,
+		}
+
+		var fe formError
+		switch {
+		case errors.As(err, &fe):
+			h.dropRecords(records)
+			return types.UploadResponse{}, err
+		case err != nil:
+			var de *dbError
+			result.Error = err.Error()
+			if errors.As(err, &de) {
+				result.Error = "failed to store the file"
+			}
+			if failure == nil {
+				failure = err
+			}
+		default:
+			result.ID = metadata.ID
+			stored[metadata.ID] = form.revision
+			records = append(records, metadata)
+		}
+		response.Files = append(response.Files, result)
+	}
+
+	if len(response.Files) == 0 {
+		return types.UploadResponse{}, fmt.Errorf("%s part is missing", FILE_FIELD)
+	}
+
+	// the fields after the files are checked and applied to them
+	if err := form.check(); err != nil {
+		h.dropRecords(records)
+		return types.UploadResponse{}, err
+	}
+	for _, metadata := range records {
+		if stored[metadata.ID] == form.revision {
+			continue
+		}
+		if err := h.applyFields(metadata, form.fields, now); err != nil {
+			log.Printf("failed to update new record %v with the trailing fields: %v", metadata.ID, err)
+			h.dropRecords(records)
+			return types.UploadResponse{}, &dbError{err}
+		}
+	}
+
+	if len(records) == 0 {
+		return response, failure
+	}
+
+	response.ID = records[0].ID
+	return response, nil
+}
+
+// insertFormFile - streams the file part into a new record of the batch, the returned metadata has
+// the filename and path of the file even when it fails
+func (h handlers) insertFormFile(part *multipart.Part, fields uploadFields, batchID types.ID, now time.Time) (types.Metadata, error) {
+	name, path, err := parseUploadPath(filename(part))
+	metadata := types.Metadata{
+		Filename: types.Filename(name),
+		Path:     path,
+	}
 	if err != nil {
-		return types.ID(""), err
+		metadata.Filename = types.Filename(filename(part))
+		return metadata, err
 	}
 
-	note := r.FormValue("note")
-	err = validateFileNote(note)
+	content, err := fileContent(part)
 	if err != nil {
-		return types.ID(""), err
+		return metadata, err
 	}
 
-	id := types.ID(uuid.New().String())
+	// every file is checked against the space left after the ones before it
+	limit, err := h.limitUpload()
+	if err != nil {
+		return metadata, uploadError(err)
+	}
 
-	err = h.db.InsertRecord(reader, types.Metadata{
-		ID:          id,
-		Filename:    types.Filename(metadata.Filename),
-		ContentType: types.ContentType(metadata.Header.Get("Content-Type")),
-		Note:        types.Note(note),
-		CreateAt:    time.Now(),
-	})
+	id, err := generateRecordId()
 	if err != nil {
-		log.Printf("failed to insert new record in db: %v", err)
-		return types.ID(""), dbError{err}
+		return metadata, &dbError{err}
+	}
+
+	metadata = types.Metadata{
+		ID:          id,
+		Filename:    types.Filename(name),
+		ContentType: types.ContentType(part.Header.Get("Content-Type")),
+		Note:        types.Note(fields.note),
+		CreateAt:    now,
+		ExpiresAt:   fields.expiresAt(now),
+		Attributes:  fields.attributes,
+		Tags:        fields.tags,
+		BatchID:     batchID,
+		Path:        path,
+	}
+
+	if err := h.db.InsertRecord(limit.reader(content), metadata); err != nil {
+		err = uploadError(err)
+		var de *dbError
+		if errors.As(err, &de) {
+			log.Printf("failed to insert new record in db: %v", err)
+		}
+		return metadata, err
+	}
+
+	return metadata, nil
+}
+
+// applyFields - updates the stored record with the fields of the whole form
+func (h handlers) applyFields(metadata types.Metadata, fields uploadFields, now time.Time) error {
+	metadata.Note = types.Note(fields.note)
+	metadata.ExpiresAt = fields.expiresAt(now)
+	metadata.Attributes = fields.attributes
+	metadata.Tags = fields.tags
+
+	return h.db.UpdateRecordMetadata(metadata.ID, metadata)
+}
+
+// dropRecords - deletes the records of the failed upload for good, the failures are only logged
+func (h handlers) dropRecords(records []types.Metadata) {
+	for _, metadata := range records {
+		err := h.db.DeleteRecord(metadata.ID)
+		if err == nil {
+			err = h.db.PurgeRecord(metadata.ID)
+		}
+		if err != nil {
+			log.Printf("failed to drop record %v: %v", metadata.ID, err)
+		}
 	}
+}
 
-	return id, nil
+// uploadError - the errors of the limits and of the form are kept as they are, so they get their own statuses,
+// the other failures of the upload are failures of the store
+func uploadError(err error) error {
+	var fe formError
+	var de *dbError
+	if quotaStatus(err) != 0 || errors.As(err, &fe) || errors.As(err, &de) {
+		return err
+	}
+	return &dbError{err}
 }
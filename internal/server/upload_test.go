@@ -7,7 +7,7 @@ import (
 	"github.com/denisschmidt/uploader/config"
 	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
 	"github.com/denisschmidt/uploader/internal/server"
-	"github.com/denisschmidt/uploader/internal/store/db/fake_db"
+	"github.com/denisschmidt/uploader/internal/store/memory"
 	"github.com/denisschmidt/uploader/internal/types"
 	"github.com/stretchr/testify/require"
 	"io"
@@ -61,10 +61,9 @@ func TestInsertRecord(t *testing.T) {
 		},
 	} {
 		t.Run(row.description, func(t *testing.T) {
-			chunkSize := 5
 			defaultConfig := config.DefaultConfig()
 			defaultConfig.SecretKey = "hello"
-			database := fake_db.New(chunkSize)
+			database := memory.New()
 			authenticator := fake_auth.FakeAuth{}
 			s, err := server.New(defaultConfig, database, authenticator)
 			require.NoError(t, err)
@@ -164,7 +163,7 @@ func TestUpdateRecord(t *testing.T) {
 	} {
 		defaultConfig := config.DefaultConfig()
 		defaultConfig.SecretKey = "hello"
-		database := fake_db.New(defaultConfig.DBChunkSize)
+		database := memory.New()
 
 		err := database.InsertRecord(strings.NewReader("file data"), mockRecord)
 		require.NoError(t, err)
@@ -198,7 +197,7 @@ func TestDeleteRecord(t *testing.T) {
 
 	defaultConfig := config.DefaultConfig()
 	defaultConfig.SecretKey = "hello"
-	database := fake_db.New(defaultConfig.DBChunkSize)
+	database := memory.New()
 
 	err := database.InsertRecord(strings.NewReader("file data"), mockRecord)
 	require.NoError(t, err)
@@ -231,6 +230,75 @@ func TestDeleteRecord(t *testing.T) {
 	require.Equal(t, http.StatusNotFound, rec.Code)
 }
 
+func TestUploadBatch(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	body, contentType := createMultipartForm(t,
+		formPart{name: "file", filename: "photos/2024/a.jpg", value: "a"},
+		formPart{name: "file", filename: "photos/../b.jpg", value: "b"},
+		formPart{name: "file", filename: "empty.txt", value: ""},
+		formPart{name: "file", filename: "photos\\c.jpg", value: "c"},
+		formPart{name: "note", value: "holidays"},
+	)
+	req, err := http.NewRequest("POST", "/api/file", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response types.UploadResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.NotEmpty(t, response.BatchID)
+	require.Len(t, response.Files, 4)
+
+	// the bad files fail on their own
+	require.Equal(t, types.UploadResult{Filename: "a.jpg", Path: "photos/2024", ID: response.Files[0].ID}, response.Files[0])
+	require.NotEmpty(t, response.Files[1].Error)
+	require.Empty(t, response.Files[1].ID)
+	require.Equal(t, "file is empty", response.Files[2].Error)
+	require.Equal(t, types.UploadResult{Filename: "c.jpg", Path: "photos", ID: response.Files[3].ID}, response.Files[3])
+	require.Equal(t, response.Files[0].ID, response.ID)
+
+	// the trailing note applies to every file of the batch
+	for _, result := range []types.UploadResult{response.Files[0], response.Files[3]} {
+		metadata, err := database.GetMetadata(result.ID)
+		require.NoError(t, err)
+		require.Equal(t, response.BatchID, metadata.BatchID)
+		require.Equal(t, result.Path, metadata.Path)
+		require.Equal(t, types.Note("holidays"), metadata.Note)
+	}
+
+	req, err = http.NewRequest("GET", "/api/files?batch_id="+string(response.BatchID), nil)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var page types.RecordsPage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Records, 2)
+
+	// the batch without any stored file fails with the error of the first one
+	body, contentType = createMultipartForm(t,
+		formPart{name: "file", filename: "/etc/passwd", value: "x"},
+		formPart{name: "file", filename: "empty.txt", value: ""},
+	)
+	req, err = http.NewRequest("POST", "/api/file", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "path must be relative")
+	require.Contains(t, rec.Body.String(), "file is empty")
+}
+
 func createMultipartFormBody(filename, note string, r io.Reader) (io.Reader, string) {
 	var b bytes.Buffer
 	bw := bufio.NewWriter(&b)
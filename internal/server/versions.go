@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+)
+
+// fileContentPut - stores the `file` part of the multipart form as the next version of the record,
+// the filename, note and labels of the record stay as they are
+func (h handlers) fileContentPut() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad record ID: %v", err),
+			})
+			return
+		}
+
+		metadata, err := h.putContentFromRequest(id, c.Request)
+		if err != nil {
+			if status := quotaStatus(err); status != 0 {
+				c.JSON(status, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			var de *dbError
+			if !errors.As(err, &de) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Bad request: %v", err),
+				})
+				return
+			}
+
+			switch de.Err.(type) {
+			case types.ErrFileNotExists:
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found ID: %v", id),
+				})
+			case types.ErrRecordLocked:
+				c.JSON(http.StatusLocked, gin.H{
+					"error": de.Err.Error(),
+				})
+			default:
+				log.Printf("failed to store new version of record %v: %v", id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("Failed to store record content: %v", de.Err),
+				})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, metadata)
+	}
+}
+
+// putContentFromRequest - streams the file part of the form into the next version of the record,
+// the other fields are ignored
+func (h handlers) putContentFromRequest(id types.ID, r *http.Request) (types.Metadata, error) {
+	limit, err := h.limitUpload()
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	if err := h.checkRequest(r, limit); err != nil {
+		return types.Metadata{}, err
+	}
+
+	form, err := newUploadForm(r)
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	part, err := form.nextFile()
+	if err == io.EOF {
+		return types.Metadata{}, fmt.Errorf("%s part is missing", FILE_FIELD)
+	}
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	content, err := fileContent(part)
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	metadata, err := h.db.PutRecordContent(id, limit.reader(content), types.ContentType(part.Header.Get("Content-Type")))
+	if err != nil {
+		return types.Metadata{}, uploadError(err)
+	}
+
+	return metadata, nil
+}
+
+// fileVersionsGet - the versions kept of the record, the latest first
+func (h handlers) fileVersionsGet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseRecordId(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad record ID: %v", err),
+			})
+			return
+		}
+
+		versions, err := h.db.ListRecordVersions(id)
+		if err != nil {
+			if _, ok := err.(types.ErrFileNotExists); ok {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": fmt.Sprintf("Record not found ID: %v", id),
+				})
+				return
+			}
+			log.Printf("failed to list versions of record %v: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to list record versions: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.RecordVersions{
+			Versions: versions,
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/config"
+	"github.com/denisschmidt/uploader/internal/auth/fake_auth"
+	"github.com/denisschmidt/uploader/internal/server"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordVersions(t *testing.T) {
+	defaultConfig := config.DefaultConfig()
+	defaultConfig.SecretKey = "hello"
+	database := memory.New()
+
+	s, err := server.New(defaultConfig, database, fake_auth.FakeAuth{})
+	require.NoError(t, err)
+
+	err = database.InsertRecord(bytes.NewBufferString("first"), types.Metadata{
+		ID:          "abcdefghij",
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		CreateAt:    time.Now(),
+	})
+	require.NoError(t, err)
+
+	serve := func(method, path string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+		req, err := http.NewRequest(method, path, body)
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	put := func(id string, content string) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		f, err := mw.CreateFormFile("file", "ignored.txt")
+		require.NoError(t, err)
+		f.Write([]byte(content))
+		require.NoError(t, mw.Close())
+		return serve("PUT", "/api/file/"+id+"/content", &b, mw.FormDataContentType())
+	}
+
+	first := serve("GET", "/api/file/abcdefghij", nil, "")
+	require.Equal(t, http.StatusOK, first.Code)
+
+	rec := put("abcdefghij", "second")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var metadata types.Metadata
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metadata))
+	require.Equal(t, 2, metadata.Version)
+	require.Equal(t, types.Filename("notes.txt"), metadata.Filename)
+
+	rec = serve("GET", "/api/file/abcdefghij", nil, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "second", rec.Body.String())
+	require.NotEqual(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+
+	rec = serve("GET", "/api/file/abcdefghij?version=1", nil, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "first", rec.Body.String())
+	require.Equal(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+
+	rec = serve("GET", "/api/file/abcdefghij/versions", nil, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var versions types.RecordVersions
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &versions))
+	require.Len(t, versions.Versions, 2)
+	require.Equal(t, 2, versions.Versions[0].Version)
+	require.Equal(t, int64(len("first")), versions.Versions[1].Size)
+
+	for _, row := range []struct {
+		description string
+		path        string
+		status      int
+	}{
+		{description: "pruned or unknown version", path: "/api/file/abcdefghij?version=3", status: http.StatusNotFound},
+		{description: "bad version", path: "/api/file/abcdefghij?version=latest", status: http.StatusBadRequest},
+		{description: "zero version", path: "/api/file/abcdefghij?version=0", status: http.StatusBadRequest},
+		{description: "history of a missing record", path: "/api/file/bcdefghijk/versions", status: http.StatusNotFound},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			require.Equal(t, row.status, serve("GET", row.path, nil, "").Code)
+		})
+	}
+
+	require.Equal(t, http.StatusNotFound, put("bcdefghijk", "missing").Code)
+	require.Equal(t, http.StatusBadRequest, put("abcdefghij", "").Code)
+
+	// the retention applies to the next version
+	rec = serve("PUT", "/api/settings", bytes.NewBufferString(`{"default_expiration_in_days": 30, "max_versions": 1}`), "application/json")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, http.StatusOK, put("abcdefghij", "third").Code)
+
+	rec = serve("GET", "/api/file/abcdefghij/versions", nil, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &versions))
+	require.Len(t, versions.Versions, 1)
+	require.Equal(t, 3, versions.Versions[0].Version)
+
+	rec = serve("PUT", "/api/settings", bytes.NewBufferString(`{"max_versions": -1}`), "application/json")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
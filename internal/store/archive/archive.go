@@ -0,0 +1,230 @@
+// Package archive moves records between stores as tar streams.
+// Every record is a JSON sidecar `<id>.json` holding its types.Metadata followed by the content entry `<id>`,
+// the IDs are path escaped
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const sidecarSuffix = ".json"
+
+// Conflict decides what Import does with a record whose ID is already taken
+type Conflict string
+
+const (
+	ConflictSkip      Conflict = "skip"
+	ConflictOverwrite Conflict = "overwrite"
+	ConflictRename    Conflict = "rename"
+)
+
+func ParseConflict(s string) (Conflict, error) {
+	switch Conflict(s) {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return Conflict(s), nil
+	default:
+		return "", fmt.Errorf("unsupported conflict policy %q, want %q, %q or %q", s, ConflictSkip, ConflictOverwrite, ConflictRename)
+	}
+}
+
+// Export writes every record of the store which hasn't expired to w, the oldest first
+func Export(s store.Store, w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+
+	exported := 0
+	options := types.ListOptions{Limit: store.MaxListLimit}
+	for {
+		page, err := s.ListRecords(options)
+		if err != nil {
+			return exported, err
+		}
+
+		for _, metadata := range page.Records {
+			if err := exportRecord(s, tw, metadata.ID); err != nil {
+				// the record could expire or be deleted while the archive is written
+				if _, ok := err.(types.ErrFileNotExists); ok {
+					continue
+				}
+				return exported, err
+			}
+			exported++
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+
+	return exported, tw.Close()
+}
+
+func exportRecord(s store.Store, tw *tar.Writer, id types.ID) error {
+	record, err := s.GetRecord(id)
+	if err != nil {
+		return err
+	}
+	if closer, ok := record.Reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	sidecar, err := json.Marshal(record.Metadata)
+	if err != nil {
+		return err
+	}
+
+	name := url.PathEscape(string(id))
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name + sidecarSuffix,
+		Mode:    0o644,
+		Size:    int64(len(sidecar)),
+		ModTime: record.CreateAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(sidecar); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    record.Size,
+		ModTime: record.CreateAt,
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, record.Reader)
+	return err
+}
+
+// ImportOptions - NewID creates the IDs of the renamed records, it is required by ConflictRename
+type ImportOptions struct {
+	Conflict Conflict
+	NewID    func() (types.ID, error)
+}
+
+// ImportReport - Renamed maps the IDs from the archive to the IDs the records are stored with
+type ImportReport struct {
+	Imported    int
+	Skipped     int
+	Overwritten int
+	Renamed     map[types.ID]types.ID
+}
+
+// Import inserts the records from the archive read from r into the store.
+// The metadata is preserved, except that the records without expiration get the default one of the store
+func Import(s store.Store, r io.Reader, options ImportOptions) (ImportReport, error) {
+	if options.Conflict == ConflictRename && options.NewID == nil {
+		return ImportReport{}, errors.New("renaming the conflicting records needs NewID")
+	}
+
+	report := ImportReport{
+		Renamed: make(map[types.ID]types.ID),
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if !strings.HasSuffix(header.Name, sidecarSuffix) {
+			return report, fmt.Errorf("archive entry %s has no metadata before it", header.Name)
+		}
+
+		var metadata types.Metadata
+		if err := json.NewDecoder(tr).Decode(&metadata); err != nil {
+			return report, fmt.Errorf("bad metadata in %s: %w", header.Name, err)
+		}
+
+		if header, err = tr.Next(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return report, err
+		}
+
+		id, err := url.PathUnescape(header.Name)
+		if err != nil || types.ID(id) != metadata.ID {
+			return report, fmt.Errorf("archive entry %s doesn't match the metadata of %v", header.Name, metadata.ID)
+		}
+		if header.Size != metadata.Size {
+			return report, fmt.Errorf("archive entry %s is %d bytes, the metadata says %d", header.Name, header.Size, metadata.Size)
+		}
+
+		if err := importRecord(s, tr, metadata, options, &report); err != nil {
+			return report, fmt.Errorf("failed to import %v: %w", metadata.ID, err)
+		}
+	}
+}
+
+func importRecord(s store.Store, r io.Reader, metadata types.Metadata, options ImportOptions, report *ImportReport) error {
+	original := metadata.ID
+	overwritten := false
+
+	for {
+		h := sha256.New()
+		err := s.InsertRecord(io.TeeReader(r, h), metadata)
+		if err == nil {
+			if digest := hex.EncodeToString(h.Sum(nil)); metadata.SHA256 != "" && digest != metadata.SHA256 {
+				purgeRecord(s, metadata.ID)
+				return fmt.Errorf("SHA-256 digest mismatch")
+			}
+			break
+		}
+
+		if _, ok := err.(types.ErrFileExists); !ok {
+			return err
+		}
+
+		switch options.Conflict {
+		case ConflictOverwrite:
+			// the ID is still taken after the record is deleted, e.g. by a resumable upload
+			if overwritten {
+				return err
+			}
+			overwritten = true
+			if err := purgeRecord(s, metadata.ID); err != nil {
+				return err
+			}
+			report.Overwritten++
+		case ConflictRename:
+			if metadata.ID, err = options.NewID(); err != nil {
+				return err
+			}
+		default:
+			report.Skipped++
+			return nil
+		}
+	}
+
+	if metadata.ID != original {
+		report.Renamed[original] = metadata.ID
+	}
+	report.Imported++
+	return nil
+}
+
+// purgeRecord - deletes the record for good instead of moving it to the trash, so its ID can be taken again
+func purgeRecord(s store.Store, id types.ID) error {
+	if err := s.DeleteRecord(id); err != nil {
+		return err
+	}
+	return s.PurgeRecord(id)
+}
@@ -0,0 +1,159 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/archive"
+	"github.com/denisschmidt/uploader/internal/store/memory"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var createAt = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+func insert(t *testing.T, s store.Store, id types.ID, content string) {
+	t.Helper()
+	err := s.InsertRecord(strings.NewReader(content), types.Metadata{
+		ID:          id,
+		Filename:    types.Filename(id + ".txt"),
+		Note:        types.Note("note of " + id),
+		ContentType: "text/plain",
+		CreateAt:    createAt,
+		ExpiresAt:   createAt.AddDate(10, 0, 0),
+	})
+	require.NoError(t, err)
+}
+
+func read(t *testing.T, s store.Store, id types.ID) (types.Metadata, string) {
+	t.Helper()
+	record, err := s.GetRecord(id)
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	return record.Metadata, string(content)
+}
+
+func export(t *testing.T, s store.Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := archive.Export(s, &buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestExportImport(t *testing.T) {
+	source := memory.New()
+	insert(t, source, "first", "first content")
+	insert(t, source, "with/slash", "escaped")
+	insert(t, source, "empty", "")
+
+	var buf bytes.Buffer
+	exported, err := archive.Export(source, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, exported)
+
+	// every record is a sidecar followed by the content
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	require.ElementsMatch(t, []string{"empty.json", "empty", "first.json", "first", "with%2Fslash.json", "with%2Fslash"}, names)
+
+	target := memory.New()
+	report, err := archive.Import(target, &buf, archive.ImportOptions{Conflict: archive.ConflictSkip})
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Imported)
+
+	for _, id := range []types.ID{"first", "with/slash", "empty"} {
+		want, wantContent := read(t, source, id)
+		got, gotContent := read(t, target, id)
+		require.Equal(t, want, got)
+		require.Equal(t, wantContent, gotContent)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	source := memory.New()
+	insert(t, source, "taken", "from the archive")
+	insert(t, source, "free", "free content")
+	tarball := export(t, source)
+
+	newIds := 0
+	newID := func() (types.ID, error) {
+		newIds++
+		return types.ID(fmt.Sprintf("renamed%d", newIds)), nil
+	}
+
+	for _, row := range []struct {
+		conflict   archive.Conflict
+		want       archive.ImportReport
+		takenAfter string
+	}{
+		{
+			conflict:   archive.ConflictSkip,
+			want:       archive.ImportReport{Imported: 1, Skipped: 1, Renamed: map[types.ID]types.ID{}},
+			takenAfter: "already stored",
+		},
+		{
+			conflict:   archive.ConflictOverwrite,
+			want:       archive.ImportReport{Imported: 2, Overwritten: 1, Renamed: map[types.ID]types.ID{}},
+			takenAfter: "from the archive",
+		},
+		{
+			conflict:   archive.ConflictRename,
+			want:       archive.ImportReport{Imported: 2, Renamed: map[types.ID]types.ID{"taken": "renamed1"}},
+			takenAfter: "already stored",
+		},
+	} {
+		t.Run(string(row.conflict), func(t *testing.T) {
+			target := memory.New()
+			insert(t, target, "taken", "already stored")
+
+			report, err := archive.Import(target, bytes.NewReader(tarball), archive.ImportOptions{
+				Conflict: row.conflict,
+				NewID:    newID,
+			})
+			require.NoError(t, err)
+			require.Equal(t, row.want, report)
+
+			_, content := read(t, target, "taken")
+			require.Equal(t, row.takenAfter, content)
+			_, content = read(t, target, "free")
+			require.Equal(t, "free content", content)
+
+			for _, renamed := range report.Renamed {
+				metadata, content := read(t, target, renamed)
+				require.Equal(t, "from the archive", content)
+				require.Equal(t, types.Filename("taken.txt"), metadata.Filename)
+			}
+		})
+	}
+}
+
+func TestImportTamperedArchive(t *testing.T) {
+	source := memory.New()
+	insert(t, source, "tampered", "original")
+	tarball := export(t, source)
+
+	// the same length, so only the digest tells
+	tampered := bytes.Replace(tarball, []byte("original"), []byte("modified"), 1)
+
+	target := memory.New()
+	_, err := archive.Import(target, bytes.NewReader(tampered), archive.ImportOptions{Conflict: archive.ConflictSkip})
+	require.ErrorContains(t, err, "SHA-256 digest mismatch")
+
+	_, err = target.GetRecord("tampered")
+	require.Equal(t, types.ErrFileNotExists{ID: "tampered"}, err)
+}
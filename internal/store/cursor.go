@@ -0,0 +1,61 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/denisschmidt/uploader/internal/types"
+)
+
+const (
+	// DefaultListLimit is the page size when ListOptions.Limit is not set
+	DefaultListLimit = 50
+	// MaxListLimit is the largest page ListRecords returns
+	MaxListLimit = 1000
+)
+
+// Cursor points at the last record of a page, the next page starts right after it.
+// Value is the sort key of that record, ID breaks the ties between equal keys
+type Cursor struct {
+	SortBy     types.SortField `json:"s"`
+	Descending bool            `json:"d"`
+	Value      string          `json:"v"`
+	ID         types.ID        `json:"i"`
+}
+
+func EncodeCursor(cursor Cursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses the cursor and checks it was issued for the same sort order as options
+func DecodeCursor(options types.ListOptions) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(options.Cursor)
+	if err != nil {
+		return Cursor{}, types.ErrInvalidCursor{Cursor: options.Cursor}
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return Cursor{}, types.ErrInvalidCursor{Cursor: options.Cursor}
+	}
+
+	if cursor.SortBy != options.SortBy || cursor.Descending != options.Descending || cursor.ID == "" {
+		return Cursor{}, types.ErrInvalidCursor{Cursor: options.Cursor}
+	}
+
+	return cursor, nil
+}
+
+// NormalizeListOptions applies the default sort order and page size
+func NormalizeListOptions(options types.ListOptions) types.ListOptions {
+	if options.SortBy == "" {
+		options.SortBy = types.SortByCreateAt
+	}
+	if options.Limit <= 0 {
+		options.Limit = DefaultListLimit
+	}
+	if options.Limit > MaxListLimit {
+		options.Limit = MaxListLimit
+	}
+	return options
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshots hold only the SQLite database, the content kept in a blob store has to be backed up separately
+
+// WriteSnapshot writes a consistent copy of the database to w, gzip compressed if gzipped is set.
+// Nothing is written when the snapshot fails its integrity check
+func (d DB) WriteSnapshot(w io.Writer, gzipped bool) error {
+	return writeSnapshot(d.ctx, w, gzipped)
+}
+
+// Backup writes the snapshot of the database at path without opening it for writing,
+// so it can run next to the server using the database
+func Backup(path string, w io.Writer, gzipped bool) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	ctx, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	return writeSnapshot(ctx, w, gzipped)
+}
+
+func writeSnapshot(ctx *sql.DB, w io.Writer, gzipped bool) error {
+	dir, err := os.MkdirTemp("", "uploader-snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// VACUUM INTO reads the database in a single transaction, the writers of the WAL aren't blocked
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if _, err := ctx.Exec(`VACUUM INTO ?`, snapshot); err != nil {
+		return fmt.Errorf("failed to snapshot the database: %w", err)
+	}
+
+	if err := VerifySnapshot(snapshot); err != nil {
+		return err
+	}
+
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !gzipped {
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, f); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// VerifySnapshot opens the database file and checks its integrity
+func VerifySnapshot(path string) error {
+	ctx, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	// SQLite opens any empty file as an empty database
+	var tables int
+	if err := ctx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='records'`).Scan(&tables); err != nil {
+		return fmt.Errorf("failed to check the snapshot: %w", err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("snapshot %s has no records table", path)
+	}
+
+	rows, err := ctx.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("failed to check the snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return err
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check the snapshot: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("snapshot integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Restore replaces the database at path with the snapshot read from r, plain or gzip compressed.
+// The snapshot is verified before anything is replaced. The server using the database must be stopped
+func Restore(r io.Reader, path string) error {
+	br := bufio.NewReader(r)
+	// the gzip magic number, a SQLite database starts with "SQLite format 3"
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	// staged next to the database, so it can be renamed into place
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := VerifySnapshot(tmp.Name()); err != nil {
+		return err
+	}
+
+	// the WAL of the replaced database would be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"time"
+)
+
+type (
+	// Store keeps the content of the records outside of SQLite, the metadata stays in the database.
+	// The content is staged while it is written and becomes visible under its ID only once committed
+	Store interface {
+		// Name is recorded next to every record, so the records are read back from the store they were written to
+		Name() string
+		// Create starts the staged content of the ID, offset 0 starts over and a positive offset
+		// continues the staged content of an interrupted write, dropping whatever was written past offset
+		Create(id types.ID, offset int64) (Writer, error)
+		// Open reads the committed content of the ID, size is the size it was committed with
+		Open(id types.ID, size int64) (io.ReadSeekCloser, error)
+		// Delete removes both the staged and the committed content of the ID, deleting a missing ID is not an error
+		Delete(id types.ID) error
+		// Walk calls fn with the ID of every staged or committed content last modified before the time
+		Walk(before time.Time, fn func(id types.ID) error) error
+	}
+
+	// Writer is the staged content of a single ID
+	Writer interface {
+		io.Writer
+		// Close keeps the staged content, so it can be continued by Store.Create
+		Close() error
+		// Commit atomically replaces the content of the ID with the staged one, the Writer must be closed first
+		Commit() error
+	}
+)
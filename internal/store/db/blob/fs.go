@@ -0,0 +1,166 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	committedDir = "blobs"
+	stagedDir    = "staging"
+)
+
+// FS keeps the content in a directory tree on the local disk, sharded by the hash of the ID
+// so no directory grows too large. The content is staged in a separate directory on the same
+// file system and renamed into the tree on commit, so a reader never sees partial content
+type FS struct {
+	root string
+}
+
+var _ Store = FS{}
+
+// NewFS creates the directory tree under root
+func NewFS(root string) (FS, error) {
+	for _, dir := range []string{committedDir, stagedDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return FS{}, err
+		}
+	}
+	return FS{root: root}, nil
+}
+
+func (s FS) Name() string {
+	return "fs"
+}
+
+// committedPath - blobs/ab/cd/<id>, where abcd is the beginning of the SHA-256 of the ID
+func (s FS) committedPath(id types.ID) string {
+	hash := sha256.Sum256([]byte(id))
+	shard := hex.EncodeToString(hash[:2])
+	return filepath.Join(s.root, committedDir, shard[:2], shard[2:], url.PathEscape(string(id)))
+}
+
+func (s FS) stagedPath(id types.ID) string {
+	return filepath.Join(s.root, stagedDir, url.PathEscape(string(id)))
+}
+
+func (s FS) Create(id types.ID, offset int64) (Writer, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(s.stagedPath(id), flags, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() < offset {
+		f.Close()
+		return nil, fmt.Errorf("staged content of %v is shorter than offset %d", id, offset)
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fsWriter{
+		File:          f,
+		committedPath: s.committedPath(id),
+	}, nil
+}
+
+func (s FS) Open(id types.ID, size int64) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.committedPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != size {
+		f.Close()
+		return nil, fmt.Errorf("content of %v is %d bytes, want %d", id, info.Size(), size)
+	}
+
+	return f, nil
+}
+
+func (s FS) Delete(id types.ID) error {
+	for _, path := range []string{s.stagedPath(id), s.committedPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s FS) Walk(before time.Time, fn func(id types.ID) error) error {
+	for _, dir := range []string{stagedDir, committedDir} {
+		err := filepath.WalkDir(filepath.Join(s.root, dir), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if !info.ModTime().Before(before) {
+				return nil
+			}
+
+			id, err := url.PathUnescape(entry.Name())
+			if err != nil {
+				return nil
+			}
+			return fn(types.ID(id))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fsWriter struct {
+	*os.File
+	committedPath string
+}
+
+// Close flushes the staged content to the disk, so it survives a crash before the commit
+func (w *fsWriter) Close() error {
+	if err := w.File.Sync(); err != nil {
+		w.File.Close()
+		return err
+	}
+	return w.File.Close()
+}
+
+func (w *fsWriter) Commit() error {
+	if err := os.MkdirAll(filepath.Dir(w.committedPath), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(w.File.Name(), w.committedPath)
+}
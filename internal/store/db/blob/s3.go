@@ -0,0 +1,424 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MinS3PartSize is the smallest part S3 accepts for every part of a multipart upload except the last one
+const MinS3PartSize = 5 << 20
+
+// S3Options of the S3 store
+type S3Options struct {
+	Bucket string
+	// Prefix is prepended to every key, so the bucket can be shared
+	Prefix string
+	// PartSize is the size of the multipart upload parts, it is also the most memory a single writer buffers
+	PartSize int64
+}
+
+// S3 keeps the content in an S3 compatible object storage. The content is written as a multipart upload
+// which becomes the object only when completed, so a reader never sees partial content.
+// The tail shorter than a part is kept as a separate staging object between the writes of a resumable upload
+type S3 struct {
+	client  minio.Core
+	options S3Options
+}
+
+var _ Store = S3{}
+
+func NewS3(client *minio.Client, options S3Options) S3 {
+	return S3{
+		client:  minio.Core{Client: client},
+		options: options,
+	}
+}
+
+func (s S3) Name() string {
+	return "s3"
+}
+
+func (s S3) committedKey(id types.ID) string {
+	return s.options.Prefix + committedDir + "/" + url.PathEscape(string(id))
+}
+
+func (s S3) stagedKey(id types.ID) string {
+	return s.options.Prefix + stagedDir + "/" + url.PathEscape(string(id))
+}
+
+// idFromKey - the ID of the committed or staged key, false for the keys this store doesn't own
+func (s S3) idFromKey(key string) (types.ID, bool) {
+	for _, dir := range []string{committedDir, stagedDir} {
+		if name, ok := strings.CutPrefix(key, s.options.Prefix+dir+"/"); ok {
+			id, err := url.PathUnescape(name)
+			return types.ID(id), err == nil
+		}
+	}
+	return "", false
+}
+
+// multipartUploads - calls fn with every unfinished multipart upload of the keys starting with prefix
+func (s S3) multipartUploads(ctx context.Context, prefix string, fn func(upload minio.ObjectMultipartInfo)) error {
+	var keyMarker, uploadIdMarker string
+	for {
+		result, err := s.client.ListMultipartUploads(ctx, s.options.Bucket, prefix, keyMarker, uploadIdMarker, "", 1000)
+		if err != nil {
+			return err
+		}
+		for _, upload := range result.Uploads {
+			fn(upload)
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		keyMarker, uploadIdMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+}
+
+// uploadIds - the IDs of the unfinished multipart uploads of the key
+func (s S3) uploadIds(ctx context.Context, key string) ([]string, error) {
+	var uploadIds []string
+	err := s.multipartUploads(ctx, key, func(upload minio.ObjectMultipartInfo) {
+		if upload.Key == key {
+			uploadIds = append(uploadIds, upload.UploadID)
+		}
+	})
+	return uploadIds, err
+}
+
+// parts - the uploaded parts of the multipart upload in order
+func (s S3) parts(ctx context.Context, key string, uploadId string) ([]minio.ObjectPart, error) {
+	var parts []minio.ObjectPart
+	marker := 0
+	for {
+		result, err := s.client.ListObjectParts(ctx, s.options.Bucket, key, uploadId, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.ObjectParts...)
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// readStaged - the tail left by the previous writer, empty when there is none
+func (s S3) readStaged(ctx context.Context, id types.ID) ([]byte, error) {
+	body, _, _, err := s.client.GetObject(ctx, s.options.Bucket, s.stagedKey(id), minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (s S3) Create(id types.ID, offset int64) (Writer, error) {
+	ctx := context.Background()
+
+	w := &s3Writer{
+		s3:  s,
+		id:  id,
+		key: s.committedKey(id),
+	}
+
+	uploadIds, err := s.uploadIds(ctx, w.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset == 0 {
+		if err := s.abortUploads(ctx, w.key, uploadIds); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	if len(uploadIds) > 1 {
+		return nil, fmt.Errorf("staged content of %v has %d multipart uploads", id, len(uploadIds))
+	}
+
+	var uploaded int64
+	if len(uploadIds) == 1 {
+		w.uploadId = uploadIds[0]
+		parts, err := s.parts(ctx, w.key, w.uploadId)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			w.parts = append(w.parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+			uploaded += part.Size
+		}
+	}
+
+	tail, err := s.readStaged(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if uploaded+int64(len(tail)) < offset {
+		return nil, fmt.Errorf("staged content of %v is shorter than offset %d", id, offset)
+	}
+	// the uploaded parts can't be truncated, the client has to start over
+	if uploaded > offset {
+		return nil, fmt.Errorf("staged content of %v can't be continued from offset %d, %d bytes are already uploaded", id, offset, uploaded)
+	}
+
+	w.buf.Write(tail[:offset-uploaded])
+	return w, nil
+}
+
+func (s S3) abortUploads(ctx context.Context, key string, uploadIds []string) error {
+	for _, uploadId := range uploadIds {
+		if err := s.client.AbortMultipartUpload(ctx, s.options.Bucket, key, uploadId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s S3) Open(id types.ID, size int64) (io.ReadSeekCloser, error) {
+	key := s.committedKey(id)
+
+	info, err := s.client.StatObject(context.Background(), s.options.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if info.Size != size {
+		return nil, fmt.Errorf("content of %v is %d bytes, want %d", id, info.Size, size)
+	}
+
+	return &s3Reader{
+		s3:   s,
+		key:  key,
+		size: size,
+	}, nil
+}
+
+func (s S3) Delete(id types.ID) error {
+	ctx := context.Background()
+	key := s.committedKey(id)
+
+	uploadIds, err := s.uploadIds(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := s.abortUploads(ctx, key, uploadIds); err != nil {
+		return err
+	}
+
+	for _, key := range []string{s.stagedKey(id), key} {
+		if err := s.client.RemoveObject(ctx, s.options.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s S3) Walk(before time.Time, fn func(id types.ID) error) error {
+	ctx := context.Background()
+
+	// the listing is finished first, fn is allowed to delete what it is called with
+	seen := make(map[types.ID]struct{})
+	var ids []types.ID
+	add := func(key string) {
+		id, ok := s.idFromKey(key)
+		if _, exists := seen[id]; ok && !exists {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	for object := range s.client.Client.ListObjects(ctx, s.options.Bucket, minio.ListObjectsOptions{Prefix: s.options.Prefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if object.LastModified.Before(before) {
+			add(object.Key)
+		}
+	}
+
+	if err := s.multipartUploads(ctx, s.options.Prefix, func(upload minio.ObjectMultipartInfo) {
+		if upload.Initiated.Before(before) {
+			add(upload.Key)
+		}
+	}); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Writer - uploads every full part as soon as it is buffered, the multipart upload is started with the first part.
+// Content shorter than a part is written as a single object on commit
+type s3Writer struct {
+	s3       S3
+	id       types.ID
+	key      string
+	uploadId string
+	parts    []minio.CompletePart
+	buf      bytes.Buffer
+}
+
+// Write buffers the data, the buffered bytes are kept by Close even when uploading a part fails
+func (w *s3Writer) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.s3.options.PartSize {
+		if err := w.uploadPart(w.s3.options.PartSize); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) uploadPart(size int64) error {
+	ctx := context.Background()
+
+	if w.uploadId == "" {
+		uploadId, err := w.s3.client.NewMultipartUpload(ctx, w.s3.options.Bucket, w.key, minio.PutObjectOptions{})
+		if err != nil {
+			return err
+		}
+		w.uploadId = uploadId
+	}
+
+	partNumber := len(w.parts) + 1
+	part, err := w.s3.client.PutObjectPart(ctx, w.s3.options.Bucket, w.key, w.uploadId, partNumber,
+		bytes.NewReader(w.buf.Bytes()[:size]), size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return err
+	}
+
+	w.parts = append(w.parts, minio.CompletePart{PartNumber: partNumber, ETag: part.ETag})
+	w.buf.Next(int(size))
+	return nil
+}
+
+// Close stores the buffered tail as the staging object, so the next writer continues from it
+func (w *s3Writer) Close() error {
+	ctx := context.Background()
+
+	if w.buf.Len() == 0 {
+		return w.s3.client.RemoveObject(ctx, w.s3.options.Bucket, w.s3.stagedKey(w.id), minio.RemoveObjectOptions{})
+	}
+
+	_, err := w.s3.client.PutObject(ctx, w.s3.options.Bucket, w.s3.stagedKey(w.id),
+		bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()), "", "", minio.PutObjectOptions{})
+	return err
+}
+
+func (w *s3Writer) Commit() error {
+	ctx := context.Background()
+
+	if w.uploadId == "" {
+		if _, err := w.s3.client.PutObject(ctx, w.s3.options.Bucket, w.key,
+			bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()), "", "", minio.PutObjectOptions{}); err != nil {
+			return err
+		}
+	} else {
+		if w.buf.Len() > 0 {
+			if err := w.uploadPart(int64(w.buf.Len())); err != nil {
+				return err
+			}
+		}
+		if _, err := w.s3.client.CompleteMultipartUpload(ctx, w.s3.options.Bucket, w.key, w.uploadId, w.parts, minio.PutObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return w.s3.client.RemoveObject(ctx, w.s3.options.Bucket, w.s3.stagedKey(w.id), minio.RemoveObjectOptions{})
+}
+
+// s3Reader - reads the object with ranged GET requests, the response body is kept while the reads are sequential
+type s3Reader struct {
+	s3     S3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		options := minio.GetObjectOptions{}
+		if r.offset > 0 {
+			if err := options.SetRange(r.offset, 0); err != nil {
+				return 0, err
+			}
+		}
+
+		body, _, _, err := r.s3.client.GetObject(context.Background(), r.s3.options.Bucket, r.key, options)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+
+	if err == io.EOF {
+		r.body.Close()
+		r.body = nil
+		if r.offset < r.size {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.offset, fmt.Errorf("invalid whence value: %d", whence)
+	}
+
+	if offset < 0 {
+		return r.offset, fmt.Errorf("negative position %d", offset)
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return r.offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/db"
+	"github.com/denisschmidt/uploader/internal/store/db/blob"
+	"github.com/denisschmidt/uploader/internal/store/db/fake_db"
+	"github.com/denisschmidt/uploader/internal/store/storetest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	for _, row := range []struct {
+		description string
+		newStore    storetest.Factory
+	}{
+		{
+			description: "sqlite",
+			newStore: func(t *testing.T) store.Store {
+				return fake_db.NewSqlWithChunk(64)
+			},
+		},
+		{
+			description: "sqlite dedup",
+			newStore: func(t *testing.T) store.Store {
+				return fake_db.NewSqlWithOptions(db.Options{ChunkSize: 64, Dedup: true})
+			},
+		},
+		{
+			description: "fs",
+			newStore: func(t *testing.T) store.Store {
+				blobs, err := blob.NewFS(t.TempDir())
+				require.NoError(t, err)
+				return fake_db.NewSqlWithOptions(db.Options{ChunkSize: 64, Blobs: blobs})
+			},
+		},
+		{
+			description: "s3",
+			newStore: func(t *testing.T) store.Store {
+				blobs, _ := newS3Blobs(t, 1000)
+				return fake_db.NewSqlWithOptions(db.Options{ChunkSize: 64, Blobs: blobs})
+			},
+		},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			storetest.Run(t, row.newStore)
+		})
+	}
+}
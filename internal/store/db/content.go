@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store/db/file"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blobVersionSeparator - separates the ID from the version in the blob keys of the later versions,
+// the record IDs never contain it
+const blobVersionSeparator = "@v"
+
+type (
+	// contentWriter - writes the content of a record either as chunks of this database or to the blob store.
+	// The content becomes readable after Commit, the `records` row makes it visible
+	contentWriter interface {
+		io.WriteCloser
+		Commit() error
+	}
+
+	// chunksWriter - the chunks are committed together with the `records` row
+	chunksWriter struct {
+		io.WriteCloser
+	}
+)
+
+func (w chunksWriter) Commit() error {
+	return nil
+}
+
+// storage - the name of the store the new records are written to, empty for the chunks in this database
+func (d DB) storage() string {
+	if d.blobs == nil {
+		return ""
+	}
+	return d.blobs.Name()
+}
+
+// blobKey - the key of the record version in the blob store, the first version is stored under the ID itself
+func blobKey(id types.ID, version int) types.ID {
+	if version <= 1 {
+		return id
+	}
+	return types.ID(fmt.Sprintf("%s%s%d", id, blobVersionSeparator, version))
+}
+
+// parseBlobKey - the ID and the version of the blob store key
+func parseBlobKey(key types.ID) (types.ID, int) {
+	i := strings.LastIndex(string(key), blobVersionSeparator)
+	if i < 0 {
+		return key, 1
+	}
+
+	version, err := strconv.Atoi(string(key[i+len(blobVersionSeparator):]))
+	if err != nil || version <= 1 {
+		return key, 1
+	}
+	return key[:i], version
+}
+
+// newContentWriter - the writer of a new record or of a new version of the record
+func (d DB) newContentWriter(id types.ID, version int) (contentWriter, error) {
+	if d.blobs != nil {
+		return d.blobs.Create(blobKey(id, version), 0)
+	}
+
+	encryption, err := d.createDataKey(d.ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return chunksWriter{file.NewWriterWithOptions(d.ctx, id, d.chunkSize, file.Options{
+		Dedup:       d.dedup,
+		Compression: d.compression,
+		Encryption:  encryption,
+		Version:     version,
+	})}, nil
+}
+
+// openContent - the reader of the record content from wherever it was written to
+func (d DB) openContent(id types.ID, options chunkOptions, size int64) (io.ReadSeeker, error) {
+	if options.storage != "" {
+		if options.storage != d.storage() {
+			return nil, fmt.Errorf("%v is stored in the %q storage, which isn't configured", id, options.storage)
+		}
+		return d.blobs.Open(blobKey(id, options.file.Version), size)
+	}
+
+	var err error
+	if options.file.Encryption, err = d.dataKey(id, options.file.Version); err != nil {
+		return nil, err
+	}
+
+	return file.NewReaderWithOptions(d.ctx, id, options.chunkSize, size, options.file), nil
+}
+
+// blobKeys - the blob store keys of every version of the records, read before the records are deleted
+func (d DB) blobKeys(tx *sql.Tx, ids ...types.ID) ([]types.ID, error) {
+	if d.blobs == nil {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := tx.Query(`
+		SELECT
+			id,
+			version
+		FROM
+			records
+		WHERE
+			storage != '' AND id IN (`+placeholders(len(ids))+`)
+		UNION ALL
+		SELECT
+			id,
+			version
+		FROM
+			record_versions
+		WHERE
+			storage != '' AND id IN (`+placeholders(len(ids))+`)`, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []types.ID
+	for rows.Next() {
+		var id types.ID
+		var version int
+		if err := rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		keys = append(keys, blobKey(id, version))
+	}
+	return keys, rows.Err()
+}
+
+// deleteBlobs - removes the content of the deleted records or versions from the blob store by their blob keys.
+// It runs after the records are deleted, the content left by a failure is removed by the orphan sweep
+func (d DB) deleteBlobs(keys ...types.ID) {
+	if d.blobs == nil {
+		return
+	}
+
+	for _, key := range keys {
+		if err := d.blobs.Delete(key); err != nil {
+			log.Printf("failed to delete content of %s: %v", key, err)
+		}
+	}
+}
+
+// sweepBlobs - removes the content of the blob store which belongs to no record version, upload or staged record
+func (d DB) sweepBlobs(staleBefore time.Time) (int64, error) {
+	if d.blobs == nil {
+		return 0, nil
+	}
+
+	var deleted int64
+	err := d.blobs.Walk(staleBefore, func(key types.ID) error {
+		id, version := parseBlobKey(key)
+
+		var exists bool
+		if err := d.ctx.QueryRow(`
+			SELECT
+				EXISTS (SELECT 1 FROM records WHERE id=? AND version=?) OR
+				EXISTS (SELECT 1 FROM record_versions WHERE id=? AND version=?) OR
+				EXISTS (SELECT 1 FROM uploads WHERE id=? AND ?=1) OR
+				EXISTS (SELECT 1 FROM pending_records WHERE id=?)`, id, version, id, version, id, version, id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		if err := d.blobs.Delete(key); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+
+	return deleted, err
+}
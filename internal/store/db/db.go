@@ -2,18 +2,18 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
-	"embed"
+	"errors"
 	"fmt"
 	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/db/blob"
 	"github.com/denisschmidt/uploader/internal/store/db/file"
 	"github.com/denisschmidt/uploader/internal/types"
 	_ "github.com/mattn/go-sqlite3"
 	"io"
 	"log"
-	"path"
-	"sort"
-	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,34 +22,105 @@ const (
 )
 
 type DB struct {
-	ctx       *sql.DB
-	chunkSize int
+	ctx         *sql.DB
+	chunkSize   int
+	dedup       bool
+	compression file.Codec
+	masterKey   *MasterKey
+	blobs       blob.Store
+	uploadLocks *uploadLocks
+	// search is set when the full-text search index is kept, see ensureSearchIndex
+	search bool
 }
 
-type dbMigration struct {
-	version int
-	query   string
+// Options of the SQLite store
+type Options struct {
+	ChunkSize             int
+	OptimizeForLiteStream bool
+	// Dedup stores the chunks of the new records by their content hash, so identical chunks are kept once
+	Dedup bool
+	// Compression of the new chunks, the existing chunks stay readable whatever it is set to
+	Compression file.Codec
+	// MasterKey enables the encryption of the new records, every record gets its own data key wrapped by the MasterKey.
+	// The encryption can't be combined with Dedup, the chunks of different records never match once encrypted
+	MasterKey *MasterKey
+	// Blobs keeps the content of the new records outside of this database, only the metadata is stored here.
+	// The chunk options above apply to the content stored in this database only
+	Blobs blob.Store
 }
 
-//go:embed migrations/*.sql
-var migrationsFs embed.FS // is an embedded filesystem that contains the migration SQL files
-
-func New(path string, defaultChunkSize int, optimizeForLiteStream bool) store.Store {
+func New(path string, defaultChunkSize int, optimizeForLiteStream bool) (store.Store, error) {
 	return NewWithChunkSize(path, defaultChunkSize, optimizeForLiteStream)
 }
 
-func NewWithChunkSize(path string, chunkSize int, optimizeForLiteStream bool) *DB {
+func NewWithChunkSize(path string, chunkSize int, optimizeForLiteStream bool) (*DB, error) {
+	return NewWithOptions(path, Options{
+		ChunkSize:             chunkSize,
+		OptimizeForLiteStream: optimizeForLiteStream,
+	})
+}
+
+// NewWithOptions opens the database and applies the pending migrations
+func NewWithOptions(path string, options Options) (*DB, error) {
+	if options.Dedup && options.MasterKey != nil {
+		return nil, errors.New("the deduplication can't be combined with the encryption")
+	}
+
+	ctx, err := open(path, options.OptimizeForLiteStream)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateToLatest(ctx); err != nil {
+		ctx.Close()
+		return nil, err
+	}
+
+	search, err := ensureSearchIndex(ctx)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+
+	db := &DB{
+		ctx:         ctx,
+		chunkSize:   options.ChunkSize,
+		dedup:       options.Dedup,
+		compression: options.Compression,
+		masterKey:   options.MasterKey,
+		blobs:       options.Blobs,
+		uploadLocks: newUploadLocks(),
+		search:      search,
+	}
+
+	// other processes may be writing to the same database (the server while export or fsck runs),
+	// so only the staged records abandoned long ago are swept
+	if _, err := db.SweepOrphans(time.Now().Add(-stalePendingAge)); err != nil {
+		log.Printf("failed to sweep orphaned chunks: %v", err)
+	}
+
+	return db, nil
+}
+
+// open - opens the database with the pragmas every connection needs, without migrating it
+func open(path string, optimizeForLiteStream bool) (*sql.DB, error) {
 	log.Printf("reading DB from %s", path)
 	ctx, err := sql.Open("sqlite3", path)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+
+	// the connections to a shared cache in-memory database fail on each other's table locks instead of waiting
+	if strings.Contains(path, "mode=memory") {
+		ctx.SetMaxOpenConns(1)
 	}
 
 	if _, err := ctx.Exec(`
 		PRAGMA temp_store = FILE;
 		PRAGMA journal_mode = WAL;
 	`); err != nil {
-		log.Fatalf("failed to set up pragmas database: %v", err)
+		ctx.Close()
+		return nil, fmt.Errorf("failed to set up pragmas database: %w", err)
 	}
 
 	if optimizeForLiteStream {
@@ -58,32 +129,128 @@ func NewWithChunkSize(path string, chunkSize int, optimizeForLiteStream bool) *D
 			PRAGMA synchronous = NORMAL;
 			PRAGMA wal_autocheckpoint = 0;
 		`); err != nil {
-			log.Fatalf("failed to set up Litestream pragmas %v", err)
+			ctx.Close()
+			return nil, fmt.Errorf("failed to set up Litestream pragmas: %w", err)
 		}
 	}
 
-	migrations(ctx)
+	return ctx, nil
+}
 
-	return &DB{
-		ctx:       ctx,
-		chunkSize: chunkSize,
-	}
+// Close closes the database, the Reaper must be closed first
+func (d DB) Close() error {
+	return d.ctx.Close()
 }
 
+// InsertRecord stores the content of the reader as a new record.
+// The chunks are written while the record is staged in `pending_records` and the record becomes visible
+// only when the `records` row is inserted, a failed insert removes everything it has written
 func (d DB) InsertRecord(reader io.Reader, metadata types.Metadata) error {
 	log.Printf("Create a new record %s", metadata.ID)
 
-	w := file.NewWriter(d.ctx, metadata.ID, d.chunkSize)
+	if err := d.stageRecord(metadata.ID); err != nil {
+		return err
+	}
+
+	w, err := d.newContentWriter(metadata.ID, 1)
+	if err != nil {
+		log.Printf("failed to create record %s: %v", metadata.ID, err)
+		d.discardRecord(metadata.ID)
+		return err
+	}
+
+	size, digest, content, err := writeContent(w, reader, metadata.ContentType)
+	if err == nil {
+		metadata.SHA256 = digest
+		err = d.commitRecord(metadata, size, content)
+	}
+
+	if err != nil {
+		log.Printf("failed to insert record %s: %v", metadata.ID, err)
+		d.discardRecord(metadata.ID)
+		return err
+	}
+
+	return nil
+}
+
+// writeContent - copies the content of the reader to the writer and commits it, returns the size, the SHA-256
+// and the text indexed for the full-text search, the text-like content is indexed as it is written
+func writeContent(w contentWriter, reader io.Reader, contentType types.ContentType) (int64, string, string, error) {
+	var text *textCapture
+	if store.IsTextContentType(contentType) {
+		text = &textCapture{}
+		reader = io.TeeReader(reader, text)
+	}
+
+	digest := newDigestWriter(w, sha256.New())
 	// copy the content from the reader (input) to the Writer instance (w)
-	if _, err := io.Copy(w, reader); err != nil {
+	size, err := io.Copy(digest, reader)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = w.Commit()
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	var content string
+	if text != nil {
+		content = store.ExtractText(text.data)
+	}
+	return size, digest.digest(), content, nil
+}
+
+// stageRecord - marks the ID as being written, so the orphan sweep leaves its chunks alone.
+// An ID already being written is ignored instead of failing, so the driver's error types aren't needed
+func (d DB) stageRecord(id types.ID) error {
+	res, err := d.ctx.Exec(`
+	INSERT OR IGNORE INTO
+		pending_records
+	(
+		id,
+		started_at
+	)
+	SELECT ?, ?
+	WHERE
+		NOT EXISTS (SELECT 1 FROM records WHERE id=?) AND
+		NOT EXISTS (SELECT 1 FROM uploads WHERE id=?)`,
+		id, time.Now().UTC().Format(timeFormat), id, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrFileExists{ID: id}
+	}
+
+	return nil
+}
+
+// commitRecord - inserts the `records` row with its search document and drops the staging marker in one transaction
+func (d DB) commitRecord(metadata types.Metadata, size int64, content string) error {
+	expiresAt, err := d.recordExpiration(metadata.ExpiresAt)
+	if err != nil {
 		return err
 	}
 
-	if err := w.Close(); err != nil {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err := d.ctx.Exec(`
+	if err = writeSearchDocument(tx, metadata.ID, content); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`
 	INSERT INTO
 		records
 	(
@@ -91,89 +258,224 @@ func (d DB) InsertRecord(reader io.Reader, metadata types.Metadata) error {
 		filename,
 		note,
 		content_type,
-		create_at
+		create_at,
+		expires_at,
+		size,
+		chunk_size,
+		dedup,
+		sha256,
+		storage,
+		batch_id,
+		path
 	)
-	VALUES(?,?,?,?,?)`,
+	VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		metadata.ID,
 		metadata.Filename,
 		metadata.Note,
 		metadata.ContentType,
 		metadata.CreateAt.UTC().Format(timeFormat),
-	)
+		formatNullTime(expiresAt),
+		size,
+		d.chunkSize,
+		d.dedup,
+		metadata.SHA256,
+		d.storage(),
+		formatNullID(metadata.BatchID),
+		metadata.Path,
+	); err != nil {
+		return err
+	}
+
+	if err = writeLabels(tx, metadata.ID, metadata); err != nil {
+		return err
+	}
+
+	if err = unstageTx(tx, metadata.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unstageTx - drops the staging marker of the record being committed. The marker swept in the meantime means
+// the chunks may have been swept as well, so the commit fails instead of making a broken record visible
+func unstageTx(tx *sql.Tx, id types.ID) error {
+	res, err := tx.Exec(`
+	DELETE FROM
+		pending_records
+	WHERE
+		id=?`, id)
+	if err != nil {
+		return err
+	}
 
+	rows, err := res.RowsAffected()
 	if err != nil {
-		log.Printf("failed to insert record into `records` table: %v", err)
 		return err
 	}
+	if rows == 0 {
+		return fmt.Errorf("record %s is no longer staged, its chunks may have been swept", id)
+	}
 
 	return nil
 }
 
+// discardRecord - removes the chunks of the staged record which failed to be inserted.
+// If this fails as well the orphan sweep picks them up later
+func (d DB) discardRecord(id types.ID) {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Printf("failed to discard record %s: %v", id, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err = deleteChunks(tx, id); err != nil {
+		log.Printf("failed to discard chunks of record %s: %v", id, err)
+		return
+	}
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		pending_records
+	WHERE
+		id=?`, id); err != nil {
+		log.Printf("failed to discard record %s: %v", id, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("failed to discard record %s: %v", id, err)
+		return
+	}
+
+	d.deleteBlobs(id)
+}
+
 func (d DB) GetRecord(id types.ID) (types.UploadRecord, error) {
-	metadata, err := d.GetMetadata(id)
+	metadata, options, err := d.getMetadata(id)
 	if err != nil {
 		return types.UploadRecord{}, err
 	}
 
-	r, err := file.NewReader(d.ctx, id)
+	reader, err := d.openContent(id, options, metadata.Size)
 	if err != nil {
 		return types.UploadRecord{}, err
 	}
 
 	return types.UploadRecord{
 		Metadata: metadata,
-		Reader:   r,
+		Reader:   reader,
 	}, nil
 }
 
 func (d DB) GetMetadata(id types.ID) (types.Metadata, error) {
+	metadata, _, err := d.getMetadata(id)
+	return metadata, err
+}
+
+// chunkOptions - how the chunks of the record were written, storage is the blob store holding the content instead
+type chunkOptions struct {
+	chunkSize int64
+	file      file.Options
+	storage   string
+}
+
+// getMetadata - reads the record together with the chunk options it was written with
+func (d DB) getMetadata(id types.ID) (types.Metadata, chunkOptions, error) {
 	var filename string
 	var note string
 	var contentType string
 	var createAtTime string
+	var expiresAtTime sql.NullString
+	var size int64
+	var digest sql.NullString
+	var modifiedAtTime sql.NullString
+	var batchID sql.NullString
+	var path string
+	var options chunkOptions
 
 	err := d.ctx.QueryRow(`
 		SELECT 
 		    filename,
 			note,
 			content_type,
-			create_at
+			create_at,
+			expires_at,
+			size,
+			chunk_size,
+			dedup,
+			sha256,
+			storage,
+			version,
+			modified_at,
+			batch_id,
+			path
 		FROM
 		    records
 		WHERE
-		    id=?`, id).Scan(&filename, &note, &contentType, &createAtTime)
+		    id=? AND (expires_at IS NULL OR expires_at > ?) AND quarantined_at IS NULL AND deleted_at IS NULL`, id, time.Now().UTC().Format(timeFormat)).Scan(&filename, &note, &contentType, &createAtTime, &expiresAtTime, &size, &options.chunkSize, &options.file.Dedup, &digest, &options.storage, &options.file.Version, &modifiedAtTime, &batchID, &path)
 	if err == sql.ErrNoRows {
-		return types.Metadata{}, types.ErrFileNotExists{
+		return types.Metadata{}, chunkOptions{}, types.ErrFileNotExists{
 			ID: id,
 		}
 	}
 	if err != nil {
-		return types.Metadata{}, err
+		return types.Metadata{}, chunkOptions{}, err
 	}
 
 	createAt, err := time.Parse(time.RFC3339, createAtTime)
 	if err != nil {
-		return types.Metadata{}, err
+		return types.Metadata{}, chunkOptions{}, err
+	}
+
+	expiresAt, err := parseNullTime(expiresAtTime)
+	if err != nil {
+		return types.Metadata{}, chunkOptions{}, err
+	}
+
+	modifiedAt, err := parseNullTime(modifiedAtTime)
+	if err != nil {
+		return types.Metadata{}, chunkOptions{}, err
 	}
 
-	return types.Metadata{
+	records := []types.Metadata{{
 		ID:          id,
 		Filename:    types.Filename(filename),
 		Note:        types.Note(note),
 		ContentType: types.ContentType(contentType),
 		CreateAt:    createAt,
-	}, nil
+		ExpiresAt:   expiresAt,
+		Size:        size,
+		SHA256:      digest.String,
+		Version:     options.file.Version,
+		ModifiedAt:  modifiedAt,
+		BatchID:     types.ID(batchID.String),
+		Path:        path,
+	}}
+	if err := d.readLabels(records); err != nil {
+		return types.Metadata{}, chunkOptions{}, err
+	}
+
+	return records[0], options, nil
 }
 
 func (d DB) UpdateRecordMetadata(id types.ID, metadata types.Metadata) error {
-	res, err := d.ctx.Exec(`
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
 		UPDATE records
 		SET
 			filename = ?,
-			note = ?
+			note = ?,
+			expires_at = COALESCE(?, expires_at)
 		WHERE
-			id=?
-	`, metadata.Filename, metadata.Note, id)
+			id=? AND (expires_at IS NULL OR expires_at > ?) AND quarantined_at IS NULL AND deleted_at IS NULL
+	`, metadata.Filename, metadata.Note, formatNullTime(metadata.ExpiresAt), id, time.Now().UTC().Format(timeFormat))
 	if err != nil {
 		return err
 	}
@@ -186,115 +488,69 @@ func (d DB) UpdateRecordMetadata(id types.ID, metadata types.Metadata) error {
 		return types.ErrFileNotExists{ID: id}
 	}
 
-	return nil
-}
-
-func (d DB) DeleteRecord(id types.ID) error {
-	tx, err := d.ctx.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(`
-	DELETE FROM
-		records
-	WHERE
-		id=?`, id)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec(`
-	DELETE FROM
-		metadata
-	WHERE
-		id=?`, id)
-	if err != nil {
+	if err = writeLabels(tx, id, metadata); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func migrations(ctx *sql.DB) {
-	var currentVersion int
-	if err := ctx.QueryRow(`PRAGMA user_version`).Scan(&currentVersion); err != nil {
-		log.Fatalf("failed to get user_version: %v", err)
-	}
-
-	migrations, err := getMigrationsQuery()
-
-	if err != nil {
-		log.Fatalf("error loading database migrations: %v", err)
+// deleteChunks - removes the chunks of every version of the records in both layouts together with their data keys,
+// earlier versions, labels and search documents,
+// the shared chunks are freed by the `record_chunks_delete` trigger once nothing refers to them
+func deleteChunks(tx *sql.Tx, ids ...types.ID) error {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
 
-	log.Printf("start migration stats: %d/%d", currentVersion, len(migrations))
-
-	for _, migration := range migrations {
-		if migration.version <= currentVersion {
-			continue
-		}
-		// starts a new transaction with an empty context and default transaction options
-		// if any operation within the transaction fails, the whole transaction will be rolled back
-		// ensuring the database remains in a consistent state.
-		tx, err := ctx.BeginTx(context.Background(), nil)
-		if err != nil {
-			log.Fatalf("failed to create transaction %d: %v", migration.version, err)
-		}
-
-		_, err = tx.Exec(migration.query)
-		if err != nil {
-			log.Fatalf("failed to perform DB migration %d: %v", migration.version, err)
-		}
-
-		_, err = tx.Exec(fmt.Sprintf(`pragma user_version=%d`, migration.version))
-		if err != nil {
-			log.Fatalf("failed to update DB version to %d: %v", migration.version, err)
-		}
-
-		if err = tx.Commit(); err != nil {
-			log.Fatalf("failed to commit migration %d: %v", migration.version, err)
+	for _, table := range []string{"metadata", "record_chunks", "data_keys", "record_versions", "record_attributes", "record_tags", "search_documents"} {
+		if _, err := tx.Exec(`
+		DELETE FROM
+			`+table+`
+		WHERE
+			id IN (`+placeholders(len(ids))+`)`, args...); err != nil {
+			return err
 		}
-
-		log.Printf("end migration stats: %d/%d", migration.version, len(migrations))
 	}
+
+	return nil
 }
 
-func getMigrationsQuery() ([]dbMigration, error) {
-	migrations := []dbMigration{}
-	dirname := "migrations"
+// recordExpiration - the expiration requested for the record, or the default one from the `settings` table
+func (d DB) recordExpiration(requested time.Time) (time.Time, error) {
+	if !requested.IsZero() {
+		return requested, nil
+	}
 
-	entries, err := migrationsFs.ReadDir(dirname)
+	settings, err := d.GetSettings()
 	if err != nil {
-		return []dbMigration{}, err
+		return time.Time{}, err
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		version := getMigrationVersion(entry.Name())
+	if settings.DefaultExpirationInDays == 0 {
+		return time.Time{}, nil
+	}
 
-		query, err := migrationsFs.ReadFile(path.Join(dirname, entry.Name()))
-		if err != nil {
-			return []dbMigration{}, err
-		}
+	return time.Now().AddDate(0, 0, settings.DefaultExpirationInDays), nil
+}
 
-		migrations = append(migrations, dbMigration{version: version, query: string(query)})
+// formatNullTime - zero time is stored as NULL
+func formatNullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
 	}
+	return sql.NullString{String: t.UTC().Format(timeFormat), Valid: true}
+}
 
-	sort.Slice(migrations, func(i, j int) bool {
-		return migrations[i].version < migrations[j].version
-	})
-
-	return migrations, nil
+// formatNullID - NULL for the empty ID
+func formatNullID(id types.ID) sql.NullString {
+	return sql.NullString{String: string(id), Valid: id != ""}
 }
 
-func getMigrationVersion(filename string) int {
-	version, err := strconv.ParseInt(filename[:3], 10, 32)
-	if err != nil {
-		log.Fatalf("migration version is wrong: %v", filename)
+func parseNullTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
 	}
-	return int(version)
+	return time.Parse(timeFormat, s.String)
 }
@@ -2,11 +2,25 @@ package db_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store/db"
+	"github.com/denisschmidt/uploader/internal/store/db/blob"
 	"github.com/denisschmidt/uploader/internal/store/db/fake_db"
+	"github.com/denisschmidt/uploader/internal/store/db/file"
 	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/require"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestReadLastByteOfRecord(t *testing.T) {
@@ -26,7 +40,7 @@ func TestReadLastByteOfRecord(t *testing.T) {
 	record, err := db.GetRecord(types.ID("test"))
 	require.NoError(t, err)
 
-	pos, err := record.Reader.Seek(1, io.SeekEnd)
+	pos, err := record.Reader.Seek(-1, io.SeekEnd)
 	require.NoError(t, err)
 
 	want := int64(len(data))
@@ -37,3 +51,1259 @@ func TestReadLastByteOfRecord(t *testing.T) {
 
 	require.Equal(t, string(content), "@")
 }
+
+// failingReader returns the data and then fails, like a dropped connection
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestResumeInterruptedUpload(t *testing.T) {
+	chunkSize := 5
+	db := fake_db.NewSqlWithChunk(chunkSize)
+	data := "0123456789abcdefghijklmnopq"
+	id := types.ID("upload")
+
+	err := db.CreateUpload(types.Upload{
+		Metadata: types.Metadata{
+			ID:       id,
+			Filename: "upload.txt",
+			CreateAt: time.Now(),
+		},
+		Length:    int64(len(data)),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// the connection drops in the middle of the second chunk
+	upload, err := db.WriteUpload(id, 0, &failingReader{data: bytes.NewBufferString(data[:7])})
+	require.Error(t, err)
+	require.Equal(t, int64(7), upload.Offset)
+
+	_, err = db.WriteUpload(id, 3, bytes.NewBufferString(data[3:]))
+	require.Equal(t, types.ErrUploadOffsetMismatch{ID: id, Expected: 7, Got: 3}, err)
+
+	_, err = db.GetRecord(id)
+	require.Equal(t, types.ErrFileNotExists{ID: id}, err)
+
+	upload, err = db.WriteUpload(id, 7, bytes.NewBufferString(data[7:]))
+	require.NoError(t, err)
+	require.Equal(t, upload.Length, upload.Offset)
+
+	record, err := db.GetRecord(id)
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+	require.Equal(t, types.Filename("upload.txt"), record.Filename)
+}
+
+func TestDeleteExpiredRecords(t *testing.T) {
+	db := fake_db.NewSqlWithChunk(5)
+	now := time.Now()
+
+	settings, err := db.GetSettings()
+	require.NoError(t, err)
+	require.Equal(t, 30, settings.DefaultExpirationInDays)
+
+	for _, row := range []struct {
+		id        types.ID
+		expiresAt time.Time
+	}{
+		{id: "expired_1", expiresAt: now.Add(time.Hour)},
+		{id: "expired_2", expiresAt: now.Add(2 * time.Hour)},
+		{id: "expired_3", expiresAt: now.Add(3 * time.Hour)},
+		{id: "default", expiresAt: time.Time{}},
+	} {
+		err := db.InsertRecord(bytes.NewBufferString("expiring content"), types.Metadata{
+			ID:        row.id,
+			Filename:  "test.txt",
+			CreateAt:  now,
+			ExpiresAt: row.expiresAt,
+		})
+		require.NoError(t, err)
+	}
+
+	metadata, err := db.GetMetadata("default")
+	require.NoError(t, err)
+	require.WithinDuration(t, now.AddDate(0, 0, 30), metadata.ExpiresAt, time.Minute)
+
+	// expired records are gone for readers even before the reaper runs
+	deleted, err := db.DeleteExpiredRecords(now, 2)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+
+	deleted, err = db.DeleteExpiredRecords(now.Add(4*time.Hour), 2)
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+
+	for _, id := range []types.ID{"expired_1", "expired_2", "expired_3"} {
+		_, err := db.GetRecord(id)
+		require.Equal(t, types.ErrFileNotExists{ID: id}, err)
+	}
+
+	_, err = db.GetRecord("default")
+	require.NoError(t, err)
+
+	err = db.UpdateSettings(types.Settings{DefaultExpirationInDays: 0})
+	require.NoError(t, err)
+
+	err = db.InsertRecord(bytes.NewBufferString("forever"), types.Metadata{ID: "forever", Filename: "test.txt"})
+	require.NoError(t, err)
+
+	metadata, err = db.GetMetadata("forever")
+	require.NoError(t, err)
+	require.True(t, metadata.ExpiresAt.IsZero())
+}
+
+func TestDeleteTrashedRecords(t *testing.T) {
+	database := fake_db.NewSqlWithChunk(5)
+
+	for _, id := range []types.ID{"trashed_1", "trashed_2", "trashed_3", "kept"} {
+		err := database.InsertRecord(bytes.NewBufferString("trashed content"), types.Metadata{
+			ID:       id,
+			Filename: "test.txt",
+			CreateAt: time.Now(),
+		})
+		require.NoError(t, err)
+	}
+
+	for _, id := range []types.ID{"trashed_1", "trashed_2", "trashed_3"} {
+		require.NoError(t, database.DeleteRecord(id))
+	}
+
+	count := func(query string) int {
+		var n int
+		require.NoError(t, database.Conn().QueryRow(query).Scan(&n))
+		return n
+	}
+
+	// nothing was deleted before the retention
+	deleted, err := database.DeleteTrashedRecords(time.Now().Add(-time.Hour), 2)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+	require.Equal(t, 4, count(`SELECT COUNT(*) FROM records`))
+
+	deleted, err = database.DeleteTrashedRecords(time.Now().Add(time.Second), 2)
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+	require.Equal(t, 1, count(`SELECT COUNT(*) FROM records`))
+	require.Equal(t, 1, count(`SELECT COUNT(DISTINCT id) FROM metadata`))
+
+	_, err = database.GetRecord("kept")
+	require.NoError(t, err)
+
+	// the purged ID can be taken again
+	err = database.InsertRecord(bytes.NewBufferString("new content"), types.Metadata{ID: "trashed_1", Filename: "test.txt"})
+	require.NoError(t, err)
+}
+
+func TestRestoreRecord(t *testing.T) {
+	database := fake_db.NewSqlWithChunk(5)
+
+	for _, id := range []types.ID{"restored", "expired", "quarantined"} {
+		err := database.InsertRecord(bytes.NewBufferString("trashed content"), types.Metadata{ID: id, Filename: "test.txt"})
+		require.NoError(t, err)
+		require.NoError(t, database.DeleteRecord(id))
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	_, err := database.Conn().Exec(`UPDATE records SET expires_at=? WHERE id=?`, past, "expired")
+	require.NoError(t, err)
+	_, err = database.Conn().Exec(`UPDATE records SET quarantined_at=? WHERE id=?`, past, "quarantined")
+	require.NoError(t, err)
+
+	require.NoError(t, database.RestoreRecord("restored"))
+
+	// the expired and quarantined records stay in the trash
+	for _, id := range []types.ID{"expired", "quarantined"} {
+		require.Equal(t, types.ErrFileNotExists{ID: id}, database.RestoreRecord(id))
+
+		var trashed int
+		require.NoError(t, database.Conn().QueryRow(`SELECT COUNT(*) FROM records WHERE id=? AND deleted_at IS NOT NULL`, id).Scan(&trashed))
+		require.Equal(t, 1, trashed)
+	}
+}
+
+func TestListRecords(t *testing.T) {
+	db := fake_db.NewSqlWithChunk(4)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i, row := range []struct {
+		id          types.ID
+		filename    types.Filename
+		contentType types.ContentType
+		content     string
+	}{
+		{id: "a", filename: "report-1.txt", contentType: "text/plain", content: "1"},
+		{id: "b", filename: "report-2.txt", contentType: "text/plain", content: "1234567"},
+		{id: "c", filename: "image.png", contentType: "image/png", content: "123456789012"},
+		{id: "d", filename: "Report-3.txt", contentType: "text/plain", content: "123"},
+		{id: "e", filename: "archive.zip", contentType: "application/zip", content: "12345"},
+	} {
+		err := db.InsertRecord(bytes.NewBufferString(row.content), types.Metadata{
+			ID:          row.id,
+			Filename:    row.filename,
+			ContentType: row.contentType,
+			CreateAt:    now.Add(time.Duration(i) * time.Minute),
+		})
+		require.NoError(t, err)
+	}
+
+	ids := func(page types.RecordsPage) []types.ID {
+		res := []types.ID{}
+		for _, r := range page.Records {
+			res = append(res, r.ID)
+		}
+		return res
+	}
+
+	for _, row := range []struct {
+		description string
+		options     types.ListOptions
+		want        []types.ID
+	}{
+		{
+			description: "default order is by creation time",
+			options:     types.ListOptions{},
+			want:        []types.ID{"a", "b", "c", "d", "e"},
+		},
+		{
+			description: "newest first",
+			options:     types.ListOptions{Descending: true},
+			want:        []types.ID{"e", "d", "c", "b", "a"},
+		},
+		{
+			description: "by size",
+			options:     types.ListOptions{SortBy: types.SortBySize},
+			want:        []types.ID{"a", "d", "e", "b", "c"},
+		},
+		{
+			description: "by filename",
+			options:     types.ListOptions{SortBy: types.SortByFilename},
+			want:        []types.ID{"d", "e", "c", "a", "b"},
+		},
+		{
+			description: "content type",
+			options:     types.ListOptions{ContentType: "text/plain"},
+			want:        []types.ID{"a", "b", "d"},
+		},
+		{
+			description: "case-sensitive filename prefix",
+			options:     types.ListOptions{FilenamePrefix: "report"},
+			want:        []types.ID{"a", "b"},
+		},
+		{
+			description: "size range across chunk boundaries",
+			options:     types.ListOptions{MinSize: 3, MaxSize: 7},
+			want:        []types.ID{"b", "d", "e"},
+		},
+		{
+			description: "created range",
+			options:     types.ListOptions{CreatedAfter: now.Add(time.Minute), CreatedBefore: now.Add(3 * time.Minute)},
+			want:        []types.ID{"b", "c"},
+		},
+	} {
+		page, err := db.ListRecords(row.options)
+		require.NoError(t, err, row.description)
+		require.Equal(t, row.want, ids(page), row.description)
+		require.Empty(t, page.NextCursor, row.description)
+	}
+
+	page, err := db.ListRecords(types.ListOptions{MinSize: 12})
+	require.NoError(t, err)
+	require.Equal(t, int64(12), page.Records[0].Size)
+	require.Equal(t, types.Filename("image.png"), page.Records[0].Filename)
+
+	t.Run("pagination", func(t *testing.T) {
+		options := types.ListOptions{Limit: 2, SortBy: types.SortBySize, Descending: true}
+		var got []types.ID
+		for {
+			page, err := db.ListRecords(options)
+			require.NoError(t, err)
+			got = append(got, ids(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			options.Cursor = page.NextCursor
+		}
+		require.Equal(t, []types.ID{"c", "b", "e", "d", "a"}, got)
+	})
+
+	t.Run("cursor of another sort order", func(t *testing.T) {
+		page, err := db.ListRecords(types.ListOptions{Limit: 1})
+		require.NoError(t, err)
+
+		_, err = db.ListRecords(types.ListOptions{Limit: 1, Cursor: page.NextCursor, Descending: true})
+		require.Equal(t, types.ErrInvalidCursor{Cursor: page.NextCursor}, err)
+
+		_, err = db.ListRecords(types.ListOptions{Cursor: "garbage"})
+		require.Equal(t, types.ErrInvalidCursor{Cursor: "garbage"}, err)
+	})
+}
+
+func TestRecordSize(t *testing.T) {
+	db := fake_db.NewSqlWithChunk(5)
+
+	for _, data := range []string{"1", "12345", "123456789012"} {
+		id := types.ID(fmt.Sprintf("size_%d", len(data)))
+		err := db.InsertRecord(bytes.NewBufferString(data), types.Metadata{ID: id, Filename: "test.txt"})
+		require.NoError(t, err)
+
+		metadata, err := db.GetMetadata(id)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), metadata.Size)
+
+		record, err := db.GetRecord(id)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), record.Size)
+
+		end, err := record.Reader.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), end)
+
+		// reading past the end of the record is EOF, not a missing chunk
+		_, err = record.Reader.Seek(int64(len(data))+3, io.SeekStart)
+		require.NoError(t, err)
+		n, err := record.Reader.Read(make([]byte, 4))
+		require.Equal(t, 0, n)
+		require.Equal(t, io.EOF, err)
+	}
+}
+
+func TestInsertRecordIsAtomic(t *testing.T) {
+	db := fake_db.NewSqlWithChunk(5)
+	id := types.ID("atomic")
+
+	err := db.InsertRecord(&failingReader{data: bytes.NewBufferString("0123456789abc")}, types.Metadata{ID: id, Filename: "test.txt"})
+	require.Error(t, err)
+
+	_, err = db.GetRecord(id)
+	require.Equal(t, types.ErrFileNotExists{ID: id}, err)
+
+	var chunks int
+	err = db.Conn().QueryRow(`SELECT COUNT(*) FROM metadata WHERE id=?`, id).Scan(&chunks)
+	require.NoError(t, err)
+	require.Equal(t, 0, chunks)
+
+	// the ID is free again after the failed insert
+	err = db.InsertRecord(bytes.NewBufferString("0123456789abc"), types.Metadata{ID: id, Filename: "test.txt"})
+	require.NoError(t, err)
+
+	// inserting the same ID again doesn't touch the stored chunks
+	err = db.InsertRecord(bytes.NewBufferString("other"), types.Metadata{ID: id, Filename: "other.txt"})
+	require.Equal(t, types.ErrFileExists{ID: id}, err)
+
+	record, err := db.GetRecord(id)
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789abc", string(content))
+}
+
+func TestSweepOrphans(t *testing.T) {
+	db := fake_db.NewSqlWithChunk(5)
+
+	err := db.InsertRecord(bytes.NewBufferString("kept record"), types.Metadata{ID: "kept", Filename: "test.txt"})
+	require.NoError(t, err)
+
+	err = db.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "upload", Filename: "upload.txt"},
+		Length:    100,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = db.WriteUpload("upload", 0, bytes.NewBufferString("partial"))
+	require.NoError(t, err)
+
+	// chunks written by an older version which crashed before inserting the record
+	for i := 0; i < 3; i++ {
+		_, err = db.Conn().Exec(`INSERT INTO metadata (id, chunk_index, chunk) VALUES (?, ?, ?)`, "legacy", i, []byte("12345"))
+		require.NoError(t, err)
+	}
+
+	// an insert staged an hour ago by a crashed process and one that is still running
+	for id, startedAt := range map[string]time.Time{
+		"crashed": time.Now().Add(-time.Hour),
+		"running": time.Now(),
+	} {
+		_, err = db.Conn().Exec(`INSERT INTO pending_records (id, started_at) VALUES (?, ?)`, id, startedAt.UTC().Format(time.RFC3339))
+		require.NoError(t, err)
+		_, err = db.Conn().Exec(`INSERT INTO metadata (id, chunk_index, chunk) VALUES (?, 0, ?)`, id, []byte("12345"))
+		require.NoError(t, err)
+	}
+
+	deleted, err := db.SweepOrphans(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), deleted)
+
+	count := func(id string) int {
+		var chunks int
+		err := db.Conn().QueryRow(`SELECT COUNT(*) FROM metadata WHERE id=?`, id).Scan(&chunks)
+		require.NoError(t, err)
+		return chunks
+	}
+
+	require.Equal(t, 0, count("legacy"))
+	require.Equal(t, 0, count("crashed"))
+	require.Equal(t, 1, count("running"))
+	require.Equal(t, 3, count("kept"))
+	require.Equal(t, 2, count("upload"))
+}
+
+// sweepingReader removes the staging marker of the record while its content is read,
+// as the sweep of another process opening the database did
+type sweepingReader struct {
+	io.Reader
+	db *db.DB
+	id types.ID
+}
+
+func (r sweepingReader) Read(p []byte) (int, error) {
+	if _, err := r.db.Conn().Exec(`DELETE FROM pending_records WHERE id=?`, r.id); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+func TestSweepWhileWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+	database, err := db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	defer database.Close()
+
+	// opening the database again leaves the records being written alone
+	_, err = database.Conn().Exec(`INSERT INTO pending_records (id, started_at) VALUES (?, ?)`, "inflight", time.Now().UTC().Format(time.RFC3339))
+	require.NoError(t, err)
+	_, err = database.Conn().Exec(`INSERT INTO metadata (id, chunk_index, chunk) VALUES (?, 0, ?)`, "inflight", []byte("12345"))
+	require.NoError(t, err)
+
+	other, err := db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	var chunks int
+	require.NoError(t, database.Conn().QueryRow(`SELECT COUNT(*) FROM metadata WHERE id=?`, "inflight").Scan(&chunks))
+	require.Equal(t, 1, chunks)
+
+	// the record whose staging marker is gone isn't committed
+	reader := sweepingReader{Reader: bytes.NewBufferString("0123456789"), db: database, id: "swept"}
+	err = database.InsertRecord(reader, types.Metadata{ID: "swept", Filename: "swept.txt", CreateAt: time.Now()})
+	require.ErrorContains(t, err, "no longer staged")
+	_, err = database.GetMetadata("swept")
+	require.Equal(t, types.ErrFileNotExists{ID: "swept"}, err)
+
+	require.NoError(t, database.InsertRecord(bytes.NewBufferString("0123456789"), types.Metadata{ID: "versioned", Filename: "versioned.txt", CreateAt: time.Now()}))
+	reader = sweepingReader{Reader: bytes.NewBufferString("abcdefghij"), db: database, id: "versioned"}
+	_, err = database.PutRecordContent("versioned", reader, "text/plain")
+	require.ErrorContains(t, err, "no longer staged")
+	record, err := database.GetRecord("versioned")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(content))
+}
+
+func TestDedupChunks(t *testing.T) {
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 5, Dedup: true})
+
+	// the records share the first two chunks, the last chunk of "b" is a partial one
+	files := map[types.ID]string{
+		"a": "12345abcde12345",
+		"b": "12345abcdeXYZ",
+	}
+	for id, data := range files {
+		err := database.InsertRecord(bytes.NewBufferString(data), types.Metadata{ID: id, Filename: "test.txt"})
+		require.NoError(t, err)
+	}
+
+	for id, data := range files {
+		record, err := database.GetRecord(id)
+		require.NoError(t, err)
+		content, err := io.ReadAll(record.Reader)
+		require.NoError(t, err)
+		require.Equal(t, data, string(content))
+	}
+
+	count := func(query string) int {
+		var n int
+		err := database.Conn().QueryRow(query).Scan(&n)
+		require.NoError(t, err)
+		return n
+	}
+
+	require.Equal(t, 0, count(`SELECT COUNT(*) FROM metadata`))
+	require.Equal(t, 3, count(`SELECT COUNT(*) FROM chunks`))
+	require.Equal(t, 6, count(`SELECT COUNT(*) FROM record_chunks`))
+
+	stats, err := database.Stats()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Records)
+	require.Equal(t, int64(28), stats.LogicalBytes)
+	require.Equal(t, int64(13), stats.StoredBytes)
+	require.InDelta(t, 28.0/13.0, stats.DedupRatio, 0.0001)
+
+	// neither the compression nor the earlier versions count as deduplication
+	plain := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 5, Compression: file.CodecGzip})
+	require.NoError(t, plain.InsertRecord(bytes.NewBufferString(strings.Repeat("a", 100)), types.Metadata{ID: "plain", Filename: "test.txt"}))
+	_, err = plain.PutRecordContent("plain", bytes.NewBufferString(strings.Repeat("b", 100)), "")
+	require.NoError(t, err)
+	stats, err = plain.Stats()
+	require.NoError(t, err)
+	require.Equal(t, float64(1), stats.DedupRatio)
+
+	// every chunk of "a" is still referenced by "b"
+	require.NoError(t, database.DeleteRecord("a"))
+	require.NoError(t, database.PurgeRecord("a"))
+	require.Equal(t, 3, count(`SELECT COUNT(*) FROM chunks`))
+	require.Equal(t, 3, count(`SELECT COUNT(*) FROM record_chunks`))
+
+	record, err := database.GetRecord("b")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, files["b"], string(content))
+
+	require.NoError(t, database.DeleteRecord("b"))
+	require.NoError(t, database.PurgeRecord("b"))
+	require.Equal(t, 0, count(`SELECT COUNT(*) FROM chunks`))
+	require.Equal(t, 0, count(`SELECT COUNT(*) FROM record_chunks`))
+}
+
+func TestCompressedChunks(t *testing.T) {
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 1000, Compression: file.CodecGzip})
+
+	data := strings.Repeat(`{"level":"info","msg":"request served"}`+"\n", 200)
+	err := database.InsertRecord(bytes.NewBufferString(data), types.Metadata{ID: "logs", Filename: "logs.json"})
+	require.NoError(t, err)
+
+	// an incompressible chunk is stored as is
+	err = database.InsertRecord(bytes.NewBufferString("abc"), types.Metadata{ID: "short", Filename: "short.txt"})
+	require.NoError(t, err)
+
+	codecs := func(id string) []string {
+		rows, err := database.Conn().Query(`SELECT codec FROM metadata WHERE id=? ORDER BY chunk_index`, id)
+		require.NoError(t, err)
+		defer rows.Close()
+		var codecs []string
+		for rows.Next() {
+			var codec string
+			require.NoError(t, rows.Scan(&codec))
+			codecs = append(codecs, codec)
+		}
+		return codecs
+	}
+	require.Equal(t, []string{"gzip", "gzip", "gzip", "gzip", "gzip", "gzip", "gzip", "gzip"}, codecs("logs"))
+	require.Equal(t, []string{""}, codecs("short"))
+
+	stats, err := database.Stats()
+	require.NoError(t, err)
+	require.Less(t, stats.StoredBytes, stats.LogicalBytes)
+
+	record, err := database.GetRecord("logs")
+	require.NoError(t, err)
+
+	// seeking maps the logical offset into the middle of a compressed chunk
+	pos, err := record.Reader.Seek(2500, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(2500), pos)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data[2500:], string(content))
+
+	// the chunks written before the compression was enabled are still readable
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk=?, codec='', crc=NULL WHERE id=? AND chunk_index=0`, []byte(data[:1000]), "logs")
+	require.NoError(t, err)
+
+	record, err = database.GetRecord("logs")
+	require.NoError(t, err)
+	content, err = io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func TestResumeCompressedUpload(t *testing.T) {
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 1000, Compression: file.CodecGzip})
+	data := strings.Repeat("compressible ", 300)
+
+	err := database.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "upload", Filename: "upload.txt"},
+		Length:    int64(len(data)),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// the second part starts in the middle of the compressed chunk
+	_, err = database.WriteUpload("upload", 0, bytes.NewBufferString(data[:1500]))
+	require.NoError(t, err)
+	upload, err := database.WriteUpload("upload", 1500, bytes.NewBufferString(data[1500:]))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), upload.Offset)
+
+	record, err := database.GetRecord("upload")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func newMasterKey(t *testing.T, b byte) *db.MasterKey {
+	t.Helper()
+	key, err := db.ParseMasterKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, db.MasterKeySize)))
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncryptedChunks(t *testing.T) {
+	masterKey := newMasterKey(t, 1)
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 1000, Compression: file.CodecGzip, MasterKey: masterKey})
+
+	data := strings.Repeat("secret plaintext ", 200)
+	err := database.InsertRecord(bytes.NewBufferString(data), types.Metadata{ID: "secret", Filename: "secret.txt"})
+	require.NoError(t, err)
+
+	rows, err := database.Conn().Query(`SELECT chunk FROM metadata WHERE id=?`, "secret")
+	require.NoError(t, err)
+	for rows.Next() {
+		var chunk []byte
+		require.NoError(t, rows.Scan(&chunk))
+		require.NotContains(t, string(chunk), "secret")
+	}
+	require.NoError(t, rows.Close())
+
+	read := func(database db.DB, offset int64) (string, error) {
+		record, err := database.GetRecord("secret")
+		if err != nil {
+			return "", err
+		}
+		if _, err := record.Reader.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		content, err := io.ReadAll(record.Reader)
+		return string(content), err
+	}
+
+	content, err := read(*database, 1500)
+	require.NoError(t, err)
+	require.Equal(t, data[1500:], content)
+
+	// the data key can't be unwrapped without the master key
+	_, err = read(database.WithMasterKey(nil), 0)
+	require.Error(t, err)
+	_, err = read(database.WithMasterKey(newMasterKey(t, 2)), 0)
+	require.Error(t, err)
+
+	// a chunk moved to another position fails the authentication
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk_index=chunk_index+10 WHERE id=? AND chunk_index=0`, "secret")
+	require.NoError(t, err)
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk_index=0 WHERE id=? AND chunk_index=1`, "secret")
+	require.NoError(t, err)
+	_, err = read(*database, 0)
+	require.Error(t, err)
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk_index=1 WHERE id=? AND chunk_index=0`, "secret")
+	require.NoError(t, err)
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk_index=0 WHERE id=? AND chunk_index=10`, "secret")
+	require.NoError(t, err)
+
+	// the rotation re-wraps the data keys, the chunks stay as they are
+	newKey := newMasterKey(t, 2)
+	rotated, err := database.RotateMasterKey(newKey)
+	require.NoError(t, err)
+	require.Equal(t, 1, rotated)
+
+	_, err = read(*database, 0)
+	require.Error(t, err)
+	content, err = read(database.WithMasterKey(newKey), 0)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	require.NoError(t, database.WithMasterKey(newKey).DeleteRecord("secret"))
+	require.NoError(t, database.WithMasterKey(newKey).PurgeRecord("secret"))
+	var keys int
+	require.NoError(t, database.Conn().QueryRow(`SELECT COUNT(*) FROM data_keys`).Scan(&keys))
+	require.Equal(t, 0, keys)
+}
+
+func TestResumeEncryptedUpload(t *testing.T) {
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 10, MasterKey: newMasterKey(t, 1)})
+	data := "encrypted resumable upload"
+
+	err := database.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "upload", Filename: "upload.txt"},
+		Length:    int64(len(data)),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// the second part rewrites the partial chunk left by the first one
+	_, err = database.WriteUpload("upload", 0, bytes.NewBufferString(data[:15]))
+	require.NoError(t, err)
+	_, err = database.WriteUpload("upload", 15, bytes.NewBufferString(data[15:]))
+	require.NoError(t, err)
+
+	record, err := database.GetRecord("upload")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func TestResumeCompressedEncryptedUpload(t *testing.T) {
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 64, Compression: file.CodecGzip, MasterKey: newMasterKey(t, 1)})
+	data := strings.Repeat("a", 80) + strings.Repeat("b", 48)
+
+	err := database.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "upload", Filename: "upload.txt"},
+		Length:    int64(len(data)),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	tail := func() []byte {
+		var chunk []byte
+		err := database.Conn().QueryRow(`SELECT chunk FROM metadata WHERE id=? AND chunk_index=1`, "upload").Scan(&chunk)
+		require.NoError(t, err)
+		return chunk
+	}
+
+	// the rewritten tail chunk is sealed under the same data key, so it must get a nonce of its own
+	// even when its compressed length doesn't change
+	_, err = database.WriteUpload("upload", 0, bytes.NewBufferString(data[:80]))
+	require.NoError(t, err)
+	before := tail()
+	_, err = database.WriteUpload("upload", 80, bytes.NewBufferString(data[80:]))
+	require.NoError(t, err)
+	after := tail()
+	require.NotEqual(t, before[:12], after[:12])
+
+	record, err := database.GetRecord("upload")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func TestFsck(t *testing.T) {
+	database := fake_db.NewSqlWithChunk(5)
+
+	for _, id := range []types.ID{"intact", "corrupt", "missing", "tampered"} {
+		err := database.InsertRecord(bytes.NewBufferString("checksummed content"), types.Metadata{ID: id, Filename: "test.txt"})
+		require.NoError(t, err)
+	}
+
+	metadata, err := database.GetMetadata("intact")
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("checksummed content"))
+	require.Equal(t, hex.EncodeToString(digest[:]), metadata.SHA256)
+
+	// a flipped byte fails the chunk CRC
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk=? WHERE id=? AND chunk_index=1`, []byte("XXXXX"), "corrupt")
+	require.NoError(t, err)
+	_, err = database.Conn().Exec(`DELETE FROM metadata WHERE id=? AND chunk_index=2`, "missing")
+	require.NoError(t, err)
+	// a chunk rewritten together with its CRC is caught by the digest of the whole content
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk=?, crc=NULL WHERE id=? AND chunk_index=0`, []byte("CHECK"), "tampered")
+	require.NoError(t, err)
+
+	record, err := database.GetRecord("corrupt")
+	require.NoError(t, err)
+	_, err = io.ReadAll(record.Reader)
+	require.Equal(t, types.ErrChunkCorrupt{ID: "corrupt", Index: 1}, err)
+
+	report, err := database.Fsck(false)
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Checked)
+	require.Equal(t, 0, report.Quarantined)
+	require.Equal(t, []db.CorruptRecord{
+		{ID: "corrupt", Problem: "Chunk 1 of corrupt is corrupt"},
+		{ID: "missing", Problem: "Chunk 2 of missing is missing"},
+		{ID: "tampered", Problem: "SHA-256 digest mismatch"},
+	}, report.Corrupt)
+
+	report, err = database.Fsck(true)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Quarantined)
+
+	// the quarantined records are no longer served or checked again
+	_, err = database.GetRecord("corrupt")
+	require.Equal(t, types.ErrFileNotExists{ID: "corrupt"}, err)
+
+	page, err := database.ListRecords(types.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 1)
+
+	report, err = database.Fsck(true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Checked)
+	require.Empty(t, report.Corrupt)
+}
+
+func TestFsckVersions(t *testing.T) {
+	database := fake_db.NewSqlWithChunk(5)
+
+	err := database.InsertRecord(bytes.NewBufferString("first version"), types.Metadata{ID: "versioned", Filename: "test.txt"})
+	require.NoError(t, err)
+	for _, content := range []string{"second version", "third version"} {
+		_, err = database.PutRecordContent("versioned", bytes.NewBufferString(content), "")
+		require.NoError(t, err)
+	}
+
+	// the earlier version is corrupt, the latest one is intact
+	_, err = database.Conn().Exec(`UPDATE metadata SET chunk=? WHERE id=? AND version=1 AND chunk_index=1`, []byte("XXXXX"), "versioned")
+	require.NoError(t, err)
+
+	report, err := database.Fsck(false)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Checked)
+	require.Equal(t, []db.CorruptRecord{
+		{ID: "versioned", Version: 1, Problem: "Chunk 1 of versioned is corrupt"},
+	}, report.Corrupt)
+
+	report, err = database.Fsck(true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Quarantined)
+
+	// only the corrupt version is no longer served
+	_, err = database.GetRecordVersion("versioned", 1)
+	require.Equal(t, types.ErrVersionNotExists{ID: "versioned", Version: 1}, err)
+
+	record, err := database.GetRecordVersion("versioned", 2)
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "second version", string(content))
+
+	versions, err := database.ListRecordVersions("versioned")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	report, err = database.Fsck(true)
+	require.NoError(t, err)
+	require.Empty(t, report.Corrupt)
+}
+
+func TestFSBlobs(t *testing.T) {
+	root := t.TempDir()
+	blobs, err := blob.NewFS(root)
+	require.NoError(t, err)
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 5, Blobs: blobs})
+
+	err = database.InsertRecord(bytes.NewBufferString("kept on disk"), types.Metadata{ID: "kept", Filename: "kept.txt"})
+	require.NoError(t, err)
+
+	paths, err := filepath.Glob(filepath.Join(root, "blobs", "*", "*", "kept"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	// nothing of the content is stored in the database
+	var chunks int
+	require.NoError(t, database.Conn().QueryRow(`SELECT COUNT(*) FROM metadata`).Scan(&chunks))
+	require.Equal(t, 0, chunks)
+
+	// content staged by a crashed insert
+	w, err := blobs.Create("crashed", 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	deleted, err := database.SweepOrphans(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	staged, err := filepath.Glob(filepath.Join(root, "staging", "*"))
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
+	require.NoError(t, database.DeleteRecord("kept"))
+	require.NoError(t, database.PurgeRecord("kept"))
+	_, err = os.Stat(paths[0])
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestS3Blobs(t *testing.T) {
+	blobs, client := newS3Blobs(t, 5)
+	database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 5, Blobs: blobs})
+
+	err := database.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "resumed", Filename: "resumed.txt"},
+		Length:    17,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// one part is uploaded, the tail of 2 bytes is staged until the next request
+	upload, err := database.WriteUpload("resumed", 0, bytes.NewBufferString("abcdefg"))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), upload.Offset)
+
+	// the record doesn't exist before the multipart upload is completed
+	_, err = client.StatObject(context.Background(), "uploader", "test/blobs/resumed", minio.StatObjectOptions{})
+	require.Error(t, err)
+
+	upload, err = database.WriteUpload("resumed", 7, bytes.NewBufferString("hijklmnopq"))
+	require.NoError(t, err)
+	require.Equal(t, int64(17), upload.Offset)
+
+	record, err := database.GetRecord("resumed")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "abcdefghijklmnopq", string(content))
+
+	// ranged reads after a seek
+	_, err = record.Reader.Seek(-3, io.SeekEnd)
+	require.NoError(t, err)
+	content, err = io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "opq", string(content))
+	require.NoError(t, record.Reader.(io.Closer).Close())
+
+	// content staged by a crashed insert
+	w, err := blobs.Create("crashed", 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	deleted, err := database.SweepOrphans(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	uploads := client.ListIncompleteUploads(context.Background(), "uploader", "test/", true)
+	for upload := range uploads {
+		require.NoError(t, upload.Err)
+		require.Fail(t, "multipart upload is left", upload.Key)
+	}
+
+	require.NoError(t, database.DeleteRecord("resumed"))
+	require.NoError(t, database.PurgeRecord("resumed"))
+	_, err = client.StatObject(context.Background(), "uploader", "test/blobs/resumed", minio.StatObjectOptions{})
+	require.Error(t, err)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	database := fake_db.NewSqlWithChunk(5)
+	err := database.InsertRecord(bytes.NewBufferString("backed up content"), types.Metadata{ID: "backed", Filename: "backed.txt"})
+	require.NoError(t, err)
+
+	for _, gzipped := range []bool{false, true} {
+		var snapshot bytes.Buffer
+		require.NoError(t, database.WriteSnapshot(&snapshot, gzipped))
+
+		if gzipped {
+			require.Equal(t, []byte{0x1f, 0x8b}, snapshot.Bytes()[:2])
+		} else {
+			require.True(t, strings.HasPrefix(snapshot.String(), "SQLite format 3"))
+		}
+
+		path := filepath.Join(t.TempDir(), "restored.db")
+		require.NoError(t, db.Restore(&snapshot, path))
+
+		restored, err := db.NewWithChunkSize(path, 5, false)
+		require.NoError(t, err)
+		record, err := restored.GetRecord("backed")
+		require.NoError(t, err)
+		content, err := io.ReadAll(record.Reader)
+		require.NoError(t, err)
+		require.Equal(t, "backed up content", string(content))
+		require.NoError(t, restored.Close())
+
+		// the backup of the file database without opening it for writing
+		var backup bytes.Buffer
+		require.NoError(t, db.Backup(path, &backup, false))
+		require.NoError(t, db.Restore(&backup, filepath.Join(t.TempDir(), "copy.db")))
+	}
+
+	// nothing is replaced by a broken snapshot
+	path := filepath.Join(t.TempDir(), "kept.db")
+	require.NoError(t, os.WriteFile(path, []byte("kept"), 0o600))
+
+	for _, snapshot := range []string{"", "not a database at all, just some text"} {
+		require.Error(t, db.Restore(strings.NewReader(snapshot), path))
+		kept, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "kept", string(kept))
+	}
+}
+
+func TestMigrateDownDeletesRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+
+	database, err := db.NewWithOptions(path, db.Options{ChunkSize: 5, Dedup: true})
+	require.NoError(t, err)
+	for _, id := range []types.ID{"first", "second"} {
+		require.NoError(t, database.InsertRecord(strings.NewReader("deduplicated content"), types.Metadata{
+			ID:       id,
+			Filename: "dedup.txt",
+			CreateAt: time.Now(),
+		}))
+	}
+	require.NoError(t, database.Close())
+
+	migrator, err := db.OpenMigrator(path)
+	require.NoError(t, err)
+	defer migrator.Close()
+
+	status, err := migrator.Status()
+	require.NoError(t, err)
+	latest := status.Latest()
+
+	// the deduplicated records can't outlive their shared chunks
+	steps, err := migrator.Down(9, true, false)
+	require.NoError(t, err)
+	require.Equal(t, 10, steps[len(steps)-1].Version)
+	require.Contains(t, steps[len(steps)-1].Deleted, db.DeletedRows{Table: "records", Rows: 2})
+
+	// nothing is deleted without the force, the migration stops before the first step deleting rows
+	_, err = migrator.Down(9, false, false)
+	require.ErrorContains(t, err, "applied only when forced")
+	status, err = migrator.Status()
+	require.NoError(t, err)
+	require.Less(t, 10, status.Current)
+	require.LessOrEqual(t, status.Current, latest)
+
+	database, err = db.NewWithOptions(path, db.Options{ChunkSize: 5, Dedup: true})
+	require.NoError(t, err)
+	_, err = database.GetMetadata("first")
+	require.NoError(t, err)
+	require.NoError(t, database.Close())
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrated.db")
+
+	migrator, err := db.OpenMigrator(path)
+	require.NoError(t, err)
+	defer migrator.Close()
+
+	status, err := migrator.Status()
+	require.NoError(t, err)
+	require.Equal(t, 0, status.Current)
+	latest := status.Latest()
+	require.Equal(t, len(status.Migrations), latest)
+
+	version := func() int {
+		status, err := migrator.Status()
+		require.NoError(t, err)
+		return status.Current
+	}
+
+	// the dry run checks every migration and leaves the database as it was
+	steps, err := migrator.Up(0, true)
+	require.NoError(t, err)
+	require.Len(t, steps, latest)
+	require.Equal(t, 0, version())
+
+	steps, err = migrator.Up(5, false)
+	require.NoError(t, err)
+	require.Len(t, steps, 5)
+	require.Equal(t, 5, version())
+
+	_, err = migrator.Up(3, false)
+	require.Error(t, err)
+	_, err = migrator.Up(latest+1, false)
+	require.Error(t, err)
+	_, err = migrator.Down(6, false, false)
+	require.Error(t, err)
+
+	_, err = migrator.Up(0, false)
+	require.NoError(t, err)
+	require.Equal(t, latest, version())
+
+	database, err := db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	require.NoError(t, database.InsertRecord(strings.NewReader("migrated content"), types.Metadata{
+		ID:       "migrated",
+		Filename: "migrated.txt",
+		CreateAt: time.Now(),
+	}))
+	require.NoError(t, database.Close())
+
+	// the dry run reports the rows every step deletes
+	steps, err = migrator.Down(0, true, false)
+	require.NoError(t, err)
+	require.Len(t, steps, latest)
+	require.True(t, steps[0].Down)
+	require.Equal(t, latest, steps[0].Version)
+	require.Empty(t, steps[0].Deleted)
+	require.Equal(t, 1, steps[latest-1].Version)
+	require.Equal(t, []db.DeletedRows{{Table: "records", Rows: 1}}, steps[latest-1].Deleted)
+	require.Equal(t, latest, version())
+
+	// the down migrations deleting the search documents are refused unless forced,
+	// the records keep their content through the down migrations of the newer columns
+	_, err = migrator.Down(8, false, false)
+	require.ErrorContains(t, err, "1 rows of search_documents")
+	require.Less(t, 8, version())
+	_, err = migrator.Down(8, false, true)
+	require.NoError(t, err)
+	require.Equal(t, 8, version())
+	_, err = migrator.Up(0, false)
+	require.NoError(t, err)
+
+	database, err = db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	record, err := database.GetRecord("migrated")
+	require.NoError(t, err)
+	content, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, "migrated content", string(content))
+	require.NoError(t, database.Close())
+
+	_, err = migrator.Down(0, false, false)
+	require.Error(t, err)
+	_, err = migrator.Down(0, false, true)
+	require.NoError(t, err)
+	require.Equal(t, 0, version())
+
+	_, err = migrator.Up(0, false)
+	require.NoError(t, err)
+	require.Equal(t, latest, version())
+}
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.db")
+	database, err := db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	defer database.Close()
+
+	if _, err := database.Search("probe", 0); errors.As(err, &types.ErrSearchUnavailable{}) {
+		t.Skip("SQLite is built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+
+	for _, record := range []struct {
+		metadata types.Metadata
+		content  string
+	}{
+		{types.Metadata{ID: "server-log", Filename: "server.log", ContentType: "text/plain; charset=utf-8"}, "started\npanic: connection refused by upstream\nexiting"},
+		{types.Metadata{ID: "binary", Filename: "dump.bin", ContentType: "application/octet-stream"}, "connection refused"},
+		{types.Metadata{ID: "noted", Filename: "notes.txt", Note: "the connection timed out", ContentType: "text/plain"}, "nothing here"},
+		{types.Metadata{ID: "report", Filename: "connection-report.json", ContentType: "application/json"}, `{"status": "ok"}`},
+	} {
+		record.metadata.CreateAt = time.Now()
+		require.NoError(t, database.InsertRecord(strings.NewReader(record.content), record.metadata))
+	}
+
+	search := func(query string) []types.ID {
+		results, err := database.Search(query, 0)
+		require.NoError(t, err)
+		ids := []types.ID{}
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		return ids
+	}
+
+	// the binary content isn't indexed, the filename weighs more than the note and the content
+	require.Equal(t, []types.ID{"report", "noted", "server-log"}, search("connection"))
+	require.Equal(t, []types.ID{"server-log"}, search("connection refused"))
+	require.Equal(t, []types.ID{"server-log"}, search("upstr"))
+	require.Equal(t, []types.ID{}, search(`"unbalanced AND (`))
+
+	results, err := database.Search("refused", 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Contains(t, results[0].Snippet, "<mark>refused</mark>")
+	require.Equal(t, types.Filename("server.log"), results[0].Filename)
+	require.Greater(t, results[0].Score, 0.0)
+
+	// the index follows the updates and deletes
+	require.NoError(t, database.UpdateRecordMetadata("noted", types.Metadata{Filename: "renamed.txt", Note: "all good"}))
+	require.Equal(t, []types.ID{"report", "server-log"}, search("connection"))
+	require.Equal(t, []types.ID{"noted"}, search("renamed"))
+
+	require.NoError(t, database.DeleteRecord("server-log"))
+	require.Equal(t, []types.ID{}, search("refused"))
+
+	// the index is rebuilt once a build without FTS5 dropped its triggers
+	_, err = database.Conn().Exec(`DROP TRIGGER records_search_update`)
+	require.NoError(t, err)
+	rebuilt, err := db.NewWithChunkSize(path, 5, false)
+	require.NoError(t, err)
+	defer rebuilt.Close()
+	require.NoError(t, rebuilt.UpdateRecordMetadata("noted", types.Metadata{Filename: "notes.txt"}))
+	results, err = rebuilt.Search("notes", 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, types.ID("noted"), results[0].ID)
+}
+
+func TestRecordVersions(t *testing.T) {
+	t.Run("encrypted", func(t *testing.T) {
+		masterKey := newMasterKey(t, 1)
+		database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 10, MasterKey: masterKey})
+
+		err := database.InsertRecord(bytes.NewBufferString("the first version"), types.Metadata{ID: "doc", Filename: "doc.txt"})
+		require.NoError(t, err)
+		_, err = database.PutRecordContent("doc", bytes.NewBufferString("the second version"), "")
+		require.NoError(t, err)
+
+		// every version has its own data key
+		var keys int
+		require.NoError(t, database.Conn().QueryRow(`SELECT COUNT(*) FROM data_keys WHERE id=?`, "doc").Scan(&keys))
+		require.Equal(t, 2, keys)
+
+		// the chunks of the earlier versions are not orphans
+		deleted, err := database.SweepOrphans(time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, int64(0), deleted)
+
+		newKey := newMasterKey(t, 2)
+		rotated, err := database.RotateMasterKey(newKey)
+		require.NoError(t, err)
+		require.Equal(t, 2, rotated)
+
+		for version, want := range map[int]string{1: "the first version", 2: "the second version"} {
+			record, err := database.WithMasterKey(newKey).GetRecordVersion("doc", version)
+			require.NoError(t, err)
+			content, err := io.ReadAll(record.Reader)
+			require.NoError(t, err)
+			require.Equal(t, want, string(content))
+		}
+	})
+
+	t.Run("blobs", func(t *testing.T) {
+		root := t.TempDir()
+		blobs, err := blob.NewFS(root)
+		require.NoError(t, err)
+		database := fake_db.NewSqlWithOptions(db.Options{ChunkSize: 5, Blobs: blobs})
+		require.NoError(t, database.UpdateSettings(types.Settings{MaxVersions: 2}))
+
+		err = database.InsertRecord(bytes.NewBufferString("version 1"), types.Metadata{ID: "doc", Filename: "doc.txt"})
+		require.NoError(t, err)
+		for _, content := range []string{"version 2", "version 3"} {
+			_, err = database.PutRecordContent("doc", bytes.NewBufferString(content), "")
+			require.NoError(t, err)
+		}
+
+		committed := func() []string {
+			paths, err := filepath.Glob(filepath.Join(root, "blobs", "*", "*", "*"))
+			require.NoError(t, err)
+			names := []string{}
+			for _, path := range paths {
+				names = append(names, filepath.Base(path))
+			}
+			return names
+		}
+
+		// the first version is pruned with its blob
+		require.ElementsMatch(t, []string{"doc@v2", "doc@v3"}, committed())
+
+		deleted, err := database.SweepOrphans(time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, int64(0), deleted)
+
+		require.NoError(t, database.DeleteRecord("doc"))
+		require.NoError(t, database.PurgeRecord("doc"))
+		require.Empty(t, committed())
+	})
+}
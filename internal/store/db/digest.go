@@ -0,0 +1,49 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// digestWriter - hashes exactly the bytes accepted by the underlying writer,
+// so the digest matches the stored content even when the write fails halfway
+type digestWriter struct {
+	io.Writer
+	hash hash.Hash
+}
+
+func newDigestWriter(w io.Writer, h hash.Hash) *digestWriter {
+	return &digestWriter{
+		Writer: w,
+		hash:   h,
+	}
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+func (w *digestWriter) digest() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// marshalDigest - the intermediate state of the SHA-256, so an interrupted upload continues the same digest
+func marshalDigest(h hash.Hash) ([]byte, error) {
+	return h.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+func unmarshalDigest(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if len(state) == 0 {
+		return h, nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
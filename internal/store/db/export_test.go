@@ -0,0 +1,14 @@
+package db
+
+import "database/sql"
+
+// Conn exposes the underlying connection to the tests which need to set up the tables by hand
+func (d DB) Conn() *sql.DB {
+	return d.ctx
+}
+
+// WithMasterKey - the same database opened with another master key
+func (d DB) WithMasterKey(key *MasterKey) DB {
+	d.masterKey = key
+	return d
+}
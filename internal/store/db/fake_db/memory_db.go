@@ -10,14 +10,27 @@ import (
 
 const optimizeForLitestream = false
 
-func NewSqlWithChunk(chunkSize int) store.Store {
+func NewSqlWithChunk(chunkSize int) *db.DB {
 	uri := ephemeralDbURI()
-	return db.NewWithChunkSize(uri, chunkSize, optimizeForLitestream)
+	return must(db.NewWithChunkSize(uri, chunkSize, optimizeForLitestream))
+}
+
+func NewSqlWithOptions(options db.Options) *db.DB {
+	uri := ephemeralDbURI()
+	return must(db.NewWithOptions(uri, options))
 }
 
 func New(chunkSize int) store.Store {
 	uri := ephemeralDbURI()
-	return db.New(uri, chunkSize, optimizeForLitestream)
+	return must(db.New(uri, chunkSize, optimizeForLitestream))
+}
+
+// must - the in-memory databases are only opened by the tests, which can't go on without them
+func must[T any](database T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return database
 }
 
 func ephemeralDbURI() string {
@@ -0,0 +1,22 @@
+package file
+
+import (
+	"database/sql"
+	"github.com/denisschmidt/uploader/internal/types"
+	"hash/crc32"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkCRC - the CRC-32C of the chunk as it is stored, after the compression and the encryption
+func chunkCRC(chunk []byte) int64 {
+	return int64(crc32.Checksum(chunk, crcTable))
+}
+
+// verifyChunk - compares the stored chunk with its CRC, the chunks written before the CRCs were recorded have none
+func verifyChunk(id types.ID, idx int, chunk []byte, crc sql.NullInt64) error {
+	if crc.Valid && crc.Int64 != chunkCRC(chunk) {
+		return types.ErrChunkCorrupt{ID: id, Index: idx}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Codec is the compression of a single chunk, it is stored next to every chunk,
+// so the chunks written before the compression was enabled stay readable
+type Codec string
+
+const (
+	CodecNone Codec = ""
+	CodecGzip Codec = "gzip"
+)
+
+// ParseCodec converts the name from the config into a Codec, "none" and "" disable the compression
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "", "none":
+		return CodecNone, nil
+	case string(CodecGzip):
+		return CodecGzip, nil
+	default:
+		return CodecNone, fmt.Errorf("unsupported compression %q", name)
+	}
+}
+
+// compress - encodes the chunk with the codec, the chunk is kept as is when the compression doesn't make it smaller.
+// Returns the stored bytes together with the codec they are encoded with
+func compress(codec Codec, chunk []byte) ([]byte, Codec, error) {
+	if codec == CodecNone {
+		return chunk, CodecNone, nil
+	}
+
+	var buf bytes.Buffer
+	switch codec {
+	case CodecGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(chunk); err != nil {
+			return nil, CodecNone, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, CodecNone, err
+		}
+	default:
+		return nil, CodecNone, fmt.Errorf("unsupported compression %q", codec)
+	}
+
+	if buf.Len() >= len(chunk) {
+		return chunk, CodecNone, nil
+	}
+
+	return buf.Bytes(), codec, nil
+}
+
+// decompress - decodes the chunk stored with the codec
+func decompress(codec Codec, chunk []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return chunk, nil
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(chunk))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+}
@@ -0,0 +1,66 @@
+package file
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+)
+
+// DataKeySize is the size of the per-record AES-256 key the chunks are encrypted with
+const DataKeySize = 32
+
+// NewChunkCipher creates the AES-GCM cipher of the record chunks from its data key
+func NewChunkCipher(dataKey []byte) (cipher.AEAD, error) {
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", DataKeySize, len(dataKey))
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkAdditionalData - binds the chunk to its record and position, so chunks can't be swapped unnoticed
+func chunkAdditionalData(id types.ID, idx int) []byte {
+	data := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(data, uint64(idx))
+	return append(data, id...)
+}
+
+// seal - encrypts the chunk when the cipher is set. The nonce is random and prepended to the sealed chunk,
+// a resumed upload rewrites its last chunk under the same data key, so the nonce can't be derived from the chunk position
+func seal(aead cipher.AEAD, id types.ID, idx int, chunk []byte) ([]byte, error) {
+	if aead == nil {
+		return chunk, nil
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(chunk)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, chunk, chunkAdditionalData(id, idx)), nil
+}
+
+// open - decrypts and authenticates the chunk sealed with the same cipher
+func open(aead cipher.AEAD, id types.ID, idx int, chunk []byte) ([]byte, error) {
+	if aead == nil {
+		return chunk, nil
+	}
+	if len(chunk) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("encrypted chunk %d of %v is too short", idx, id)
+	}
+
+	nonce, sealed := chunk[:aead.NonceSize()], chunk[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, chunkAdditionalData(id, idx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %d of %v: %w", idx, id, err)
+	}
+	return plain, nil
+}
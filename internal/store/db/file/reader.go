@@ -17,20 +17,18 @@ type (
 		offset     int64
 		chunkSize  int64
 		buf        *bytes.Buffer
+		options    Options
 	}
 )
 
-func NewReader(db *sql.DB, id types.ID) (io.ReadSeeker, error) {
-	chunkSize, err := getChunkSize(db, id)
-	if err != nil {
-		return nil, err
-	}
-
-	fileLength, err := getFileLength(db, id, chunkSize)
-	if err != nil {
-		return nil, err
-	}
+// NewReader creates a seekable reader of the data written by Writer for the given ID,
+// chunkSize and fileLength are the values the data was written with
+func NewReader(db *sql.DB, id types.ID, chunkSize int64, fileLength int64) io.ReadSeeker {
+	return NewReaderWithOptions(db, id, chunkSize, fileLength, Options{})
+}
 
+// NewReaderWithOptions creates a reader of the data written by the Writer with the same options
+func NewReaderWithOptions(db *sql.DB, id types.ID, chunkSize int64, fileLength int64, options Options) io.ReadSeeker {
 	return &reader{
 		db:         db,
 		ID:         id,
@@ -38,7 +36,8 @@ func NewReader(db *sql.DB, id types.ID) (io.ReadSeeker, error) {
 		offset:     0,
 		chunkSize:  chunkSize,
 		buf:        bytes.NewBuffer([]byte{}),
-	}, nil
+		options:    options,
+	}
 }
 
 func (r *reader) Read(p []byte) (n int, err error) {
@@ -48,7 +47,7 @@ func (r *reader) Read(p []byte) (n int, err error) {
 		read += n
 		// If buf is empty, check if we've read the entire file
 		if err == io.EOF {
-			if r.offset == r.fileLength {
+			if r.offset >= r.fileLength {
 				// Return EOF if we've reached the end of the file
 				return read, io.EOF
 			}
@@ -67,51 +66,96 @@ func (r *reader) Read(p []byte) (n int, err error) {
 	return read, nil
 }
 
+// Seek follows io.Seeker, seeking past the end is allowed and the next Read returns io.EOF
 func (r *reader) Seek(offset int64, whence int) (int64, error) {
-	// Reset the buf since seeking to a new position invalidates its content
-	r.buf = bytes.NewBuffer([]byte{})
+	// The buf holds the data before r.offset which hasn't been read yet
+	position := r.offset - int64(r.buf.Len())
 
-	// Update the file offset based on the 'whence' parameter
 	switch whence {
 	case io.SeekStart:
-		r.offset = offset
 	case io.SeekCurrent:
-		r.offset += offset
+		offset += position
 	case io.SeekEnd:
-		r.offset = int64(r.fileLength) - offset
+		offset += r.fileLength
 	default:
-		return r.offset, fmt.Errorf("invalid whence value: %d", whence)
+		return position, fmt.Errorf("invalid whence value: %d", whence)
+	}
+
+	if offset < 0 {
+		return position, fmt.Errorf("negative position %d", offset)
 	}
 
+	// Reset the buf since seeking to a new position invalidates its content
+	r.buf = bytes.NewBuffer([]byte{})
+	r.offset = offset
+
 	return r.offset, nil
 }
 
 func (r *reader) populateBuffer() error {
 	// Return EOF if we've reached the end of the file
-	if r.offset == int64(r.fileLength) {
+	if r.offset >= int64(r.fileLength) {
 		return io.EOF
 	}
 	// Calculate the current chunk index based on the file offset and chunk size
 	chunkIndex := r.offset / int64(r.chunkSize)
 
 	// Query the database to retrieve the chunk data for the given ID and chunkIndex
-	var chunk []byte
+	var stored []byte
+	var codec Codec
+	var crc sql.NullInt64
 
-	err := r.db.QueryRow(`
-		SELECT chunk
+	query := `
+		SELECT chunk, codec, crc
 		FROM metadata
-		WHERE id=? AND chunk_index=?
+		WHERE id=? AND version=? AND chunk_index=?
 		ORDER BY
 		    chunk_index ASC 
-	`, r.ID, chunkIndex).Scan(&chunk)
+	`
+	if r.options.Dedup {
+		query = `
+		SELECT chunks.chunk, chunks.codec, chunks.crc
+		FROM record_chunks
+		JOIN chunks ON chunks.hash = record_chunks.hash
+		WHERE record_chunks.id=? AND record_chunks.version=? AND record_chunks.chunk_index=?
+	`
+	}
+
+	err := r.db.QueryRow(query, r.ID, r.options.version(), chunkIndex).Scan(&stored, &codec, &crc)
+	if err == sql.ErrNoRows {
+		return types.ErrChunkMissing{ID: r.ID, Index: int(chunkIndex)}
+	}
+	if err != nil {
+		log.Printf("reading chunk failed: %v", err)
+		return err
+	}
+
+	if err := verifyChunk(r.ID, int(chunkIndex), stored, crc); err != nil {
+		log.Printf("reading chunk failed: %v", err)
+		return err
+	}
+
+	compressed, err := open(r.options.Encryption, r.ID, int(chunkIndex), stored)
 	if err != nil {
 		log.Printf("reading chunk failed: %v", err)
 		return err
 	}
 
+	// The chunk holds chunkSize bytes of the file once decompressed, so the offsets within it stay the same
+	chunk, err := decompress(codec, compressed)
+	if err != nil {
+		log.Printf("decompressing chunk failed: %v", err)
+		return err
+	}
+
 	// Determine the start index within the chunk to read from based on the file offset
 	readStart := r.offset % int64(r.chunkSize)
 
+	// a chunk shorter than the offset within it would never move the reader forward
+	if readStart >= int64(len(chunk)) {
+		return types.ErrChunkCorrupt{ID: r.ID, Index: int(chunkIndex)}
+	}
+
 	// Update the buf with the chunk data starting from the readStart index
 	r.buf = bytes.NewBuffer(chunk[readStart:])
 
@@ -120,44 +164,3 @@ func (r *reader) populateBuffer() error {
 
 	return nil
 }
-
-func getChunkSize(db *sql.DB, id types.ID) (int64, error) {
-	var chunkSize int64
-	if err := db.QueryRow(`
-		SELECT
-		LENGTH(chunk) AS chunk_size
-		FROM
-			metadata
-		WHERE
-			id=?
-		ORDER BY
-			chunk_index ASC
-		LIMIT 1
-	`, id).Scan(&chunkSize); err != nil {
-		return 0, err
-	}
-
-	return chunkSize, nil
-}
-
-func getFileLength(db *sql.DB, id types.ID, chunkSize int64) (int64, error) {
-	var chunkIndex int64
-	var chunkLen int64
-	// retrieve the last chunk index and the length of that chunk
-	if err := db.QueryRow(`
-		SELECT
-			chunk_index,
-			LENGTH(chunk) AS chunk_size
-		FROM
-			metadata
-		WHERE
-			id=?
-		ORDER BY
-			chunk_index DESC
-		LIMIT 1
-	`, id).Scan(&chunkIndex, &chunkLen); err != nil {
-		return 0, err
-	}
-
-	return (chunkSize * chunkIndex) + chunkLen, nil
-}
@@ -1,28 +1,112 @@
 package file
 
 import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"github.com/denisschmidt/uploader/internal/store/db/wrapper"
 	"github.com/denisschmidt/uploader/internal/types"
 	"io"
 )
 
-type writer struct {
-	ctx     wrapper.SqlDB
-	ID      types.ID
-	buf     []byte
-	written int
-}
+type (
+	// Options of the chunk layout, the same options must be used to read the data back
+	Options struct {
+		// Dedup stores the chunks once by their SHA-256 hash in the `chunks` table
+		// and references them from `record_chunks` instead of writing them to `metadata`
+		Dedup bool
+		// Compression of the new chunks, the chunks are read back with the codec stored next to each of them
+		Compression Codec
+		// Encryption seals every chunk with the AES-GCM cipher of the record data key, see NewChunkCipher.
+		// The chunks are compressed before they are encrypted
+		Encryption cipher.AEAD
+		// Version of the record content the chunks belong to, 0 is the first version
+		Version int
+	}
+
+	writer struct {
+		ctx     wrapper.SqlDB
+		ID      types.ID
+		buf     []byte
+		written int
+		options Options
+	}
+)
 
 // NewWriter creates a new Writer for the given ID using the specified SqlDB instance.
 // The data will be split into separate rows in the database, with each row containing at most chunkSize bytes
 func NewWriter(ctx wrapper.SqlDB, id types.ID, chunkLen int) io.WriteCloser {
+	return NewWriterWithOptions(ctx, id, chunkLen, Options{})
+}
+
+// NewWriterWithOptions creates a new Writer which stores the chunks according to the options
+func NewWriterWithOptions(ctx wrapper.SqlDB, id types.ID, chunkLen int, options Options) io.WriteCloser {
 	return &writer{
-		ctx: ctx,
-		ID:  id,
-		buf: make([]byte, chunkLen),
+		ctx:     ctx,
+		ID:      id,
+		buf:     make([]byte, chunkLen),
+		options: options,
 	}
 }
 
+// NewWriterAt creates a Writer that continues the data of the given ID from offset.
+// The chunk containing offset is loaded back into the buf and every chunk from it onwards is removed,
+// so the bytes written past offset by an interrupted writer are replaced by the new data
+func NewWriterAt(ctx wrapper.SqlQueryDB, id types.ID, chunkLen int, offset int64, options Options) (io.WriteCloser, error) {
+	w := &writer{
+		ctx:     ctx,
+		ID:      id,
+		buf:     make([]byte, chunkLen),
+		written: int(offset),
+		options: options,
+	}
+
+	idx := w.written / chunkLen
+	keep := w.written % chunkLen
+
+	if keep != 0 {
+		var stored []byte
+		var codec Codec
+		var crc sql.NullInt64
+		err := ctx.QueryRow(`
+			SELECT chunk, codec, crc
+			FROM metadata
+			WHERE id=? AND version=? AND chunk_index=?
+		`, id, options.version(), idx).Scan(&stored, &codec, &crc)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyChunk(id, idx, stored, crc); err != nil {
+			return nil, err
+		}
+		compressed, err := open(options.Encryption, id, idx, stored)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := decompress(codec, compressed)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) < keep {
+			return nil, fmt.Errorf("chunk %d of %v is shorter than offset %d", idx, id, offset)
+		}
+		copy(w.buf, chunk[:keep])
+	}
+
+	if _, err := ctx.Exec(`
+		DELETE FROM
+			metadata
+		WHERE
+			id=? AND version=? AND chunk_index>=?
+	`, id, options.version(), idx); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
 // Writer is the interface that wraps the basic Write method
 // Write writes len(data) bytes from data to the underlying data stream
 // It returns the number of bytes written from data (0 <= n <= len(data))
@@ -66,23 +150,78 @@ func (w *writer) Close() error {
 // by inserting a new row in the `metadata` table with the associated entry ID, chunk_index, and data chunk
 // =====================================================================================================================
 // id: the unique identifier for the entry this data belongs to.
+// version: the version of the record content.
 // chunk_index: the index of the current data chunk.
-// chunk: the actual data chunk, which is a slice of the buf containing the first n bytes.
+// chunk: the actual data chunk, which is a slice of the buf containing the first n bytes, compressed and encrypted when enabled.
+// codec: the compression of the chunk.
+// crc: the CRC-32C of the stored chunk, verified by the reader.
 func (w *writer) flush(n int) error {
 	idx := w.written / len(w.buf)
-	_, err := w.ctx.Exec(`
+
+	if w.options.Dedup {
+		return w.flushDedup(idx, w.buf[0:n])
+	}
+
+	chunk, codec, err := compress(w.options.Compression, w.buf[0:n])
+	if err != nil {
+		return err
+	}
+	chunk, err = seal(w.options.Encryption, w.ID, idx, chunk)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ctx.Exec(`
 	INSERT INTO
 		metadata
 	(
 		id,
+		version,
+		chunk_index,
+		chunk,
+		codec,
+		crc
+	)
+	VALUES(?,?,?,?,?,?)
+ 	`, w.ID, w.options.version(), idx, chunk, codec, chunkCRC(chunk))
+	return err
+}
+
+// flushDedup - stores the chunk by the hash of its uncompressed data, the `record_chunks_writer` trigger
+// inserts the chunk only when it is not stored yet and references it from the record
+func (w *writer) flushDedup(idx int, data []byte) error {
+	hash := sha256.Sum256(data)
+
+	chunk, codec, err := compress(w.options.Compression, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ctx.Exec(`
+	INSERT INTO
+		record_chunks_writer
+	(
+		id,
+		version,
 		chunk_index,
-		chunk
+		chunk,
+		hash,
+		codec,
+		crc
 	)
-	VALUES(?,?,?)
- 	`, w.ID, idx, w.buf[0:n])
+	VALUES(?,?,?,?,?,?,?)
+ 	`, w.ID, w.options.version(), idx, chunk, hex.EncodeToString(hash[:]), codec, chunkCRC(chunk))
 	return err
 }
 
+// version - the chunks written without a version belong to the first one
+func (o Options) version() int {
+	if o.Version == 0 {
+		return 1
+	}
+	return o.Version
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -26,12 +26,13 @@ type (
 var errMockSqlFailure = errors.New("wrong SQL")
 
 func (db *mockSqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	chunk := args[2].([]byte)
+	// id, version, chunk_index, chunk, ...
+	chunk := args[3].([]byte)
 	chunkCopy := make([]byte, len(chunk))
 	copy(chunkCopy, chunk)
 	db.rows = append(db.rows, mockChunkRow{
 		id:         args[0].(types.ID),
-		chunkIndex: args[1].(int),
+		chunkIndex: args[2].(int),
 		chunk:      chunkCopy,
 	})
 	return nil, db.err
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"log"
+	"time"
+)
+
+type (
+	// CorruptRecord is a record which failed the integrity check, Version is set when it is one of
+	// its earlier versions and 0 when it is the latest one
+	CorruptRecord struct {
+		ID      types.ID
+		Version int
+		Problem string
+	}
+
+	// FsckReport is the result of Fsck, Quarantined is the number of corrupt records and versions moved out of service
+	FsckReport struct {
+		Checked     int
+		Corrupt     []CorruptRecord
+		Quarantined int
+	}
+)
+
+// Fsck reads back every version of every record, verifying the CRC of each chunk and the SHA-256 of the whole content.
+// With quarantine the corrupt records are kept in the database but no longer served, a corrupt earlier version
+// is quarantined on its own and the rest of the record is still served
+func (d DB) Fsck(quarantine bool) (FsckReport, error) {
+	rows, err := d.ctx.Query(`
+		SELECT
+			id
+		FROM
+			records
+		WHERE
+			quarantined_at IS NULL
+		ORDER BY
+			id`)
+	if err != nil {
+		return FsckReport{}, err
+	}
+
+	ids, err := scanIds(rows)
+	if err != nil {
+		return FsckReport{}, err
+	}
+
+	var report FsckReport
+	for _, id := range ids {
+		problem, err := d.verifyRecord(id)
+		if err != nil {
+			return report, err
+		}
+		report.Checked++
+
+		if problem == "" {
+			continue
+		}
+
+		log.Printf("record %s is corrupt: %s", id, problem)
+		report.Corrupt = append(report.Corrupt, CorruptRecord{ID: id, Problem: problem})
+
+		if quarantine {
+			if err := d.quarantineRecord(id); err != nil {
+				return report, err
+			}
+			report.Quarantined++
+		}
+	}
+
+	return report, d.fsckVersions(quarantine, &report)
+}
+
+// fsckVersions - verifies the earlier versions of the records which aren't quarantined
+func (d DB) fsckVersions(quarantine bool, report *FsckReport) error {
+	type version struct {
+		id      types.ID
+		version int
+		size    int64
+		digest  sql.NullString
+		options chunkOptions
+	}
+
+	rows, err := d.ctx.Query(`
+		SELECT
+			v.id,
+			v.version,
+			v.size,
+			v.chunk_size,
+			v.dedup,
+			v.sha256,
+			v.storage
+		FROM
+			record_versions v
+			JOIN records r ON r.id = v.id
+		WHERE
+			v.quarantined_at IS NULL AND r.quarantined_at IS NULL
+		ORDER BY
+			v.id, v.version`)
+	if err != nil {
+		return err
+	}
+
+	// the versions are read before they are checked, so the query doesn't hold the connection meanwhile
+	var versions []version
+	for rows.Next() {
+		var v version
+		if err := rows.Scan(&v.id, &v.version, &v.size, &v.options.chunkSize, &v.options.file.Dedup, &v.digest, &v.options.storage); err != nil {
+			rows.Close()
+			return err
+		}
+		v.options.file.Version = v.version
+		versions = append(versions, v)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		problem, err := d.verifyContent(v.id, v.options, v.size, v.digest)
+		if err != nil {
+			return err
+		}
+
+		if problem == "" {
+			continue
+		}
+
+		log.Printf("version %d of record %s is corrupt: %s", v.version, v.id, problem)
+		report.Corrupt = append(report.Corrupt, CorruptRecord{ID: v.id, Version: v.version, Problem: problem})
+
+		if quarantine {
+			if err := d.quarantineVersion(v.id, v.version); err != nil {
+				return err
+			}
+			report.Quarantined++
+		}
+	}
+
+	return nil
+}
+
+// verifyRecord - reads the record back, returns the description of the problem or "" when the record is intact.
+// The error is returned only when the record can't be checked at all
+func (d DB) verifyRecord(id types.ID) (string, error) {
+	var size int64
+	var options chunkOptions
+	var digest sql.NullString
+
+	err := d.ctx.QueryRow(`
+		SELECT
+			size,
+			chunk_size,
+			dedup,
+			sha256,
+			storage,
+			version
+		FROM
+			records
+		WHERE
+			id=?`, id).Scan(&size, &options.chunkSize, &options.file.Dedup, &digest, &options.storage, &options.file.Version)
+	if err != nil {
+		return "", err
+	}
+
+	return d.verifyContent(id, options, size, digest)
+}
+
+// verifyContent - reads back the content of the record version written with the options
+func (d DB) verifyContent(id types.ID, options chunkOptions, size int64, digest sql.NullString) (string, error) {
+	reader, err := d.openContent(id, options, size)
+	if err != nil {
+		if options.storage != "" && options.storage == d.storage() {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if n != size {
+		return fmt.Sprintf("read %d bytes, want %d", n, size), nil
+	}
+
+	if digest.Valid && digest.String != fmt.Sprintf("%x", h.Sum(nil)) {
+		return "SHA-256 digest mismatch", nil
+	}
+
+	return "", nil
+}
+
+func (d DB) quarantineRecord(id types.ID) error {
+	_, err := d.ctx.Exec(`
+		UPDATE records
+		SET
+			quarantined_at = ?
+		WHERE
+			id=?`, time.Now().UTC().Format(timeFormat), id)
+	return err
+}
+
+func (d DB) quarantineVersion(id types.ID, version int) error {
+	_, err := d.ctx.Exec(`
+		UPDATE record_versions
+		SET
+			quarantined_at = ?
+		WHERE
+			id=? AND version=?`, time.Now().UTC().Format(timeFormat), id, version)
+	return err
+}
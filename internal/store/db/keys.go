@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store/db/file"
+	"github.com/denisschmidt/uploader/internal/store/db/wrapper"
+	"github.com/denisschmidt/uploader/internal/types"
+	"os"
+	"strings"
+)
+
+// MasterKeySize is the size of the AES-256 key wrapping the data keys of the records
+const MasterKeySize = 32
+
+// MasterKey wraps the per-record data keys, only the wrapped data keys are stored in the database
+type MasterKey struct {
+	key []byte
+}
+
+// ParseMasterKey decodes the base64 encoded master key
+func ParseMasterKey(encoded string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	return &MasterKey{key: key}, nil
+}
+
+// ReadMasterKeyFile reads the base64 encoded master key from the file
+func ReadMasterKeyFile(path string) (*MasterKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMasterKey(string(content))
+}
+
+// ID identifies the master key without revealing it, so a data key wrapped by another key is reported clearly
+func (k *MasterKey) ID() string {
+	hash := sha256.Sum256(k.key)
+	return hex.EncodeToString(hash[:8])
+}
+
+func (k *MasterKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrap - encrypts the data key with a random nonce, the nonce is prepended to the result
+func (k *MasterKey) wrap(dataKey []byte) ([]byte, error) {
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (k *MasterKey) unwrap(wrapped []byte) ([]byte, error) {
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped data key is too short")
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+// createDataKey - generates and stores the data key of the record version or upload,
+// returns no cipher when the encryption is disabled
+func (d DB) createDataKey(ctx wrapper.SqlDB, id types.ID, version int) (cipher.AEAD, error) {
+	if d.masterKey == nil {
+		return nil, nil
+	}
+
+	dataKey := make([]byte, file.DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := d.masterKey.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ctx.Exec(`
+	INSERT INTO
+		data_keys
+	(
+		id,
+		version,
+		key_id,
+		wrapped_key
+	)
+	VALUES(?,?,?,?)`, id, version, d.masterKey.ID(), wrapped); err != nil {
+		return nil, err
+	}
+
+	return file.NewChunkCipher(dataKey)
+}
+
+// dataKey - the cipher of the record version or upload, no cipher when its chunks aren't encrypted
+func (d DB) dataKey(id types.ID, version int) (cipher.AEAD, error) {
+	var keyId string
+	var wrapped []byte
+
+	err := d.ctx.QueryRow(`
+		SELECT
+			key_id,
+			wrapped_key
+		FROM
+			data_keys
+		WHERE
+			id=? AND version=?`, id, version).Scan(&keyId, &wrapped)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.masterKey == nil {
+		return nil, fmt.Errorf("%v is encrypted, but no master key is configured", id)
+	}
+	if keyId != d.masterKey.ID() {
+		return nil, fmt.Errorf("data key of %v is wrapped by the master key %s, the configured one is %s", id, keyId, d.masterKey.ID())
+	}
+
+	dataKey, err := d.masterKey.unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of %v: %w", id, err)
+	}
+
+	return file.NewChunkCipher(dataKey)
+}
+
+// RotateMasterKey re-wraps every data key wrapped by the configured master key with the new one.
+// The chunks aren't rewritten, all the keys are re-wrapped in a single transaction,
+// so the store must be reopened with the new key afterwards. Returns the number of re-wrapped keys
+func (d DB) RotateMasterKey(newKey *MasterKey) (int, error) {
+	if d.masterKey == nil {
+		return 0, fmt.Errorf("no master key is configured")
+	}
+
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT
+			id,
+			version,
+			key_id,
+			wrapped_key
+		FROM
+			data_keys`)
+	if err != nil {
+		return 0, err
+	}
+
+	type wrappedKey struct {
+		id      types.ID
+		version int
+		keyId   string
+		wrapped []byte
+	}
+
+	var keys []wrappedKey
+	for rows.Next() {
+		var key wrappedKey
+		if err := rows.Scan(&key.id, &key.version, &key.keyId, &key.wrapped); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if key.keyId != d.masterKey.ID() {
+			return 0, fmt.Errorf("data key of %v is wrapped by the master key %s, the configured one is %s", key.id, key.keyId, d.masterKey.ID())
+		}
+
+		dataKey, err := d.masterKey.unwrap(key.wrapped)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unwrap data key of %v: %w", key.id, err)
+		}
+
+		wrapped, err := newKey.wrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE data_keys
+			SET
+				key_id = ?,
+				wrapped_key = ?
+			WHERE
+				id=? AND version=?`, newKey.ID(), wrapped, key.id, key.version); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"github.com/denisschmidt/uploader/internal/store/db/wrapper"
+	"github.com/denisschmidt/uploader/internal/types"
+)
+
+// The attributes and tags are kept in the `record_attributes` and `record_tags` tables under the ID of the record
+
+// writeLabels - replaces the attributes and tags of the ID, the nil ones are left as they are
+func writeLabels(ctx wrapper.SqlDB, id types.ID, metadata types.Metadata) error {
+	if metadata.Attributes != nil {
+		if _, err := ctx.Exec(`
+		DELETE FROM
+			record_attributes
+		WHERE
+			id=?`, id); err != nil {
+			return err
+		}
+
+		for key, value := range metadata.Attributes {
+			if _, err := ctx.Exec(`
+			INSERT INTO
+				record_attributes
+			(
+				id,
+				key,
+				value
+			)
+			VALUES(?,?,?)`, id, key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if metadata.Tags != nil {
+		if _, err := ctx.Exec(`
+		DELETE FROM
+			record_tags
+		WHERE
+			id=?`, id); err != nil {
+			return err
+		}
+
+		for _, tag := range metadata.Tags {
+			if _, err := ctx.Exec(`
+			INSERT OR IGNORE INTO
+				record_tags
+			(
+				id,
+				tag
+			)
+			VALUES(?,?)`, id, tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readLabels - fills in the attributes and tags of the records with a query per table
+func (d DB) readLabels(records []types.Metadata) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	byId := make(map[types.ID]*types.Metadata, len(records))
+	args := make([]interface{}, len(records))
+	for i := range records {
+		byId[records[i].ID] = &records[i]
+		args[i] = records[i].ID
+	}
+
+	rows, err := d.ctx.Query(`
+		SELECT
+			id,
+			key,
+			value
+		FROM
+			record_attributes
+		WHERE
+			id IN (`+placeholders(len(args))+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id types.ID
+		var key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return err
+		}
+
+		metadata := byId[id]
+		if metadata.Attributes == nil {
+			metadata.Attributes = make(map[string]string)
+		}
+		metadata.Attributes[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = d.ctx.Query(`
+		SELECT
+			id,
+			tag
+		FROM
+			record_tags
+		WHERE
+			id IN (`+placeholders(len(args))+`)
+		ORDER BY
+			tag`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id types.ID
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+
+		metadata := byId[id]
+		metadata.Tags = append(metadata.Tags, tag)
+	}
+	return rows.Err()
+}
+
+// labelConditions - the conditions of ListRecords matching the records having all the attributes and tags of the options
+func labelConditions(options types.ListOptions) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	for key, value := range options.Attributes {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM record_attributes a WHERE a.id = records.id AND a.key = ? AND a.value = ?)")
+		args = append(args, key, value)
+	}
+
+	for _, tag := range options.Tags {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM record_tags t WHERE t.id = records.id AND t.tag = ?)")
+		args = append(args, tag)
+	}
+
+	return conditions, args
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sortColumns - the columns of the `records` table the records can be ordered by
+var sortColumns = map[types.SortField]string{
+	types.SortByCreateAt: "create_at",
+	types.SortByFilename: "filename",
+	types.SortBySize:     "size",
+}
+
+// ListRecords returns a page of not expired records matching the options, either the ones in service or in the trash.
+// Pagination is keyset based, the cursor holds the sort key and ID of the last returned record,
+// so the pages stay stable while the records are inserted or deleted
+func (d DB) ListRecords(options types.ListOptions) (types.RecordsPage, error) {
+	options = store.NormalizeListOptions(options)
+
+	column, ok := sortColumns[options.SortBy]
+	if !ok {
+		return types.RecordsPage{}, fmt.Errorf("unsupported sort field %q", options.SortBy)
+	}
+
+	conditions := []string{"(expires_at IS NULL OR expires_at > ?)", "quarantined_at IS NULL", "deleted_at IS NULL"}
+	args := []interface{}{time.Now().UTC().Format(timeFormat)}
+	if options.Trashed {
+		conditions[2] = "deleted_at IS NOT NULL"
+	}
+
+	if options.ContentType != "" {
+		conditions = append(conditions, "content_type = ?")
+		args = append(args, options.ContentType)
+	}
+	if options.FilenamePrefix != "" {
+		// substr is case-sensitive unlike LIKE and doesn't need escaping of the wildcards
+		conditions = append(conditions, "substr(filename, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(options.FilenamePrefix), options.FilenamePrefix)
+	}
+	if !options.CreatedAfter.IsZero() {
+		conditions = append(conditions, "create_at >= ?")
+		args = append(args, options.CreatedAfter.UTC().Format(timeFormat))
+	}
+	if !options.CreatedBefore.IsZero() {
+		conditions = append(conditions, "create_at < ?")
+		args = append(args, options.CreatedBefore.UTC().Format(timeFormat))
+	}
+	if options.MinSize > 0 {
+		conditions = append(conditions, "size >= ?")
+		args = append(args, options.MinSize)
+	}
+	if options.MaxSize > 0 {
+		conditions = append(conditions, "size <= ?")
+		args = append(args, options.MaxSize)
+	}
+	if options.BatchID != "" {
+		conditions = append(conditions, "batch_id = ?")
+		args = append(args, options.BatchID)
+	}
+
+	labels, labelArgs := labelConditions(options)
+	conditions = append(conditions, labels...)
+	args = append(args, labelArgs...)
+
+	direction := "ASC"
+	comparison := ">"
+	if options.Descending {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	if options.Cursor != "" {
+		cursor, err := store.DecodeCursor(options)
+		if err != nil {
+			return types.RecordsPage{}, err
+		}
+
+		var value interface{} = cursor.Value
+		if options.SortBy == types.SortBySize {
+			size, err := strconv.ParseInt(cursor.Value, 10, 64)
+			if err != nil {
+				return types.RecordsPage{}, types.ErrInvalidCursor{Cursor: options.Cursor}
+			}
+			value = size
+		}
+
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison))
+		args = append(args, value, value, cursor.ID)
+	}
+
+	args = append(args, options.Limit+1)
+
+	rows, err := d.ctx.Query(`
+		SELECT
+			id,
+			filename,
+			note,
+			content_type,
+			create_at,
+			expires_at,
+			size,
+			sha256,
+			version,
+			modified_at,
+			deleted_at,
+			batch_id,
+			path
+		FROM
+			records
+		WHERE
+			`+strings.Join(conditions, " AND ")+`
+		ORDER BY
+			`+column+` `+direction+`,
+			id `+direction+`
+		LIMIT ?`, args...)
+	if err != nil {
+		return types.RecordsPage{}, err
+	}
+	defer rows.Close()
+
+	records := []types.Metadata{}
+	for rows.Next() {
+		var metadata types.Metadata
+		var note sql.NullString
+		var contentType sql.NullString
+		var createAtTime string
+		var expiresAtTime sql.NullString
+		var digest sql.NullString
+		var modifiedAtTime sql.NullString
+		var deletedAtTime sql.NullString
+		var batchID sql.NullString
+
+		if err := rows.Scan(
+			&metadata.ID,
+			&metadata.Filename,
+			&note,
+			&contentType,
+			&createAtTime,
+			&expiresAtTime,
+			&metadata.Size,
+			&digest,
+			&metadata.Version,
+			&modifiedAtTime,
+			&deletedAtTime,
+			&batchID,
+			&metadata.Path,
+		); err != nil {
+			return types.RecordsPage{}, err
+		}
+
+		metadata.Note = types.Note(note.String)
+		metadata.ContentType = types.ContentType(contentType.String)
+		metadata.SHA256 = digest.String
+		metadata.BatchID = types.ID(batchID.String)
+
+		if metadata.CreateAt, err = time.Parse(timeFormat, createAtTime); err != nil {
+			return types.RecordsPage{}, err
+		}
+		if metadata.ExpiresAt, err = parseNullTime(expiresAtTime); err != nil {
+			return types.RecordsPage{}, err
+		}
+		if metadata.ModifiedAt, err = parseNullTime(modifiedAtTime); err != nil {
+			return types.RecordsPage{}, err
+		}
+		if metadata.DeletedAt, err = parseNullTime(deletedAtTime); err != nil {
+			return types.RecordsPage{}, err
+		}
+
+		records = append(records, metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return types.RecordsPage{}, err
+	}
+
+	if err := d.readLabels(records); err != nil {
+		return types.RecordsPage{}, err
+	}
+
+	page := types.RecordsPage{
+		Records: records,
+	}
+
+	if len(records) > options.Limit {
+		page.Records = records[:options.Limit]
+		page.NextCursor = store.EncodeCursor(nextCursor(options, page.Records[options.Limit-1]))
+	}
+
+	return page, nil
+}
+
+func nextCursor(options types.ListOptions, last types.Metadata) store.Cursor {
+	cursor := store.Cursor{
+		SortBy:     options.SortBy,
+		Descending: options.Descending,
+		ID:         last.ID,
+	}
+
+	switch options.SortBy {
+	case types.SortByFilename:
+		cursor.Value = string(last.Filename)
+	case types.SortBySize:
+		cursor.Value = strconv.FormatInt(last.Size, 10)
+	default:
+		cursor.Value = last.CreateAt.UTC().Format(timeFormat)
+	}
+
+	return cursor
+}
@@ -0,0 +1,425 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const downSuffix = ".down.sql"
+
+//go:embed migrations/*.sql
+var migrationsFs embed.FS // is an embedded filesystem that contains the migration SQL files
+
+// Migration - the version is the number the file name starts with,
+// the down script reverts the database to the previous version
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStep - a migration applied or reverted by Migrator.Up or Migrator.Down,
+// Deleted are the rows the down script removes, it is counted only for the down steps
+type MigrationStep struct {
+	Migration
+	Down    bool
+	Deleted []DeletedRows
+}
+
+// DeletedRows - the number of rows a table loses, the rows of a dropped table are lost as a whole
+type DeletedRows struct {
+	Table string
+	Rows  int64
+}
+
+func (d DeletedRows) String() string {
+	return fmt.Sprintf("%d rows of %s", d.Rows, d.Table)
+}
+
+func (s MigrationStep) String() string {
+	direction := "up"
+	if s.Down {
+		direction = "down"
+	}
+	return fmt.Sprintf("%03d-%s %s", s.Version, s.Name, direction)
+}
+
+// MigrationStatus - Current is the user_version of the database, 0 for an empty one
+type MigrationStatus struct {
+	Current    int
+	Migrations []Migration
+}
+
+func (s MigrationStatus) Latest() int {
+	if len(s.Migrations) == 0 {
+		return 0
+	}
+	return s.Migrations[len(s.Migrations)-1].Version
+}
+
+// loadMigrations - the embedded migrations ordered by version, every one needs its down script
+func loadMigrations() ([]Migration, error) {
+	dirname := "migrations"
+
+	entries, err := migrationsFs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		filename := entry.Name()
+		down := strings.HasSuffix(filename, downSuffix)
+		name := strings.TrimSuffix(strings.TrimSuffix(filename, downSuffix), ".sql")
+
+		number, rest, ok := strings.Cut(name, "-")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration version is wrong: %s", filename)
+		}
+
+		query, err := migrationsFs.ReadFile(path.Join(dirname, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: rest}
+			byVersion[version] = migration
+		}
+		if migration.Name != rest {
+			return nil, fmt.Errorf("migration %d has the scripts of %s and %s", version, migration.Name, rest)
+		}
+
+		if down {
+			migration.down = string(query)
+		} else {
+			migration.up = string(query)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if migration.up == "" {
+			return nil, fmt.Errorf("migration %03d-%s has no up script", migration.Version, migration.Name)
+		}
+		if migration.down == "" {
+			return nil, fmt.Errorf("migration %03d-%s has no down script", migration.Version, migration.Name)
+		}
+	}
+
+	return migrations, nil
+}
+
+func userVersion(ctx *sql.DB) (int, error) {
+	var version int
+	if err := ctx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get user_version: %w", err)
+	}
+	return version, nil
+}
+
+// migrateToLatest - applies the pending migrations when the database is opened.
+// A database migrated by a newer build is left as it is
+func migrateToLatest(ctx *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("error loading database migrations: %w", err)
+	}
+
+	current, err := userVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+	if current > latest {
+		log.Printf("database version %d is newer than the latest known migration %d", current, latest)
+		return nil
+	}
+
+	log.Printf("start migration stats: %d/%d", current, latest)
+	if _, err := migrate(ctx, migrations, current, latest, false, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrate - moves the database from the current version to the target one.
+// Every step is committed on its own, so a failure leaves the database at the last version that succeeded.
+// The dry run applies all the steps in a single transaction and rolls it back, reporting the rows they delete.
+// The down step deleting any rows is refused unless forced
+func migrate(ctx *sql.DB, migrations []Migration, current, target int, dryRun bool, force bool) ([]MigrationStep, error) {
+	var steps []MigrationStep
+	for _, migration := range migrations {
+		if current < target && migration.Version > current && migration.Version <= target {
+			steps = append(steps, MigrationStep{Migration: migration})
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migration := migrations[i]; current > target && migration.Version <= current && migration.Version > target {
+			steps = append(steps, MigrationStep{Migration: migration, Down: true})
+		}
+	}
+
+	if dryRun {
+		return steps, dryRunSteps(ctx, steps)
+	}
+
+	for i, step := range steps {
+		// starts a new transaction with an empty context and default transaction options
+		// if any operation within the transaction fails, the whole transaction will be rolled back
+		// ensuring the database remains in a consistent state.
+		tx, err := ctx.BeginTx(context.Background(), nil)
+		if err != nil {
+			return steps[:i], fmt.Errorf("failed to create transaction %d: %w", step.Version, err)
+		}
+
+		if err := applyStep(tx, &steps[i]); err != nil {
+			tx.Rollback()
+			return steps[:i], err
+		}
+
+		if len(steps[i].Deleted) > 0 && !force {
+			tx.Rollback()
+			return steps[:i], fmt.Errorf("migration %s deletes %s, it is applied only when forced", step, joinDeleted(steps[i].Deleted))
+		}
+
+		if err = tx.Commit(); err != nil {
+			return steps[:i], fmt.Errorf("failed to commit migration %s: %w", step, err)
+		}
+
+		log.Printf("end migration %s", step)
+	}
+
+	return steps, nil
+}
+
+func dryRunSteps(ctx *sql.DB, steps []MigrationStep) error {
+	tx, err := ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i := range steps {
+		if err := applyStep(tx, &steps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyStep - runs the script of the step, the rows deleted by a down script are recorded in the step
+func applyStep(tx *sql.Tx, step *MigrationStep) error {
+	query, version := step.up, step.Version
+	if step.Down {
+		query, version = step.down, step.Version-1
+	}
+
+	var before map[string]int64
+	if step.Down {
+		var err error
+		if before, err = countRows(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed to perform DB migration %s: %w", step, err)
+	}
+
+	if step.Down {
+		after, err := countRows(tx)
+		if err != nil {
+			return err
+		}
+		step.Deleted = deletedRows(before, after)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`pragma user_version=%d`, version)); err != nil {
+		return fmt.Errorf("failed to update DB version to %d: %w", version, err)
+	}
+	return nil
+}
+
+// countRows - the number of rows of every table. The virtual tables and their shadow tables are left out,
+// they hold the search index which is built again from the records
+func countRows(tx *sql.Tx) (map[string]int64, error) {
+	rows, err := tx.Query(`
+		SELECT
+			name,
+			COALESCE(sql, '')
+		FROM
+			sqlite_master
+		WHERE
+			type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]string{}
+	var virtual []string
+	for rows.Next() {
+		var name, schema string
+		if err := rows.Scan(&name, &schema); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables[name] = schema
+		if strings.HasPrefix(strings.ToUpper(schema), "CREATE VIRTUAL TABLE") {
+			virtual = append(virtual, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for name := range tables {
+		shadow := false
+		for _, v := range virtual {
+			shadow = shadow || name == v || strings.HasPrefix(name, v+"_")
+		}
+		if shadow {
+			continue
+		}
+
+		var count int64
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "` + name + `"`).Scan(&count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return counts, nil
+}
+
+// deletedRows - the rows the tables lost between the counts, ordered by the table name
+func deletedRows(before, after map[string]int64) []DeletedRows {
+	var deleted []DeletedRows
+	for table, rows := range before {
+		if lost := rows - after[table]; lost > 0 {
+			deleted = append(deleted, DeletedRows{Table: table, Rows: lost})
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Table < deleted[j].Table
+	})
+	return deleted
+}
+
+func joinDeleted(deleted []DeletedRows) string {
+	parts := make([]string, len(deleted))
+	for i, d := range deleted {
+		parts[i] = d.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Migrator moves the database between the versions of the migrations without opening it as a store,
+// so nothing else touches the database, e.g. the sweep of the orphaned chunks
+type Migrator struct {
+	ctx        *sql.DB
+	migrations []Migration
+}
+
+// OpenMigrator opens the database at path, which doesn't have to exist yet
+func OpenMigrator(path string) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database migrations: %w", err)
+	}
+
+	ctx, err := open(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		ctx:        ctx,
+		migrations: migrations,
+	}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.ctx.Close()
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	current, err := userVersion(m.ctx)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{
+		Current:    current,
+		Migrations: m.migrations,
+	}, nil
+}
+
+// Up applies the migrations up to the target version, 0 is the latest one
+func (m *Migrator) Up(target int, dryRun bool) ([]MigrationStep, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	if target == 0 {
+		target = status.Latest()
+	}
+	if target > status.Latest() {
+		return nil, fmt.Errorf("there is no migration %d, the latest one is %d", target, status.Latest())
+	}
+	if target < status.Current {
+		return nil, fmt.Errorf("the database is at version %d, migrating it down to %d needs the down command", status.Current, target)
+	}
+
+	return migrate(m.ctx, m.migrations, status.Current, target, dryRun, false)
+}
+
+// Down reverts the migrations newer than the target version, 0 reverts all of them.
+// The down scripts deleting any rows are refused unless forced, the dry run reports the rows every step deletes
+func (m *Migrator) Down(target int, dryRun bool, force bool) ([]MigrationStep, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	if target < 0 {
+		return nil, fmt.Errorf("version %d is negative", target)
+	}
+	if status.Current > status.Latest() {
+		return nil, fmt.Errorf("the database is at version %d, the down scripts are known up to %d", status.Current, status.Latest())
+	}
+	if target > status.Current {
+		return nil, fmt.Errorf("the database is at version %d, migrating it up to %d needs the up command", status.Current, target)
+	}
+
+	return migrate(m.ctx, m.migrations, status.Current, target, dryRun, force)
+}
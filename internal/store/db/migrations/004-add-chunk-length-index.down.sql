@@ -0,0 +1 @@
+DROP INDEX idx_records_data_length;
@@ -0,0 +1,4 @@
+-- The unfinished uploads are lost together with their chunks.
+DELETE FROM metadata WHERE id IN (SELECT id FROM uploads);
+
+DROP TABLE uploads;
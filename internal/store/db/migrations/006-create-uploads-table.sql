@@ -0,0 +1,14 @@
+-- Resumable uploads, the chunks are written to the `metadata` table under the same ID
+-- and the row is promoted to `records` once upload_offset reaches upload_length.
+CREATE TABLE IF NOT EXISTS uploads
+(
+    id            TEXT PRIMARY KEY,
+    filename      TEXT,
+    note          TEXT,
+    content_type  TEXT,
+    create_at     TEXT,
+    expires_at    TEXT,
+    chunk_size    INTEGER NOT NULL,
+    upload_offset INTEGER NOT NULL DEFAULT 0,
+    upload_length INTEGER NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Every record is kept forever again.
+DROP INDEX idx_records_expires_at;
+
+ALTER TABLE records DROP COLUMN expires_at;
@@ -0,0 +1,5 @@
+-- NULL means the record never expires, records uploaded before this migration are kept as they are.
+ALTER TABLE records ADD COLUMN expires_at TEXT;
+
+CREATE INDEX idx_records_expires_at
+    ON records(expires_at);
@@ -0,0 +1,4 @@
+DROP INDEX idx_records_size;
+
+ALTER TABLE records DROP COLUMN chunk_size;
+ALTER TABLE records DROP COLUMN size;
@@ -0,0 +1,29 @@
+-- The size and chunk size of the record are needed to read it back,
+-- keep them with the record instead of querying the chunks every time.
+ALTER TABLE records ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE records ADD COLUMN chunk_size INTEGER NOT NULL DEFAULT 0;
+
+UPDATE records
+SET
+    size = (
+        SELECT
+            IFNULL(SUM(LENGTH(chunk)), 0)
+        FROM
+            metadata
+        WHERE
+            metadata.id = records.id
+    ),
+    chunk_size = (
+        SELECT
+            IFNULL(LENGTH(chunk), 0)
+        FROM
+            metadata
+        WHERE
+            metadata.id = records.id
+        ORDER BY
+            chunk_index ASC
+        LIMIT 1
+    );
+
+CREATE INDEX idx_records_size
+    ON records(size);
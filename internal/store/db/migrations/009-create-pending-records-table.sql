@@ -0,0 +1,7 @@
+-- A record is staged here while its chunks are written, inserting the `records` row commits it.
+-- Chunks that belong to neither `records`, `uploads` nor `pending_records` are orphans.
+CREATE TABLE IF NOT EXISTS pending_records
+(
+    id         TEXT PRIMARY KEY,
+    started_at TEXT NOT NULL
+);
@@ -0,0 +1,16 @@
+-- The deduplicated records can't be read without their shared chunks, they are removed with them.
+DELETE FROM records WHERE dedup = 1;
+
+DROP TRIGGER record_chunks_delete;
+
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+DROP INDEX idx_record_chunks_hash;
+
+DROP TABLE record_chunks;
+
+DROP TABLE chunks;
+
+ALTER TABLE records DROP COLUMN dedup;
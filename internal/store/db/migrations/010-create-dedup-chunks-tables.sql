@@ -0,0 +1,52 @@
+-- Content addressed chunks, stored once and shared by every record that contains them.
+CREATE TABLE IF NOT EXISTS chunks
+(
+    hash      TEXT PRIMARY KEY,
+    chunk     BLOB    NOT NULL,
+    ref_count INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS record_chunks
+(
+    id          TEXT    NOT NULL,
+    chunk_index INTEGER NOT NULL,
+    hash        TEXT    NOT NULL,
+    PRIMARY KEY (id, chunk_index),
+    FOREIGN KEY (hash) REFERENCES chunks (hash)
+);
+
+CREATE INDEX idx_record_chunks_hash
+    ON record_chunks (hash);
+
+-- 1 when the chunks of the record are in `record_chunks` instead of `metadata`.
+ALTER TABLE records ADD COLUMN dedup INTEGER NOT NULL DEFAULT 0;
+
+-- The writer inserts into the view, so storing the chunk and referencing it happen in a single statement.
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count)
+    VALUES (NEW.hash, NEW.chunk, 1)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
+
+-- Deleting a reference frees the chunk once nothing else refers to it.
+CREATE TRIGGER record_chunks_delete
+    AFTER DELETE ON record_chunks
+BEGIN
+    UPDATE chunks SET ref_count = ref_count - 1 WHERE hash = OLD.hash;
+    DELETE FROM chunks WHERE hash = OLD.hash AND ref_count <= 0;
+END;
@@ -0,0 +1,45 @@
+-- The compressed chunks can't be read without their codec, the records and uploads having any of them are removed.
+DELETE FROM records
+WHERE
+    id IN (SELECT id FROM metadata WHERE codec != '') OR
+    id IN (SELECT record_chunks.id FROM record_chunks JOIN chunks ON chunks.hash = record_chunks.hash WHERE chunks.codec != '');
+
+DELETE FROM uploads WHERE id IN (SELECT id FROM metadata WHERE codec != '');
+
+DELETE FROM metadata
+WHERE
+    id NOT IN (SELECT id FROM records) AND
+    id NOT IN (SELECT id FROM uploads) AND
+    id NOT IN (SELECT id FROM pending_records);
+
+-- The `record_chunks_delete` trigger frees the shared chunks nothing refers to anymore.
+DELETE FROM record_chunks WHERE id NOT IN (SELECT id FROM records);
+
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+ALTER TABLE metadata DROP COLUMN codec;
+
+ALTER TABLE chunks DROP COLUMN codec;
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count)
+    VALUES (NEW.hash, NEW.chunk, 1)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
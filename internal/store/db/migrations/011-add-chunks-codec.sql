@@ -0,0 +1,31 @@
+-- The compression of every chunk, empty for the chunks stored as is.
+ALTER TABLE metadata ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE chunks ADD COLUMN codec TEXT NOT NULL DEFAULT '';
+
+-- The writer view gets the codec, the chunk already stored under the hash keeps its own codec.
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash,
+    chunks.codec
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count, codec)
+    VALUES (NEW.hash, NEW.chunk, 1, NEW.codec)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
@@ -0,0 +1,8 @@
+-- The encrypted records and uploads can't be read without their data keys, they are removed with them.
+DELETE FROM records WHERE id IN (SELECT id FROM data_keys);
+
+DELETE FROM uploads WHERE id IN (SELECT id FROM data_keys);
+
+DELETE FROM metadata WHERE id IN (SELECT id FROM data_keys);
+
+DROP TABLE data_keys;
@@ -0,0 +1,8 @@
+-- The data key of every encrypted record or upload, wrapped by the master key with the given key_id.
+-- Rotating the master key re-wraps these keys, the chunks stay as they are.
+CREATE TABLE IF NOT EXISTS data_keys
+(
+    id          TEXT PRIMARY KEY,
+    key_id      TEXT NOT NULL,
+    wrapped_key BLOB NOT NULL
+);
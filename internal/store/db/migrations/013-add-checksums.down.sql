@@ -0,0 +1,38 @@
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+ALTER TABLE metadata DROP COLUMN crc;
+
+ALTER TABLE chunks DROP COLUMN crc;
+
+ALTER TABLE records DROP COLUMN sha256;
+
+-- The quarantined records would be served again.
+DELETE FROM records WHERE quarantined_at IS NOT NULL;
+
+ALTER TABLE records DROP COLUMN quarantined_at;
+
+ALTER TABLE uploads DROP COLUMN sha256_state;
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash,
+    chunks.codec
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count, codec)
+    VALUES (NEW.hash, NEW.chunk, 1, NEW.codec)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
@@ -0,0 +1,39 @@
+-- CRC-32C of every stored chunk and SHA-256 of every record, NULL for the data written before they were recorded.
+ALTER TABLE metadata ADD COLUMN crc INTEGER;
+
+ALTER TABLE chunks ADD COLUMN crc INTEGER;
+
+ALTER TABLE records ADD COLUMN sha256 TEXT;
+
+-- Quarantined records failed the integrity check, they are kept for inspection but not served.
+ALTER TABLE records ADD COLUMN quarantined_at TEXT;
+
+-- The state of the SHA-256 of the bytes received so far, so the digest survives the interruptions.
+ALTER TABLE uploads ADD COLUMN sha256_state BLOB;
+
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash,
+    chunks.codec,
+    chunks.crc
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count, codec, crc)
+    VALUES (NEW.hash, NEW.chunk, 1, NEW.codec, NEW.crc)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
@@ -0,0 +1,9 @@
+-- The content kept in a blob store isn't known to this database anymore, such records and uploads are removed.
+-- The blobs themselves stay in the blob store.
+DELETE FROM records WHERE storage != '';
+
+DELETE FROM uploads WHERE storage != '';
+
+ALTER TABLE records DROP COLUMN storage;
+
+ALTER TABLE uploads DROP COLUMN storage;
@@ -0,0 +1,4 @@
+-- The blob store holding the content, empty when the content is stored as chunks in this database.
+ALTER TABLE records ADD COLUMN storage TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE uploads ADD COLUMN storage TEXT NOT NULL DEFAULT '';
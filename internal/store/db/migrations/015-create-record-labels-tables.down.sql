@@ -0,0 +1,3 @@
+DROP TABLE record_tags;
+
+DROP TABLE record_attributes;
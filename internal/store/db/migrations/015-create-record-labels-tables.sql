@@ -0,0 +1,20 @@
+-- The custom attributes and tags of the records. The rows of an upload are written under its ID
+-- when it is created, so they belong to the record once the upload is complete
+CREATE TABLE IF NOT EXISTS record_attributes
+(
+    id    TEXT NOT NULL,
+    key   TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (id, key)
+);
+
+CREATE INDEX IF NOT EXISTS record_attributes_key_value_idx ON record_attributes (key, value);
+
+CREATE TABLE IF NOT EXISTS record_tags
+(
+    id  TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS record_tags_tag_idx ON record_tags (tag);
@@ -0,0 +1,8 @@
+-- The `records_search` index is left behind, it is rebuilt once the triggers are missing.
+DROP TRIGGER IF EXISTS records_search_insert;
+
+DROP TRIGGER IF EXISTS records_search_update;
+
+DROP TRIGGER IF EXISTS records_search_delete;
+
+DROP TABLE search_documents;
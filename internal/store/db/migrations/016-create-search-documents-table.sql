@@ -0,0 +1,11 @@
+-- Every record has a row here, `doc` is the rowid of the record in the `records_search` FTS5 index
+-- and `content` is the text extracted from the text-like records.
+-- The index itself is created when the database is opened by a build with FTS5.
+CREATE TABLE IF NOT EXISTS search_documents
+(
+    doc     INTEGER PRIMARY KEY,
+    id      TEXT NOT NULL UNIQUE,
+    content TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO search_documents (id) SELECT id FROM records;
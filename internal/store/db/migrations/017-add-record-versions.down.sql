@@ -0,0 +1,90 @@
+-- Only the latest version of every record is kept. The blobs of the earlier versions stay in the blob store.
+DELETE FROM metadata
+WHERE version != COALESCE((SELECT version FROM records WHERE records.id = metadata.id), 1);
+
+DELETE FROM record_chunks
+WHERE version != COALESCE((SELECT version FROM records WHERE records.id = record_chunks.id), 1);
+
+DELETE FROM data_keys
+WHERE version != COALESCE((SELECT version FROM records WHERE records.id = data_keys.id), 1);
+
+DROP TABLE record_versions;
+
+DROP INDEX idx_metadata_version;
+
+ALTER TABLE metadata DROP COLUMN version;
+
+ALTER TABLE records DROP COLUMN version;
+
+ALTER TABLE records DROP COLUMN modified_at;
+
+ALTER TABLE settings DROP COLUMN max_versions;
+
+DROP TRIGGER record_chunks_delete;
+
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+CREATE TABLE record_chunks_unversioned
+(
+    id          TEXT    NOT NULL,
+    chunk_index INTEGER NOT NULL,
+    hash        TEXT    NOT NULL,
+    PRIMARY KEY (id, chunk_index),
+    FOREIGN KEY (hash) REFERENCES chunks (hash)
+);
+
+INSERT INTO record_chunks_unversioned (id, chunk_index, hash)
+SELECT id, chunk_index, hash FROM record_chunks;
+
+DROP TABLE record_chunks;
+
+ALTER TABLE record_chunks_unversioned RENAME TO record_chunks;
+
+CREATE INDEX idx_record_chunks_hash
+    ON record_chunks (hash);
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash,
+    chunks.codec,
+    chunks.crc
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count, codec, crc)
+    VALUES (NEW.hash, NEW.chunk, 1, NEW.codec, NEW.crc)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, chunk_index, hash)
+    VALUES (NEW.id, NEW.chunk_index, NEW.hash);
+END;
+
+CREATE TRIGGER record_chunks_delete
+    AFTER DELETE ON record_chunks
+BEGIN
+    UPDATE chunks SET ref_count = ref_count - 1 WHERE hash = OLD.hash;
+    DELETE FROM chunks WHERE hash = OLD.hash AND ref_count <= 0;
+END;
+
+CREATE TABLE data_keys_unversioned
+(
+    id          TEXT PRIMARY KEY,
+    key_id      TEXT NOT NULL,
+    wrapped_key BLOB NOT NULL
+);
+
+INSERT INTO data_keys_unversioned (id, key_id, wrapped_key)
+SELECT id, key_id, wrapped_key FROM data_keys;
+
+DROP TABLE data_keys;
+
+ALTER TABLE data_keys_unversioned RENAME TO data_keys;
@@ -0,0 +1,103 @@
+-- The content of a record is stored in versions, `records` describes the latest one
+-- and `record_versions` the earlier ones kept by the retention. The chunks, chunk references and data keys
+-- are stored under the ID and the version, the uploads and the existing records are version 1.
+ALTER TABLE records ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- When the content of the latest version was stored, NULL for the first version.
+ALTER TABLE records ADD COLUMN modified_at TEXT;
+
+CREATE TABLE IF NOT EXISTS record_versions
+(
+    id           TEXT    NOT NULL,
+    version      INTEGER NOT NULL,
+    content_type TEXT,
+    create_at    TEXT    NOT NULL,
+    size         INTEGER NOT NULL,
+    chunk_size   INTEGER NOT NULL,
+    dedup        INTEGER NOT NULL DEFAULT 0,
+    sha256       TEXT,
+    storage      TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (id, version)
+);
+
+ALTER TABLE metadata ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX idx_metadata_version
+    ON metadata (id, version, chunk_index);
+
+ALTER TABLE settings ADD COLUMN max_versions INTEGER NOT NULL DEFAULT 10;
+
+-- The primary keys of `record_chunks` and `data_keys` gain the version, so both tables are rebuilt.
+-- Dropping the old `record_chunks` without its trigger keeps the reference counts of the shared chunks.
+DROP TRIGGER record_chunks_delete;
+
+DROP TRIGGER record_chunks_writer_insert;
+
+DROP VIEW record_chunks_writer;
+
+CREATE TABLE record_chunks_versioned
+(
+    id          TEXT    NOT NULL,
+    version     INTEGER NOT NULL DEFAULT 1,
+    chunk_index INTEGER NOT NULL,
+    hash        TEXT    NOT NULL,
+    PRIMARY KEY (id, version, chunk_index),
+    FOREIGN KEY (hash) REFERENCES chunks (hash)
+);
+
+INSERT INTO record_chunks_versioned (id, version, chunk_index, hash)
+SELECT id, 1, chunk_index, hash FROM record_chunks;
+
+DROP TABLE record_chunks;
+
+ALTER TABLE record_chunks_versioned RENAME TO record_chunks;
+
+CREATE INDEX idx_record_chunks_hash
+    ON record_chunks (hash);
+
+CREATE VIEW record_chunks_writer AS
+SELECT
+    record_chunks.id,
+    record_chunks.version,
+    record_chunks.chunk_index,
+    chunks.chunk,
+    chunks.hash,
+    chunks.codec,
+    chunks.crc
+FROM
+    record_chunks
+    JOIN chunks ON chunks.hash = record_chunks.hash;
+
+CREATE TRIGGER record_chunks_writer_insert
+    INSTEAD OF INSERT ON record_chunks_writer
+BEGIN
+    INSERT INTO chunks (hash, chunk, ref_count, codec, crc)
+    VALUES (NEW.hash, NEW.chunk, 1, NEW.codec, NEW.crc)
+    ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1;
+
+    INSERT INTO record_chunks (id, version, chunk_index, hash)
+    VALUES (NEW.id, NEW.version, NEW.chunk_index, NEW.hash);
+END;
+
+CREATE TRIGGER record_chunks_delete
+    AFTER DELETE ON record_chunks
+BEGIN
+    UPDATE chunks SET ref_count = ref_count - 1 WHERE hash = OLD.hash;
+    DELETE FROM chunks WHERE hash = OLD.hash AND ref_count <= 0;
+END;
+
+CREATE TABLE data_keys_versioned
+(
+    id          TEXT    NOT NULL,
+    version     INTEGER NOT NULL DEFAULT 1,
+    key_id      TEXT    NOT NULL,
+    wrapped_key BLOB    NOT NULL,
+    PRIMARY KEY (id, version)
+);
+
+INSERT INTO data_keys_versioned (id, version, key_id, wrapped_key)
+SELECT id, 1, key_id, wrapped_key FROM data_keys;
+
+DROP TABLE data_keys;
+
+ALTER TABLE data_keys_versioned RENAME TO data_keys;
@@ -0,0 +1,6 @@
+-- The records in the trash would be served again, they are removed. Their chunks are left to the orphan sweep.
+DELETE FROM records WHERE deleted_at IS NOT NULL;
+
+DROP INDEX idx_records_deleted_at;
+
+ALTER TABLE records DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+-- The deleted records are kept in the trash until they are restored or purged, they aren't served meanwhile.
+ALTER TABLE records ADD COLUMN deleted_at TEXT;
+
+CREATE INDEX idx_records_deleted_at
+    ON records (deleted_at);
@@ -0,0 +1,5 @@
+-- The records stay, only their batches and paths are forgotten.
+DROP INDEX idx_records_batch_id;
+
+ALTER TABLE records DROP COLUMN path;
+ALTER TABLE records DROP COLUMN batch_id;
@@ -0,0 +1,6 @@
+-- The files uploaded in one request share the batch ID, path is the directory of the file relative to the upload.
+ALTER TABLE records ADD COLUMN batch_id TEXT;
+ALTER TABLE records ADD COLUMN path TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_records_batch_id
+    ON records (batch_id);
@@ -0,0 +1,4 @@
+-- The quarantined versions would be served again.
+DELETE FROM record_versions WHERE quarantined_at IS NOT NULL;
+
+ALTER TABLE record_versions DROP COLUMN quarantined_at;
@@ -0,0 +1,2 @@
+-- Quarantined versions failed the integrity check, they are kept for inspection but not served.
+ALTER TABLE record_versions ADD COLUMN quarantined_at TEXT;
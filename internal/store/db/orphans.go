@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// stalePendingAge - a staged record older than this is considered abandoned by the periodic sweep
+const stalePendingAge = 24 * time.Hour
+
+// SweepOrphans removes the chunks, chunk references and blobs that don't belong to any record version or upload.
+// They are left by the versions which wrote the chunks before the record, or by the inserts and new record versions
+// staged before staleBefore that never finished. Returns the number of deleted chunks and blobs
+func (d DB) SweepOrphans(staleBefore time.Time) (int64, error) {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		pending_records
+	WHERE
+		started_at <= ?`, staleBefore.UTC().Format(timeFormat)); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`
+	DELETE FROM
+		metadata
+	WHERE
+		NOT EXISTS (SELECT 1 FROM records r WHERE r.id = metadata.id AND r.version = metadata.version) AND
+		NOT EXISTS (SELECT 1 FROM record_versions v WHERE v.id = metadata.id AND v.version = metadata.version) AND
+		id NOT IN (SELECT id FROM uploads) AND
+		id NOT IN (SELECT id FROM pending_records)`)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err = tx.Exec(`
+	DELETE FROM
+		record_chunks
+	WHERE
+		NOT EXISTS (SELECT 1 FROM records r WHERE r.id = record_chunks.id AND r.version = record_chunks.version) AND
+		NOT EXISTS (SELECT 1 FROM record_versions v WHERE v.id = record_chunks.id AND v.version = record_chunks.version) AND
+		id NOT IN (SELECT id FROM pending_records)`)
+	if err != nil {
+		return 0, err
+	}
+
+	references, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	deleted += references
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		data_keys
+	WHERE
+		NOT EXISTS (SELECT 1 FROM records r WHERE r.id = data_keys.id AND r.version = data_keys.version) AND
+		NOT EXISTS (SELECT 1 FROM record_versions v WHERE v.id = data_keys.id AND v.version = data_keys.version) AND
+		id NOT IN (SELECT id FROM uploads) AND
+		id NOT IN (SELECT id FROM pending_records)`); err != nil {
+		return 0, err
+	}
+
+	for _, table := range []string{"record_attributes", "record_tags", "search_documents"} {
+		if _, err = tx.Exec(`
+		DELETE FROM
+			` + table + `
+		WHERE
+			id NOT IN (SELECT id FROM records) AND
+			id NOT IN (SELECT id FROM uploads)`); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if deleted > 0 {
+		log.Printf("swept %d orphaned chunks", deleted)
+	}
+
+	blobs, err := d.sweepBlobs(staleBefore)
+	if blobs > 0 {
+		log.Printf("swept %d orphaned blobs", blobs)
+	}
+
+	return deleted + blobs, err
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/denisschmidt/uploader/internal/types"
+	"log"
+	"strings"
+	"time"
+)
+
+// Reaper periodically removes expired records, abandoned uploads, the records kept in the trash
+// longer than the trash retention and orphaned chunks
+type Reaper struct {
+	db             DB
+	batchSize      int
+	trashRetention time.Duration
+	shutdown       chan struct{}
+}
+
+// StartReaper runs the Reaper in the background, every interval it deletes expired data in batches of batchSize records
+// and purges the records moved to the trash more than trashRetention ago
+func (d DB) StartReaper(interval time.Duration, batchSize int, trashRetention time.Duration) *Reaper {
+	reaper := &Reaper{
+		db:             d,
+		batchSize:      batchSize,
+		trashRetention: trashRetention,
+		shutdown:       make(chan struct{}),
+	}
+
+	go reaper.run(interval)
+
+	return reaper
+}
+
+func (r *Reaper) Close() {
+	close(r.shutdown)
+}
+
+func (r *Reaper) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-ticker.C:
+			r.reap(time.Now())
+		}
+	}
+}
+
+func (r *Reaper) reap(now time.Time) {
+	records, err := r.db.DeleteExpiredRecords(now, r.batchSize)
+	if err != nil {
+		log.Printf("failed to delete expired records: %v", err)
+	}
+
+	uploads, err := r.db.DeleteExpiredUploads(now)
+	if err != nil {
+		log.Printf("failed to delete expired uploads: %v", err)
+	}
+
+	if records > 0 || uploads > 0 {
+		log.Printf("reaper deleted %d expired records and %d expired uploads", records, uploads)
+	}
+
+	trashed, err := r.db.DeleteTrashedRecords(now.Add(-r.trashRetention), r.batchSize)
+	if err != nil {
+		log.Printf("failed to purge trashed records: %v", err)
+	}
+	if trashed > 0 {
+		log.Printf("reaper purged %d records from the trash", trashed)
+	}
+
+	if _, err := r.db.SweepOrphans(now.Add(-stalePendingAge)); err != nil {
+		log.Printf("failed to sweep orphaned chunks: %v", err)
+	}
+}
+
+// DeleteExpiredRecords deletes the records expired by now together with their chunks.
+// Every batch is deleted in its own transaction, so the database isn't locked for too long
+func (d DB) DeleteExpiredRecords(now time.Time, batchSize int) (int, error) {
+	return d.deleteRecordsBefore("expires_at", now, batchSize)
+}
+
+// DeleteTrashedRecords permanently deletes the records moved to the trash before the time, in batches like DeleteExpiredRecords
+func (d DB) DeleteTrashedRecords(before time.Time, batchSize int) (int, error) {
+	return d.deleteRecordsBefore("deleted_at", before, batchSize)
+}
+
+// deleteRecordsBefore - deletes the records whose time column is before or at the time in batches of batchSize
+func (d DB) deleteRecordsBefore(column string, before time.Time, batchSize int) (int, error) {
+	deleted := 0
+
+	for {
+		rows, err := d.ctx.Query(`
+			SELECT
+				id
+			FROM
+				records
+			WHERE
+				`+column+` <= ?
+			LIMIT ?`, before.UTC().Format(timeFormat), batchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		ids, err := scanIds(rows)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+
+		if err := d.deleteRecords(ids); err != nil {
+			return deleted, err
+		}
+		deleted += len(ids)
+
+		if len(ids) < batchSize {
+			return deleted, nil
+		}
+	}
+}
+
+func (d DB) deleteRecords(ids []types.ID) error {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	keys, err := d.blobKeys(tx, ids...)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		records
+	WHERE
+		id IN (`+placeholders(len(ids))+`)`, args...); err != nil {
+		return err
+	}
+
+	if err = deleteChunks(tx, ids...); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	d.deleteBlobs(keys...)
+	return nil
+}
+
+// scanIds - reads all the IDs from single column rows and closes them
+func scanIds(rows *sql.Rows) ([]types.ID, error) {
+	defer rows.Close()
+
+	var ids []types.ID
+	for rows.Next() {
+		var id types.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// placeholders - comma separated list of n query parameters for the IN clause
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,39 @@
+package db_test
+
+import (
+	"context"
+	"github.com/denisschmidt/uploader/internal/store/db/blob"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newS3Blobs - the S3 store backed by an in-process S3 stand-in, which is shut down with the test
+func newS3Blobs(t *testing.T, partSize int64) (blob.S3, *minio.Client) {
+	server := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(server.Close)
+
+	// V4 signing streams the bodies over plain HTTP in the aws-chunked encoding the stand-in doesn't decode
+	client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds: credentials.NewStaticV2("access", "secret", ""),
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.MakeBucket(context.Background(), "uploader", minio.MakeBucketOptions{}))
+
+	// the stand-in fails to list the multipart uploads of a bucket that never had one
+	core := minio.Core{Client: client}
+	uploadId, err := core.NewMultipartUpload(context.Background(), "uploader", "init", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	require.NoError(t, core.AbortMultipartUpload(context.Background(), "uploader", "init", uploadId))
+
+	return blob.NewS3(client, blob.S3Options{
+		Bucket:   "uploader",
+		Prefix:   "test/",
+		PartSize: partSize,
+	}), client
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/store/db/wrapper"
+	"github.com/denisschmidt/uploader/internal/types"
+	"log"
+	"strings"
+	"time"
+)
+
+// The full-text search index `records_search` is an FTS5 table over the filename, the note and the content
+// of search_documents, its rowid is search_documents.doc. The triggers keep it in sync with `records`.
+// FTS5 is compiled into the SQLite driver only with the `sqlite_fts5` build tag, so the index isn't part
+// of the migrations: it is created, or rebuilt after a build without FTS5 dropped the triggers, on open
+
+var searchTriggers = []string{"records_search_insert", "records_search_update", "records_search_delete"}
+
+// ensureSearchIndex - reports whether the full-text search is available
+func ensureSearchIndex(ctx *sql.DB) (bool, error) {
+	var available bool
+	if err := ctx.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return false, fmt.Errorf("failed to check FTS5: %w", err)
+	}
+
+	tx, err := ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if !available {
+		// the index can't be written without FTS5, the next build with it rebuilds the index
+		for _, trigger := range searchTriggers {
+			if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + trigger); err != nil {
+				return false, err
+			}
+		}
+		return false, tx.Commit()
+	}
+
+	names := make([]interface{}, len(searchTriggers))
+	for i, trigger := range searchTriggers {
+		names[i] = trigger
+	}
+
+	var triggers int
+	if err := tx.QueryRow(`
+		SELECT
+			COUNT(*)
+		FROM
+			sqlite_master
+		WHERE
+			type='trigger' AND name IN (`+placeholders(len(names))+`)`, names...).Scan(&triggers); err != nil {
+		return false, err
+	}
+	if triggers == len(searchTriggers) {
+		return true, nil
+	}
+
+	log.Printf("building the search index")
+
+	if _, err := tx.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS records_search USING fts5(filename, note, content);
+
+	DELETE FROM records_search;
+
+	INSERT INTO
+		records_search
+	(
+		rowid,
+		filename,
+		note,
+		content
+	)
+	SELECT
+		d.doc,
+		r.filename,
+		r.note,
+		d.content
+	FROM
+		records r
+		JOIN search_documents d ON d.id = r.id;
+
+	DROP TRIGGER IF EXISTS records_search_insert;
+	DROP TRIGGER IF EXISTS records_search_update;
+	DROP TRIGGER IF EXISTS records_search_delete;
+
+	-- the search_documents row is written before the record
+	CREATE TRIGGER records_search_insert AFTER INSERT ON records
+	BEGIN
+		INSERT INTO records_search (rowid, filename, note, content)
+		SELECT doc, new.filename, new.note, content FROM search_documents WHERE id = new.id;
+	END;
+
+	CREATE TRIGGER records_search_update AFTER UPDATE OF filename, note ON records
+	BEGIN
+		UPDATE records_search SET filename = new.filename, note = new.note
+		WHERE rowid = (SELECT doc FROM search_documents WHERE id = new.id);
+	END;
+
+	-- the search_documents row is deleted after the record
+	CREATE TRIGGER records_search_delete AFTER DELETE ON records
+	BEGIN
+		DELETE FROM records_search WHERE rowid = (SELECT doc FROM search_documents WHERE id = old.id);
+	END;
+	`); err != nil {
+		return false, fmt.Errorf("failed to build the search index: %w", err)
+	}
+
+	return true, tx.Commit()
+}
+
+// textCapture - keeps the beginning of the content written to it for the search index
+type textCapture struct {
+	data []byte
+}
+
+func (c *textCapture) Write(p []byte) (int, error) {
+	if room := store.MaxIndexedText - len(c.data); room > 0 {
+		c.data = append(c.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// writeSearchDocument - must be written before the `records` row, content is the extracted text of the record
+func writeSearchDocument(ctx wrapper.SqlDB, id types.ID, content string) error {
+	_, err := ctx.Exec(`
+	INSERT INTO
+		search_documents
+	(
+		id,
+		content
+	)
+	VALUES(?,?)
+	ON CONFLICT(id) DO UPDATE SET content=excluded.content`, id, content)
+	return err
+}
+
+// ftsQuery - every term of the query must match as a prefix, the FTS5 syntax isn't exposed
+func ftsQuery(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search returns the records matching all the terms of the query in the filename, the note or the content,
+// the best match first. The filename weighs the most, then the note
+func (d DB) Search(query string, limit int) ([]types.SearchResult, error) {
+	if !d.search {
+		return nil, types.ErrSearchUnavailable{}
+	}
+
+	match := ftsQuery(query)
+	if match == "" {
+		return []types.SearchResult{}, nil
+	}
+
+	rows, err := d.ctx.Query(`
+		SELECT
+			r.id,
+			r.filename,
+			r.note,
+			r.content_type,
+			r.create_at,
+			r.expires_at,
+			r.size,
+			r.sha256,
+			r.version,
+			r.modified_at,
+			r.batch_id,
+			r.path,
+			snippet(records_search, -1, ?, ?, '…', 16),
+			bm25(records_search, 10.0, 5.0, 1.0) AS score
+		FROM
+			records_search s
+			JOIN search_documents d ON d.doc = s.rowid
+			JOIN records r ON r.id = d.id
+		WHERE
+			records_search MATCH ? AND
+			(r.expires_at IS NULL OR r.expires_at > ?) AND
+			r.quarantined_at IS NULL AND
+			r.deleted_at IS NULL
+		ORDER BY
+			score
+		LIMIT ?`,
+		store.HighlightStart, store.HighlightEnd, match, time.Now().UTC().Format(timeFormat), store.NormalizeSearchLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []types.SearchResult{}
+	for rows.Next() {
+		var result types.SearchResult
+		var note sql.NullString
+		var contentType sql.NullString
+		var createAtTime string
+		var expiresAtTime sql.NullString
+		var digest sql.NullString
+		var modifiedAtTime sql.NullString
+		var batchID sql.NullString
+		var bm25 float64
+
+		if err := rows.Scan(
+			&result.ID,
+			&result.Filename,
+			&note,
+			&contentType,
+			&createAtTime,
+			&expiresAtTime,
+			&result.Size,
+			&digest,
+			&result.Version,
+			&modifiedAtTime,
+			&batchID,
+			&result.Path,
+			&result.Snippet,
+			&bm25,
+		); err != nil {
+			return nil, err
+		}
+
+		result.Note = types.Note(note.String)
+		result.ContentType = types.ContentType(contentType.String)
+		result.SHA256 = digest.String
+		result.BatchID = types.ID(batchID.String)
+		// bm25 is negative, the better the match the lower it is
+		result.Score = -bm25
+
+		if result.CreateAt, err = time.Parse(timeFormat, createAtTime); err != nil {
+			return nil, err
+		}
+		if result.ExpiresAt, err = parseNullTime(expiresAtTime); err != nil {
+			return nil, err
+		}
+		if result.ModifiedAt, err = parseNullTime(modifiedAtTime); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]types.Metadata, len(results))
+	for i := range results {
+		records[i] = results[i].Metadata
+	}
+	if err := d.readLabels(records); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Metadata = records[i]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"github.com/denisschmidt/uploader/internal/types"
+)
+
+// settingsId - the `settings` table holds a single row
+const settingsId = 1
+
+func (d DB) GetSettings() (types.Settings, error) {
+	var defaultExpirationInDays int
+	var maxVersions int
+
+	err := d.ctx.QueryRow(`
+		SELECT
+			default_expiration_in_days,
+			max_versions
+		FROM
+			settings
+		WHERE
+			id=?`, settingsId).Scan(&defaultExpirationInDays, &maxVersions)
+	if err != nil {
+		return types.Settings{}, err
+	}
+
+	return types.Settings{
+		DefaultExpirationInDays: defaultExpirationInDays,
+		MaxVersions:             maxVersions,
+	}, nil
+}
+
+func (d DB) UpdateSettings(settings types.Settings) error {
+	_, err := d.ctx.Exec(`
+		UPDATE settings
+		SET
+			default_expiration_in_days = ?,
+			max_versions = ?
+		WHERE
+			id=?
+	`, settings.DefaultExpirationInDays, settings.MaxVersions, settingsId)
+	return err
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"github.com/denisschmidt/uploader/internal/types"
+)
+
+// Stats returns the size of the records against the size of the chunks stored for them,
+// shared chunks of the deduplicated records are counted once, compressed chunks by their compressed size
+// and the content kept in the blob store by its size. The dedup ratio is the size of the chunk references
+// against the size of the shared chunks
+func (d DB) Stats() (types.StoreStats, error) {
+	var stats types.StoreStats
+
+	if err := d.ctx.QueryRow(`
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(size), 0)
+		FROM
+			records`).Scan(&stats.Records, &stats.LogicalBytes); err != nil {
+		return types.StoreStats{}, err
+	}
+
+	if err := d.ctx.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(LENGTH(chunk)), 0) FROM metadata) +
+			(SELECT COALESCE(SUM(LENGTH(chunk)), 0) FROM chunks) +
+			(SELECT COALESCE(SUM(size), 0) FROM records WHERE storage != '') +
+			(SELECT COALESCE(SUM(size), 0) FROM record_versions WHERE storage != '')`).Scan(&stats.StoredBytes); err != nil {
+		return types.StoreStats{}, err
+	}
+
+	// the ratio only takes the deduplicated chunks in, so the compression and the other versions don't change it
+	var referencedBytes, sharedBytes int64
+	if err := d.ctx.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(LENGTH(c.chunk)), 0) FROM record_chunks rc JOIN chunks c ON c.hash = rc.hash),
+			(SELECT COALESCE(SUM(LENGTH(chunk)), 0) FROM chunks)`).Scan(&referencedBytes, &sharedBytes); err != nil {
+		return types.StoreStats{}, err
+	}
+
+	stats.DedupRatio = 1
+	if sharedBytes > 0 {
+		stats.DedupRatio = float64(referencedBytes) / float64(sharedBytes)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"github.com/denisschmidt/uploader/internal/types"
+	"time"
+)
+
+// The deleted records stay in `records` with deleted_at set, everything else of them is kept as it is
+// until they are purged by PurgeRecord, PurgeTrash or the Reaper
+
+// purgeBatchSize - the number of records PurgeTrash deletes in a single transaction
+const purgeBatchSize = 500
+
+// DeleteRecord moves the record to the trash, a record already in the trash keeps its deletion time
+func (d DB) DeleteRecord(id types.ID) error {
+	_, err := d.ctx.Exec(`
+		UPDATE records
+		SET
+			deleted_at = ?
+		WHERE
+			id=? AND deleted_at IS NULL`, time.Now().UTC().Format(timeFormat), id)
+	return err
+}
+
+// RestoreRecord moves the record back from the trash, expired and quarantined records stay where they are
+func (d DB) RestoreRecord(id types.ID) error {
+	res, err := d.ctx.Exec(`
+		UPDATE records
+		SET
+			deleted_at = NULL
+		WHERE
+			id=? AND (expires_at IS NULL OR expires_at > ?) AND quarantined_at IS NULL AND deleted_at IS NOT NULL`, id, time.Now().UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrFileNotExists{ID: id}
+	}
+
+	return nil
+}
+
+func (d DB) PurgeRecord(id types.ID) error {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keys, err := d.blobKeys(tx, id)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+	DELETE FROM
+		records
+	WHERE
+		id=? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrFileNotExists{ID: id}
+	}
+
+	if err = deleteChunks(tx, id); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	d.deleteBlobs(keys...)
+	return nil
+}
+
+func (d DB) PurgeTrash(before time.Time) (int, error) {
+	return d.DeleteTrashedRecords(before, purgeBatchSize)
+}
@@ -0,0 +1,430 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store/db/file"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// uploadLocks - makes sure only one request at a time appends data to the same upload
+type uploadLocks struct {
+	mu  sync.Mutex
+	ids map[types.ID]struct{}
+}
+
+func newUploadLocks() *uploadLocks {
+	return &uploadLocks{
+		ids: make(map[types.ID]struct{}),
+	}
+}
+
+func (l *uploadLocks) tryLock(id types.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.ids[id]; ok {
+		return false
+	}
+	l.ids[id] = struct{}{}
+	return true
+}
+
+func (l *uploadLocks) unlock(id types.ID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.ids, id)
+}
+
+func (d DB) CreateUpload(upload types.Upload) error {
+	log.Printf("Create a new upload %s", upload.ID)
+
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO
+		uploads
+	(
+		id,
+		filename,
+		note,
+		content_type,
+		create_at,
+		expires_at,
+		chunk_size,
+		storage,
+		upload_offset,
+		upload_length
+	)
+	VALUES(?,?,?,?,?,?,?,?,0,?)`,
+		upload.ID,
+		upload.Filename,
+		upload.Note,
+		upload.ContentType,
+		upload.CreateAt.UTC().Format(timeFormat),
+		upload.ExpiresAt.UTC().Format(timeFormat),
+		d.chunkSize,
+		d.storage(),
+		upload.Length,
+	)
+	if err != nil {
+		log.Printf("failed to insert upload into `uploads` table: %v", err)
+		return err
+	}
+
+	if err = writeLabels(tx, upload.ID, upload.Metadata); err != nil {
+		return err
+	}
+
+	if d.blobs == nil {
+		if _, err = d.createDataKey(tx, upload.ID, 1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d DB) GetUpload(id types.ID) (types.Upload, error) {
+	upload, _, err := d.getUpload(id)
+	if _, ok := err.(types.ErrUploadNotExists); !ok {
+		return upload, err
+	}
+
+	// the upload could already be promoted to a record,
+	// report it as complete so the client doesn't start over
+	metadata, recordErr := d.GetMetadata(id)
+	if recordErr != nil {
+		return types.Upload{}, err
+	}
+
+	return types.Upload{
+		Metadata: metadata,
+		Offset:   metadata.Size,
+		Length:   metadata.Size,
+	}, nil
+}
+
+// WriteUpload appends the data from the reader to the upload, offset must match the number of bytes already received.
+// Everything read before a failure of the reader is kept, so the client can resume from the returned offset
+func (d DB) WriteUpload(id types.ID, offset int64, reader io.Reader) (types.Upload, error) {
+	if !d.uploadLocks.tryLock(id) {
+		return types.Upload{}, types.ErrUploadLocked{ID: id}
+	}
+	defer d.uploadLocks.unlock(id)
+
+	upload, options, err := d.getUpload(id)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	h, err := unmarshalDigest(options.digestState)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	if upload.Offset != offset {
+		return types.Upload{}, types.ErrUploadOffsetMismatch{
+			ID:       id,
+			Expected: upload.Offset,
+			Got:      offset,
+		}
+	}
+
+	w, err := d.uploadWriter(id, options, offset)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	digest := newDigestWriter(w, h)
+	n, copyErr := io.Copy(digest, io.LimitReader(reader, upload.Length-upload.Offset))
+
+	// flush the tail even if the reader failed, these bytes have been received
+	if err := w.Close(); err != nil {
+		return types.Upload{}, err
+	}
+
+	upload.Offset += n
+
+	state, err := marshalDigest(h)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	if _, err := d.ctx.Exec(`
+		UPDATE uploads
+		SET
+			upload_offset = ?,
+			sha256_state = ?
+		WHERE
+			id=?
+	`, upload.Offset, state, id); err != nil {
+		return types.Upload{}, err
+	}
+
+	if copyErr != nil {
+		return upload, copyErr
+	}
+
+	if upload.Offset == upload.Length {
+		if err := w.Commit(); err != nil {
+			return types.Upload{}, err
+		}
+		if err := d.completeUpload(id, digest.digest()); err != nil {
+			return types.Upload{}, err
+		}
+	}
+
+	return upload, nil
+}
+
+// uploadWriter - continues the content of the upload from offset in the storage the upload was created with
+func (d DB) uploadWriter(id types.ID, options uploadOptions, offset int64) (contentWriter, error) {
+	if options.storage != "" {
+		if options.storage != d.storage() {
+			return nil, fmt.Errorf("upload %v is stored in the %q storage, which isn't configured", id, options.storage)
+		}
+		return d.blobs.Create(id, offset)
+	}
+
+	encryption, err := d.dataKey(id, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := file.NewWriterAt(d.ctx, id, options.chunkSize, offset, file.Options{
+		Compression: d.compression,
+		Encryption:  encryption,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chunksWriter{w}, nil
+}
+
+func (d DB) DeleteUpload(id types.ID) error {
+	if !d.uploadLocks.tryLock(id) {
+		return types.ErrUploadLocked{ID: id}
+	}
+	defer d.uploadLocks.unlock(id)
+
+	return d.deleteUpload(id)
+}
+
+// completeUpload - moves the upload into the `records` table, the chunks are already in place
+func (d DB) completeUpload(id types.ID, digest string) error {
+	log.Printf("Complete upload %s", id)
+
+	expiresAt, err := d.recordExpiration(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the content of the resumable uploads isn't indexed, only their filename and note
+	if err = writeSearchDocument(tx, id, ""); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`
+	INSERT INTO
+		records
+	(
+		id,
+		filename,
+		note,
+		content_type,
+		create_at,
+		expires_at,
+		size,
+		chunk_size,
+		sha256,
+		storage
+	)
+	SELECT
+		id,
+		filename,
+		note,
+		content_type,
+		create_at,
+		?,
+		upload_length,
+		chunk_size,
+		?,
+		storage
+	FROM
+		uploads
+	WHERE
+		id=?`, formatNullTime(expiresAt), digest, id); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		uploads
+	WHERE
+		id=?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// uploadOptions - where the content of the upload is written and the digest of the bytes received so far
+type uploadOptions struct {
+	chunkSize   int
+	storage     string
+	digestState []byte
+}
+
+func (d DB) getUpload(id types.ID) (types.Upload, uploadOptions, error) {
+	var filename string
+	var note string
+	var contentType string
+	var createAtTime string
+	var expiresAtTime string
+	var options uploadOptions
+	var offset int64
+	var length int64
+
+	err := d.ctx.QueryRow(`
+		SELECT
+			filename,
+			note,
+			content_type,
+			create_at,
+			expires_at,
+			chunk_size,
+			storage,
+			sha256_state,
+			upload_offset,
+			upload_length
+		FROM
+			uploads
+		WHERE
+			id=?`, id).Scan(&filename, &note, &contentType, &createAtTime, &expiresAtTime, &options.chunkSize, &options.storage, &options.digestState, &offset, &length)
+	if err == sql.ErrNoRows {
+		return types.Upload{}, uploadOptions{}, types.ErrUploadNotExists{ID: id}
+	}
+	if err != nil {
+		return types.Upload{}, uploadOptions{}, err
+	}
+
+	createAt, err := time.Parse(timeFormat, createAtTime)
+	if err != nil {
+		return types.Upload{}, uploadOptions{}, err
+	}
+
+	expiresAt, err := time.Parse(timeFormat, expiresAtTime)
+	if err != nil {
+		return types.Upload{}, uploadOptions{}, err
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return types.Upload{}, uploadOptions{}, types.ErrUploadNotExists{ID: id}
+	}
+
+	records := []types.Metadata{{
+		ID:          id,
+		Filename:    types.Filename(filename),
+		Note:        types.Note(note),
+		ContentType: types.ContentType(contentType),
+		CreateAt:    createAt,
+	}}
+	if err := d.readLabels(records); err != nil {
+		return types.Upload{}, uploadOptions{}, err
+	}
+
+	return types.Upload{
+		Metadata:  records[0],
+		Offset:    offset,
+		Length:    length,
+		ExpiresAt: expiresAt,
+	}, options, nil
+}
+
+func (d DB) deleteUpload(id types.ID) error {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	DELETE FROM
+		uploads
+	WHERE
+		id=?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrUploadNotExists{ID: id}
+	}
+
+	if err = deleteChunks(tx, id); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	d.deleteBlobs(id)
+	return nil
+}
+
+// DeleteExpiredUploads - removes abandoned uploads together with their chunks,
+// uploads that are being written right now are skipped
+func (d DB) DeleteExpiredUploads(now time.Time) (int, error) {
+	rows, err := d.ctx.Query(`
+		SELECT
+			id
+		FROM
+			uploads
+		WHERE
+			expires_at <= ?`, now.UTC().Format(timeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := scanIds(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if !d.uploadLocks.tryLock(id) {
+			continue
+		}
+		err := d.deleteUpload(id)
+		d.uploadLocks.unlock(id)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
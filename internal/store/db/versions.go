@@ -0,0 +1,419 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"log"
+	"time"
+)
+
+// The `records` row describes the latest version of the record content, the earlier versions kept by the retention
+// are in `record_versions`. The chunks, chunk references, data keys and blobs of every version are stored
+// under the ID together with the version, see file.Options and blobKey
+
+// PutRecordContent stores the content of the reader as the next version of the record.
+// The record is staged in `pending_records` while the chunks are written, so only one new version
+// of the record is written at a time and the orphan sweep leaves its chunks alone
+func (d DB) PutRecordContent(id types.ID, reader io.Reader, contentType types.ContentType) (types.Metadata, error) {
+	if err := d.stageVersion(id); err != nil {
+		return types.Metadata{}, err
+	}
+
+	current, _, err := d.getMetadata(id)
+	if err != nil {
+		d.unstage(id)
+		return types.Metadata{}, err
+	}
+
+	version := current.Version + 1
+	if contentType == "" {
+		contentType = current.ContentType
+	}
+
+	log.Printf("Create version %d of record %s", version, id)
+
+	w, err := d.newContentWriter(id, version)
+	if err != nil {
+		log.Printf("failed to create version %d of record %s: %v", version, id, err)
+		d.discardVersion(id, version)
+		return types.Metadata{}, err
+	}
+
+	size, digest, content, err := writeContent(w, reader, contentType)
+	var pruned []types.ID
+	if err == nil {
+		pruned, err = d.commitVersion(id, version, contentType, size, digest, content)
+	}
+
+	if err != nil {
+		log.Printf("failed to store version %d of record %s: %v", version, id, err)
+		d.discardVersion(id, version)
+		return types.Metadata{}, err
+	}
+
+	d.deleteBlobs(pruned...)
+
+	return d.GetMetadata(id)
+}
+
+// stageVersion - marks the record as being written, fails when another version of it is being written
+func (d DB) stageVersion(id types.ID) error {
+	res, err := d.ctx.Exec(`
+	INSERT OR IGNORE INTO
+		pending_records
+	(
+		id,
+		started_at
+	)
+	VALUES(?,?)`, id, time.Now().UTC().Format(timeFormat))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrRecordLocked{ID: id}
+	}
+
+	return nil
+}
+
+// unstage - drops the staging marker, the orphan sweep drops it later if this fails
+func (d DB) unstage(id types.ID) {
+	if _, err := d.ctx.Exec(`
+	DELETE FROM
+		pending_records
+	WHERE
+		id=?`, id); err != nil {
+		log.Printf("failed to unstage record %s: %v", id, err)
+	}
+}
+
+// commitVersion - moves the latest version to `record_versions`, makes the new version the latest one
+// and prunes the versions beyond the retention in one transaction. Returns the blob keys of the pruned versions
+func (d DB) commitVersion(id types.ID, version int, contentType types.ContentType, size int64, digest string, content string) ([]types.ID, error) {
+	settings, err := d.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	INSERT INTO
+		record_versions
+	(
+		id,
+		version,
+		content_type,
+		create_at,
+		size,
+		chunk_size,
+		dedup,
+		sha256,
+		storage
+	)
+	SELECT
+		id,
+		version,
+		content_type,
+		COALESCE(modified_at, create_at),
+		size,
+		chunk_size,
+		dedup,
+		sha256,
+		storage
+	FROM
+		records
+	WHERE
+		id=? AND version=?`, id, version-1)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		// the record was deleted meanwhile
+		return nil, types.ErrFileNotExists{ID: id}
+	}
+
+	if _, err = tx.Exec(`
+		UPDATE records
+		SET
+			version = ?,
+			modified_at = ?,
+			content_type = ?,
+			size = ?,
+			chunk_size = ?,
+			dedup = ?,
+			sha256 = ?,
+			storage = ?
+		WHERE
+			id=?`,
+		version,
+		time.Now().UTC().Format(timeFormat),
+		contentType,
+		size,
+		d.chunkSize,
+		d.dedup,
+		digest,
+		d.storage(),
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	if err = writeSearchDocument(tx, id, content); err != nil {
+		return nil, err
+	}
+	// the triggers only follow the filename and the note
+	if d.search {
+		if _, err = tx.Exec(`
+			UPDATE records_search
+			SET
+				content = ?
+			WHERE
+				rowid = (SELECT doc FROM search_documents WHERE id=?)`, content, id); err != nil {
+			return nil, err
+		}
+	}
+
+	var pruned []types.ID
+	if settings.MaxVersions > 0 {
+		if pruned, err = d.pruneVersions(tx, id, version-settings.MaxVersions); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = unstageTx(tx, id); err != nil {
+		return nil, err
+	}
+
+	return pruned, tx.Commit()
+}
+
+// pruneVersions - removes the earlier versions of the record up to and including the given one,
+// returns the blob keys of the removed versions
+func (d DB) pruneVersions(tx *sql.Tx, id types.ID, upTo int) ([]types.ID, error) {
+	if upTo < 1 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT
+			version,
+			storage
+		FROM
+			record_versions
+		WHERE
+			id=? AND version<=?`, id, upTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []types.ID
+	pruned := 0
+	for rows.Next() {
+		var version int
+		var storage string
+		if err := rows.Scan(&version, &storage); err != nil {
+			return nil, err
+		}
+		pruned++
+		if storage != "" {
+			keys = append(keys, blobKey(id, version))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"metadata", "record_chunks", "data_keys", "record_versions"} {
+		if _, err := tx.Exec(`
+		DELETE FROM
+			`+table+`
+		WHERE
+			id=? AND version<=?`, id, upTo); err != nil {
+			return nil, err
+		}
+	}
+
+	if pruned > 0 {
+		log.Printf("pruned %d versions of record %s", pruned, id)
+	}
+	return keys, nil
+}
+
+// discardVersion - removes the chunks of the version which failed to be stored and unstages the record.
+// If this fails as well the orphan sweep picks them up later
+func (d DB) discardVersion(id types.ID, version int) {
+	tx, err := d.ctx.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Printf("failed to discard version %d of record %s: %v", version, id, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"metadata", "record_chunks", "data_keys"} {
+		if _, err = tx.Exec(`
+		DELETE FROM
+			`+table+`
+		WHERE
+			id=? AND version=?`, id, version); err != nil {
+			log.Printf("failed to discard version %d of record %s: %v", version, id, err)
+			return
+		}
+	}
+
+	if _, err = tx.Exec(`
+	DELETE FROM
+		pending_records
+	WHERE
+		id=?`, id); err != nil {
+		log.Printf("failed to discard version %d of record %s: %v", version, id, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("failed to discard version %d of record %s: %v", version, id, err)
+		return
+	}
+
+	d.deleteBlobs(blobKey(id, version))
+}
+
+func (d DB) GetRecordVersion(id types.ID, version int) (types.UploadRecord, error) {
+	metadata, options, err := d.getMetadata(id)
+	if err != nil {
+		return types.UploadRecord{}, err
+	}
+
+	if version != 0 && version != metadata.Version {
+		if metadata, options, err = d.getVersion(metadata, version); err != nil {
+			return types.UploadRecord{}, err
+		}
+	}
+
+	reader, err := d.openContent(id, options, metadata.Size)
+	if err != nil {
+		return types.UploadRecord{}, err
+	}
+
+	return types.UploadRecord{
+		Metadata: metadata,
+		Reader:   reader,
+	}, nil
+}
+
+// getVersion - the metadata of the record with the content of the earlier version and the chunk options it was written with
+func (d DB) getVersion(metadata types.Metadata, version int) (types.Metadata, chunkOptions, error) {
+	var contentType sql.NullString
+	var createAtTime string
+	var digest sql.NullString
+	var options chunkOptions
+
+	err := d.ctx.QueryRow(`
+		SELECT
+			content_type,
+			create_at,
+			size,
+			chunk_size,
+			dedup,
+			sha256,
+			storage
+		FROM
+			record_versions
+		WHERE
+			id=? AND version=? AND quarantined_at IS NULL`, metadata.ID, version).Scan(&contentType, &createAtTime, &metadata.Size, &options.chunkSize, &options.file.Dedup, &digest, &options.storage)
+	if err == sql.ErrNoRows {
+		return types.Metadata{}, chunkOptions{}, types.ErrVersionNotExists{ID: metadata.ID, Version: version}
+	}
+	if err != nil {
+		return types.Metadata{}, chunkOptions{}, err
+	}
+
+	metadata.ModifiedAt = time.Time{}
+	if version > 1 {
+		if metadata.ModifiedAt, err = time.Parse(timeFormat, createAtTime); err != nil {
+			return types.Metadata{}, chunkOptions{}, err
+		}
+	}
+
+	metadata.Version = version
+	metadata.ContentType = types.ContentType(contentType.String)
+	metadata.SHA256 = digest.String
+	options.file.Version = version
+
+	return metadata, options, nil
+}
+
+func (d DB) ListRecordVersions(id types.ID) ([]types.RecordVersion, error) {
+	metadata, _, err := d.getMetadata(id)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := types.RecordVersion{
+		Version:     metadata.Version,
+		ContentType: metadata.ContentType,
+		CreateAt:    metadata.ModifiedAt,
+		Size:        metadata.Size,
+		SHA256:      metadata.SHA256,
+	}
+	if latest.CreateAt.IsZero() {
+		latest.CreateAt = metadata.CreateAt
+	}
+
+	rows, err := d.ctx.Query(`
+		SELECT
+			version,
+			content_type,
+			create_at,
+			size,
+			sha256
+		FROM
+			record_versions
+		WHERE
+			id=? AND quarantined_at IS NULL
+		ORDER BY
+			version DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []types.RecordVersion{latest}
+	for rows.Next() {
+		var version types.RecordVersion
+		var contentType sql.NullString
+		var createAtTime string
+		var digest sql.NullString
+
+		if err := rows.Scan(&version.Version, &contentType, &createAtTime, &version.Size, &digest); err != nil {
+			return nil, err
+		}
+
+		version.ContentType = types.ContentType(contentType.String)
+		version.SHA256 = digest.String
+		if version.CreateAt, err = time.Parse(timeFormat, createAtTime); err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
@@ -5,3 +5,9 @@ import "database/sql"
 type SqlDB interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
+
+// SqlQueryDB is satisfied by both *sql.DB and *sql.Tx
+type SqlQueryDB interface {
+	SqlDB
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
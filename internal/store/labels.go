@@ -0,0 +1,72 @@
+package store
+
+import (
+	"github.com/denisschmidt/uploader/internal/types"
+	"sort"
+)
+
+// NormalizeLabels - the attributes and tags the way every store returns them:
+// nil when there are none and the tags sorted without duplicates
+func NormalizeLabels(metadata types.Metadata) types.Metadata {
+	metadata.Attributes = copyAttributes(metadata.Attributes)
+	metadata.Tags = normalizeTags(metadata.Tags)
+	return metadata
+}
+
+// UpdateLabels - the attributes and tags of the update replace the current ones unless they are nil
+func UpdateLabels(current types.Metadata, update types.Metadata) types.Metadata {
+	if update.Attributes != nil {
+		current.Attributes = copyAttributes(update.Attributes)
+	}
+	if update.Tags != nil {
+		current.Tags = normalizeTags(update.Tags)
+	}
+	return current
+}
+
+// HasLabels - the metadata has all the attributes and tags of the listing filter
+func HasLabels(metadata types.Metadata, options types.ListOptions) bool {
+	for key, value := range options.Attributes {
+		if v, ok := metadata.Attributes[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	for _, tag := range options.Tags {
+		i := sort.SearchStrings(metadata.Tags, tag)
+		if i == len(metadata.Tags) || metadata.Tags[i] != tag {
+			return false
+		}
+	}
+
+	return true
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	copied := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		copied[key] = value
+	}
+	return copied
+}
+
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+
+	unique := sorted[:1]
+	for _, tag := range sorted[1:] {
+		if tag != unique[len(unique)-1] {
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sortKey - the value the records are ordered by, the same as the cursor keeps it.
+// The times are compared formatted like the SQLite store compares them
+type sortKey struct {
+	text string
+	size int64
+}
+
+func (k sortKey) compare(other sortKey, sortBy types.SortField) int {
+	if sortBy == types.SortBySize {
+		switch {
+		case k.size < other.size:
+			return -1
+		case k.size > other.size:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(k.text, other.text)
+}
+
+func recordSortKey(metadata types.Metadata, sortBy types.SortField) sortKey {
+	switch sortBy {
+	case types.SortByFilename:
+		return sortKey{text: string(metadata.Filename)}
+	case types.SortBySize:
+		return sortKey{size: metadata.Size}
+	default:
+		return sortKey{text: metadata.CreateAt.UTC().Format(time.RFC3339)}
+	}
+}
+
+func cursorSortKey(cursor store.Cursor, options types.ListOptions) (sortKey, error) {
+	if options.SortBy != types.SortBySize {
+		return sortKey{text: cursor.Value}, nil
+	}
+
+	size, err := strconv.ParseInt(cursor.Value, 10, 64)
+	if err != nil {
+		return sortKey{}, types.ErrInvalidCursor{Cursor: options.Cursor}
+	}
+	return sortKey{size: size}, nil
+}
+
+// ListRecords returns a page of not expired records matching the options with the same keyset pagination as the SQLite store
+func (s *Store) ListRecords(options types.ListOptions) (types.RecordsPage, error) {
+	options = store.NormalizeListOptions(options)
+
+	switch options.SortBy {
+	case types.SortByCreateAt, types.SortByFilename, types.SortBySize:
+	default:
+		return types.RecordsPage{}, fmt.Errorf("unsupported sort field %q", options.SortBy)
+	}
+
+	var after *store.Cursor
+	var afterKey sortKey
+	if options.Cursor != "" {
+		cursor, err := store.DecodeCursor(options)
+		if err != nil {
+			return types.RecordsPage{}, err
+		}
+		if afterKey, err = cursorSortKey(cursor, options); err != nil {
+			return types.RecordsPage{}, err
+		}
+		after = &cursor
+	}
+
+	// compare orders a before b in the requested direction, the ID breaks the ties
+	compare := func(a sortKey, aId types.ID, b sortKey, bId types.ID) int {
+		c := a.compare(b, options.SortBy)
+		if c == 0 {
+			c = strings.Compare(string(aId), string(bId))
+		}
+		if options.Descending {
+			c = -c
+		}
+		return c
+	}
+
+	s.mu.Lock()
+	records := []types.Metadata{}
+	for id := range s.records {
+		r, ok := s.listed(id, options.Trashed)
+		if !ok || !matches(r.metadata, options) {
+			continue
+		}
+		if after != nil && compare(recordSortKey(r.metadata, options.SortBy), id, afterKey, after.ID) <= 0 {
+			continue
+		}
+		records = append(records, r.metadata)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return compare(recordSortKey(records[i], options.SortBy), records[i].ID, recordSortKey(records[j], options.SortBy), records[j].ID) < 0
+	})
+
+	page := types.RecordsPage{
+		Records: records,
+	}
+
+	if len(records) > options.Limit {
+		page.Records = records[:options.Limit]
+		last := page.Records[options.Limit-1]
+
+		cursor := store.Cursor{
+			SortBy:     options.SortBy,
+			Descending: options.Descending,
+			ID:         last.ID,
+		}
+		key := recordSortKey(last, options.SortBy)
+		cursor.Value = key.text
+		if options.SortBy == types.SortBySize {
+			cursor.Value = strconv.FormatInt(key.size, 10)
+		}
+		page.NextCursor = store.EncodeCursor(cursor)
+	}
+
+	return page, nil
+}
+
+// matches - the filters of the options, zero values are not applied
+func matches(metadata types.Metadata, options types.ListOptions) bool {
+	switch {
+	case options.ContentType != "" && metadata.ContentType != options.ContentType:
+		return false
+	case options.FilenamePrefix != "" && !strings.HasPrefix(string(metadata.Filename), options.FilenamePrefix):
+		return false
+	case !options.CreatedAfter.IsZero() && metadata.CreateAt.Before(options.CreatedAfter.Truncate(time.Second)):
+		return false
+	case !options.CreatedBefore.IsZero() && !metadata.CreateAt.Before(options.CreatedBefore.Truncate(time.Second)):
+		return false
+	case options.MinSize > 0 && metadata.Size < options.MinSize:
+		return false
+	case options.MaxSize > 0 && metadata.Size > options.MaxSize:
+		return false
+	case options.BatchID != "" && metadata.BatchID != options.BatchID:
+		return false
+	case !store.HasLabels(metadata, options):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+// Package memory is a store.Store keeping everything in maps, it doesn't need SQLite or cgo.
+// The content is lost when the process exits, so it is meant for tests and trying things out
+package memory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"sync"
+	"time"
+)
+
+// the same initial settings as the SQLite store
+const (
+	defaultExpirationInDays = 30
+	defaultMaxVersions      = 10
+)
+
+type (
+	record struct {
+		metadata types.Metadata
+		// data is never modified once the record is stored, so the readers share it
+		data []byte
+		// versions are the earlier versions kept by the retention, the oldest first
+		versions []version
+	}
+
+	version struct {
+		version types.RecordVersion
+		data    []byte
+	}
+
+	upload struct {
+		upload types.Upload
+		data   []byte
+		// writing is set while a request appends to the upload
+		writing bool
+	}
+
+	Store struct {
+		mu       sync.Mutex
+		records  map[types.ID]record
+		uploads  map[types.ID]*upload
+		pending  map[types.ID]struct{}
+		settings types.Settings
+	}
+)
+
+var _ store.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		records: make(map[types.ID]record),
+		uploads: make(map[types.ID]*upload),
+		pending: make(map[types.ID]struct{}),
+		settings: types.Settings{
+			DefaultExpirationInDays: defaultExpirationInDays,
+			MaxVersions:             defaultMaxVersions,
+		},
+	}
+}
+
+// InsertRecord reads the content outside of the lock, the ID is reserved meanwhile
+// and the record becomes visible only when all of it is read
+func (s *Store) InsertRecord(reader io.Reader, metadata types.Metadata) error {
+	if err := s.reserve(metadata.ID); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(reader)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, metadata.ID)
+
+	if err != nil {
+		return err
+	}
+
+	metadata = store.NormalizeLabels(metadata)
+	metadata.Size = int64(len(data))
+	metadata.SHA256 = digest(data)
+	metadata.CreateAt = truncate(metadata.CreateAt)
+	metadata.ExpiresAt = truncate(s.recordExpiration(metadata.ExpiresAt))
+	metadata.Version = 1
+	metadata.ModifiedAt = time.Time{}
+
+	s.records[metadata.ID] = record{
+		metadata: metadata,
+		data:     data,
+	}
+	return nil
+}
+
+// reserve - marks the ID as being written, it fails if the ID is taken by a record or an upload
+func (s *Store) reserve(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.taken(id) {
+		return types.ErrFileExists{ID: id}
+	}
+	s.pending[id] = struct{}{}
+	return nil
+}
+
+func (s *Store) taken(id types.ID) bool {
+	_, isRecord := s.records[id]
+	_, isUpload := s.uploads[id]
+	_, isPending := s.pending[id]
+	return isRecord || isUpload || isPending
+}
+
+func (s *Store) GetRecord(id types.ID) (types.UploadRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.record(id)
+	if !ok {
+		return types.UploadRecord{}, types.ErrFileNotExists{ID: id}
+	}
+
+	return types.UploadRecord{
+		Metadata: r.metadata,
+		Reader:   bytes.NewReader(r.data),
+	}, nil
+}
+
+func (s *Store) GetMetadata(id types.ID) (types.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.record(id)
+	if !ok {
+		return types.Metadata{}, types.ErrFileNotExists{ID: id}
+	}
+	return r.metadata, nil
+}
+
+// record - the record if it exists, hasn't expired and isn't in the trash, the caller holds the lock
+func (s *Store) record(id types.ID) (record, bool) {
+	return s.listed(id, false)
+}
+
+// listed - the record if it exists, hasn't expired and is either in the trash or in service, the caller holds the lock
+func (s *Store) listed(id types.ID, trashed bool) (record, bool) {
+	r, ok := s.records[id]
+	if !ok || expired(r.metadata.ExpiresAt, time.Now()) || r.metadata.DeletedAt.IsZero() == trashed {
+		return record{}, false
+	}
+	return r, true
+}
+
+func (s *Store) UpdateRecordMetadata(id types.ID, metadata types.Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.record(id)
+	if !ok {
+		return types.ErrFileNotExists{ID: id}
+	}
+
+	r.metadata = store.UpdateLabels(r.metadata, metadata)
+	r.metadata.Filename = metadata.Filename
+	r.metadata.Note = metadata.Note
+	if !metadata.ExpiresAt.IsZero() {
+		r.metadata.ExpiresAt = truncate(metadata.ExpiresAt)
+	}
+	s.records[id] = r
+	return nil
+}
+
+// Stats counts every byte once, nothing is shared or compressed
+func (s *Store) Stats() (types.StoreStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := types.StoreStats{
+		DedupRatio: 1,
+	}
+	for _, r := range s.records {
+		stats.Records++
+		stats.LogicalBytes += r.metadata.Size
+		stats.StoredBytes += r.metadata.Size
+		for _, v := range r.versions {
+			stats.StoredBytes += v.version.Size
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *Store) GetSettings() (types.Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings, nil
+}
+
+func (s *Store) UpdateSettings(settings types.Settings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+	return nil
+}
+
+// recordExpiration - the requested expiration or the default one from the settings, the caller holds the lock
+func (s *Store) recordExpiration(requested time.Time) time.Time {
+	if !requested.IsZero() || s.settings.DefaultExpirationInDays == 0 {
+		return requested
+	}
+	return time.Now().AddDate(0, 0, s.settings.DefaultExpirationInDays)
+}
+
+// truncate - the times are returned with the precision the SQLite store keeps them
+func truncate(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+// expired - zero time never expires
+func expired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !expiresAt.After(now)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"sort"
+	"strings"
+)
+
+// snippetContext - the number of characters kept around the first match in the snippet
+const snippetContext = 40
+
+// searchField - the weights are the ones the SQLite store ranks with
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// Search matches every term of the query case-insensitively anywhere in the filename, the note
+// or the text content. It is simpler than the FTS5 index of the SQLite store, only the weights of the fields are the same
+func (s *Store) Search(query string, limit int) ([]types.SearchResult, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	results := []types.SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	s.mu.Lock()
+	for id := range s.records {
+		r, ok := s.record(id)
+		if !ok {
+			continue
+		}
+
+		fields := []searchField{
+			{string(r.metadata.Filename), 10},
+			{string(r.metadata.Note), 5},
+		}
+		if store.IsTextContentType(r.metadata.ContentType) {
+			fields = append(fields, searchField{store.ExtractText(r.data), 1})
+		}
+
+		var score float64
+		var snippet string
+		matched := true
+		for _, term := range terms {
+			termScore := 0.0
+			for _, field := range fields {
+				if n := strings.Count(strings.ToLower(field.text), term); n > 0 {
+					termScore += field.weight * float64(n)
+					if snippet == "" {
+						snippet = highlight(field.text, terms)
+					}
+				}
+			}
+			if termScore == 0 {
+				matched = false
+				break
+			}
+			score += termScore
+		}
+		if !matched {
+			continue
+		}
+
+		results = append(results, types.SearchResult{
+			Metadata: r.metadata,
+			Snippet:  snippet,
+			Score:    score,
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if limit = store.NormalizeSearchLimit(limit); len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// highlight - the text around the first match with all the terms marked
+func highlight(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// the lower case changed the length, the positions don't line up
+		lower = runes
+	}
+
+	first := -1
+	marks := make([]int, len(runes))
+	for _, term := range terms {
+		termRunes := []rune(term)
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if string(lower[i:i+len(termRunes)]) == term {
+				marks[i] = max(marks[i], len(termRunes))
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := max(first-snippetContext, 0), min(first+snippetContext, len(runes))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marks[i] > 0 {
+			markEnd := min(i+marks[i], len(runes))
+			b.WriteString(store.HighlightStart + string(runes[i:markEnd]) + store.HighlightEnd)
+			end = max(end, markEnd)
+			i = markEnd - 1
+			continue
+		}
+		b.WriteRune(runes[i])
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"github.com/denisschmidt/uploader/internal/types"
+	"time"
+)
+
+// DeleteRecord moves the record to the trash, a record already in the trash keeps its deletion time
+func (s *Store) DeleteRecord(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.record(id); ok {
+		r.metadata.DeletedAt = truncate(time.Now())
+		s.records[id] = r
+	}
+	return nil
+}
+
+func (s *Store) RestoreRecord(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.listed(id, true)
+	if !ok {
+		return types.ErrFileNotExists{ID: id}
+	}
+
+	r.metadata.DeletedAt = time.Time{}
+	s.records[id] = r
+	return nil
+}
+
+func (s *Store) PurgeRecord(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok || r.metadata.DeletedAt.IsZero() {
+		return types.ErrFileNotExists{ID: id}
+	}
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *Store) PurgeTrash(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, r := range s.records {
+		if !r.metadata.DeletedAt.IsZero() && !r.metadata.DeletedAt.After(before) {
+			delete(s.records, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"bytes"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"time"
+)
+
+func (s *Store) CreateUpload(u types.Upload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.taken(u.ID) {
+		return types.ErrFileExists{ID: u.ID}
+	}
+
+	u.Metadata = store.NormalizeLabels(u.Metadata)
+	u.Offset = 0
+	u.CreateAt = truncate(u.CreateAt)
+	u.ExpiresAt = truncate(u.ExpiresAt)
+	s.uploads[u.ID] = &upload{upload: u}
+	return nil
+}
+
+func (s *Store) GetUpload(id types.ID) (types.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.activeUpload(id); ok {
+		return u.upload, nil
+	}
+
+	// the upload could already be promoted to a record,
+	// report it as complete so the client doesn't start over
+	r, ok := s.record(id)
+	if !ok {
+		return types.Upload{}, types.ErrUploadNotExists{ID: id}
+	}
+
+	return types.Upload{
+		Metadata: r.metadata,
+		Offset:   r.metadata.Size,
+		Length:   r.metadata.Size,
+	}, nil
+}
+
+// activeUpload - the upload if it exists and hasn't expired, the caller holds the lock
+func (s *Store) activeUpload(id types.ID) (*upload, bool) {
+	u, ok := s.uploads[id]
+	if !ok || expired(u.upload.ExpiresAt, time.Now()) {
+		return nil, false
+	}
+	return u, true
+}
+
+// WriteUpload appends the data from the reader to the upload, offset must match the number of bytes already received.
+// Everything read before a failure of the reader is kept, so the client can resume from the returned offset
+func (s *Store) WriteUpload(id types.ID, offset int64, reader io.Reader) (types.Upload, error) {
+	u, err := s.lockUpload(id, offset)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	// the reader is consumed outside of the lock, the upload is reserved by the writing flag
+	var buf bytes.Buffer
+	_, copyErr := io.Copy(&buf, io.LimitReader(reader, u.upload.Length-u.upload.Offset))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.writing = false
+
+	u.data = append(u.data, buf.Bytes()...)
+	u.upload.Offset = int64(len(u.data))
+	result := u.upload
+
+	if copyErr != nil {
+		return result, copyErr
+	}
+
+	if u.upload.Offset == u.upload.Length {
+		s.completeUpload(u)
+	}
+
+	return result, nil
+}
+
+// lockUpload - marks the upload as being written after checking the offset
+func (s *Store) lockUpload(id types.ID, offset int64) (*upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if ok && u.writing {
+		return nil, types.ErrUploadLocked{ID: id}
+	}
+	if !ok || expired(u.upload.ExpiresAt, time.Now()) {
+		return nil, types.ErrUploadNotExists{ID: id}
+	}
+
+	if u.upload.Offset != offset {
+		return nil, types.ErrUploadOffsetMismatch{
+			ID:       id,
+			Expected: u.upload.Offset,
+			Got:      offset,
+		}
+	}
+
+	u.writing = true
+	return u, nil
+}
+
+// completeUpload - moves the upload into the records, the caller holds the lock
+func (s *Store) completeUpload(u *upload) {
+	metadata := u.upload.Metadata
+	metadata.Size = int64(len(u.data))
+	metadata.SHA256 = digest(u.data)
+	metadata.ExpiresAt = truncate(s.recordExpiration(time.Time{}))
+	metadata.Version = 1
+
+	s.records[metadata.ID] = record{
+		metadata: metadata,
+		data:     u.data,
+	}
+	delete(s.uploads, metadata.ID)
+}
+
+func (s *Store) DeleteUpload(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return types.ErrUploadNotExists{ID: id}
+	}
+	if u.writing {
+		return types.ErrUploadLocked{ID: id}
+	}
+
+	delete(s.uploads, id)
+	return nil
+}
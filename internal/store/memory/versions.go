@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"bytes"
+	"github.com/denisschmidt/uploader/internal/types"
+	"io"
+	"time"
+)
+
+// PutRecordContent reads the content outside of the lock, the record is reserved meanwhile
+// so only one new version of it is written at a time
+func (s *Store) PutRecordContent(id types.ID, reader io.Reader, contentType types.ContentType) (types.Metadata, error) {
+	if err := s.reserveVersion(id); err != nil {
+		return types.Metadata{}, err
+	}
+
+	data, err := io.ReadAll(reader)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	r, ok := s.record(id)
+	if !ok {
+		return types.Metadata{}, types.ErrFileNotExists{ID: id}
+	}
+
+	r.versions = append(r.versions, version{
+		version: recordVersion(r.metadata),
+		data:    r.data,
+	})
+	if s.settings.MaxVersions > 0 && len(r.versions) >= s.settings.MaxVersions {
+		// a new slice, so the readers of the pruned versions aren't affected
+		r.versions = append([]version(nil), r.versions[len(r.versions)-s.settings.MaxVersions+1:]...)
+	}
+
+	if contentType != "" {
+		r.metadata.ContentType = contentType
+	}
+	r.metadata.Version++
+	r.metadata.ModifiedAt = truncate(time.Now())
+	r.metadata.Size = int64(len(data))
+	r.metadata.SHA256 = digest(data)
+	r.data = data
+
+	s.records[id] = r
+	return r.metadata, nil
+}
+
+// reserveVersion - marks the record as being written, it fails if another version of it is being written
+func (s *Store) reserveVersion(id types.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pending[id]; ok {
+		return types.ErrRecordLocked{ID: id}
+	}
+	if _, ok := s.record(id); !ok {
+		return types.ErrFileNotExists{ID: id}
+	}
+	s.pending[id] = struct{}{}
+	return nil
+}
+
+// recordVersion - the version of the record content described by the metadata
+func recordVersion(metadata types.Metadata) types.RecordVersion {
+	createAt := metadata.ModifiedAt
+	if createAt.IsZero() {
+		createAt = metadata.CreateAt
+	}
+
+	return types.RecordVersion{
+		Version:     metadata.Version,
+		ContentType: metadata.ContentType,
+		CreateAt:    createAt,
+		Size:        metadata.Size,
+		SHA256:      metadata.SHA256,
+	}
+}
+
+func (s *Store) GetRecordVersion(id types.ID, number int) (types.UploadRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.record(id)
+	if !ok {
+		return types.UploadRecord{}, types.ErrFileNotExists{ID: id}
+	}
+
+	if number == 0 || number == r.metadata.Version {
+		return types.UploadRecord{
+			Metadata: r.metadata,
+			Reader:   bytes.NewReader(r.data),
+		}, nil
+	}
+
+	for _, v := range r.versions {
+		if v.version.Version != number {
+			continue
+		}
+
+		metadata := r.metadata
+		metadata.Version = v.version.Version
+		metadata.ContentType = v.version.ContentType
+		metadata.Size = v.version.Size
+		metadata.SHA256 = v.version.SHA256
+		metadata.ModifiedAt = time.Time{}
+		if number > 1 {
+			metadata.ModifiedAt = v.version.CreateAt
+		}
+
+		return types.UploadRecord{
+			Metadata: metadata,
+			Reader:   bytes.NewReader(v.data),
+		}, nil
+	}
+
+	return types.UploadRecord{}, types.ErrVersionNotExists{ID: id, Version: number}
+}
+
+func (s *Store) ListRecordVersions(id types.ID) ([]types.RecordVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.record(id)
+	if !ok {
+		return nil, types.ErrFileNotExists{ID: id}
+	}
+
+	versions := []types.RecordVersion{recordVersion(r.metadata)}
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		versions = append(versions, r.versions[i].version)
+	}
+	return versions, nil
+}
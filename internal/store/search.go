@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"github.com/denisschmidt/uploader/internal/types"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxIndexedText - only the beginning of the larger text records is searchable
+	MaxIndexedText = 1 << 20
+	// DefaultSearchLimit is the number of results when the limit isn't set
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is the largest number of results Search returns
+	MaxSearchLimit = 100
+
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+var textContentTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/toml":       true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/csv":        true,
+}
+
+// IsTextContentType - the content types whose content is indexed for the full-text search
+func IsTextContentType(contentType types.ContentType) bool {
+	mediaType, _, err := mime.ParseMediaType(string(contentType))
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		textContentTypes[mediaType]
+}
+
+// ExtractText - the searchable text of the content, empty when it doesn't look like UTF-8 text.
+// A multibyte character cut at MaxIndexedText is dropped
+func ExtractText(data []byte) string {
+	if len(data) > MaxIndexedText {
+		data = data[:MaxIndexedText]
+	}
+
+	for i := 0; i < utf8.UTFMax && len(data) > 0; i++ {
+		if utf8.Valid(data) {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return ""
+	}
+	return string(data)
+}
+
+// NormalizeSearchLimit applies the default and the largest number of results
+func NormalizeSearchLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		return MaxSearchLimit
+	}
+	return limit
+}
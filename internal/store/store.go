@@ -3,14 +3,46 @@ package store
 import (
 	"github.com/denisschmidt/uploader/internal/types"
 	"io"
+	"time"
 )
 
 type Store interface {
 	InsertRecord(reader io.Reader, metadata types.Metadata) error
 	GetRecord(id types.ID) (types.UploadRecord, error)
 	GetMetadata(id types.ID) (types.Metadata, error)
+	ListRecords(options types.ListOptions) (types.RecordsPage, error)
 
+	// UpdateRecordMetadata replaces the filename and the note, the attributes and tags are replaced
+	// only when they aren't nil and the expiration only when it isn't zero
 	UpdateRecordMetadata(id types.ID, metadata types.Metadata) error
 
+	// DeleteRecord moves the record to the trash, it isn't served until it is restored.
+	// Deleting a missing record is not an error
 	DeleteRecord(id types.ID) error
+	// RestoreRecord moves the record back from the trash
+	RestoreRecord(id types.ID) error
+	// PurgeRecord permanently deletes the record in the trash
+	PurgeRecord(id types.ID) error
+	// PurgeTrash permanently deletes the records moved to the trash before the time, returns their number
+	PurgeTrash(before time.Time) (int, error)
+
+	// PutRecordContent stores the content of the reader as the next version of the record, an empty content type
+	// keeps the one of the previous version. The oldest versions beyond Settings.MaxVersions are pruned
+	PutRecordContent(id types.ID, reader io.Reader, contentType types.ContentType) (types.Metadata, error)
+	// GetRecordVersion reads the given version of the record, version 0 is the latest one
+	GetRecordVersion(id types.ID, version int) (types.UploadRecord, error)
+	// ListRecordVersions returns the versions kept of the record, the latest first
+	ListRecordVersions(id types.ID) ([]types.RecordVersion, error)
+
+	Stats() (types.StoreStats, error)
+
+	GetSettings() (types.Settings, error)
+	UpdateSettings(settings types.Settings) error
+
+	// CreateUpload, GetUpload, WriteUpload and DeleteUpload manage resumable uploads,
+	// WriteUpload promotes the upload to a record once all of its bytes are received
+	CreateUpload(upload types.Upload) error
+	GetUpload(id types.ID) (types.Upload, error)
+	WriteUpload(id types.ID, offset int64, reader io.Reader) (types.Upload, error)
+	DeleteUpload(id types.ID) error
 }
@@ -0,0 +1,744 @@
+// Package storetest is the conformance suite every store.Store implementation must pass
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/denisschmidt/uploader/internal/store"
+	"github.com/denisschmidt/uploader/internal/types"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// Factory creates a new empty store for every test
+type Factory func(t *testing.T) store.Store
+
+// largeFileSize - spans many chunks and blob parts of the stores under test, without making the suite slow
+const largeFileSize = 1<<20 + 7
+
+// Run runs the whole suite against the stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, newStore(t)) })
+	t.Run("SmallReads", func(t *testing.T) { testSmallReads(t, newStore(t)) })
+	t.Run("Seek", func(t *testing.T) { testSeek(t, newStore(t)) })
+	t.Run("SeekEdgeCases", func(t *testing.T) { testSeekEdgeCases(t, newStore(t)) })
+	t.Run("Metadata", func(t *testing.T) { testMetadata(t, newStore(t)) })
+	t.Run("Labels", func(t *testing.T) { testLabels(t, newStore(t)) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, newStore(t)) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, newStore(t)) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, newStore(t)) })
+	t.Run("Upload", func(t *testing.T) { testUpload(t, newStore(t)) })
+	t.Run("ConcurrentReaders", func(t *testing.T) { testConcurrentReaders(t, newStore(t)) })
+	t.Run("ConcurrentWriters", func(t *testing.T) { testConcurrentWriters(t, newStore(t)) })
+	t.Run("LargeFile", func(t *testing.T) { testLargeFile(t, newStore(t)) })
+}
+
+// content - deterministic content which differs at every position, so misplaced bytes are noticed
+func content(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte('a' + i%23)
+	}
+	return data
+}
+
+func insert(t *testing.T, s store.Store, id types.ID, data []byte) {
+	t.Helper()
+	err := s.InsertRecord(bytes.NewReader(data), types.Metadata{
+		ID:          id,
+		Filename:    types.Filename(id + ".bin"),
+		Note:        "conformance",
+		ContentType: "application/octet-stream",
+		CreateAt:    time.Now().UTC().Truncate(time.Second),
+	})
+	require.NoError(t, err)
+}
+
+// read - reads the whole record and closes the reader
+func read(t *testing.T, s store.Store, id types.ID) []byte {
+	t.Helper()
+	record, err := s.GetRecord(id)
+	require.NoError(t, err)
+	defer closeReader(record)
+
+	data, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	return data
+}
+
+func closeReader(record types.UploadRecord) {
+	if closer, ok := record.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func testRoundTrip(t *testing.T, s store.Store) {
+	for _, size := range []int{0, 1, 4, 5, 6, 17, 63, 64, 65, 100, 1000, 4097} {
+		id := types.ID(fmt.Sprintf("size%d", size))
+		data := content(size)
+
+		insert(t, s, id, data)
+		require.Equal(t, data, read(t, s, id), "size %d", size)
+
+		metadata, err := s.GetMetadata(id)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), metadata.Size)
+	}
+}
+
+func testSmallReads(t *testing.T, s store.Store) {
+	data := content(43)
+	insert(t, s, "small", data)
+
+	record, err := s.GetRecord("small")
+	require.NoError(t, err)
+	defer closeReader(record)
+
+	// every read returns at most one byte, so no read is aligned with anything
+	rest, err := io.ReadAll(iotest.OneByteReader(record.Reader))
+	require.NoError(t, err)
+	require.Equal(t, data, rest)
+
+	_, err = record.Reader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, iotest.TestReader(record.Reader, data))
+}
+
+func testSeek(t *testing.T, s store.Store) {
+	data := content(37)
+	size := int64(len(data))
+	insert(t, s, "seek", data)
+
+	for _, row := range []struct {
+		description string
+		// read is the number of bytes read before seeking
+		read   int64
+		offset int64
+		whence int
+		want   int64
+	}{
+		{description: "start", offset: 0, whence: io.SeekStart, want: 0},
+		{description: "start middle", offset: 10, whence: io.SeekStart, want: 10},
+		{description: "start chunk boundary", offset: 5, whence: io.SeekStart, want: 5},
+		{description: "start last byte", offset: size - 1, whence: io.SeekStart, want: size - 1},
+		{description: "start end", offset: size, whence: io.SeekStart, want: size},
+		{description: "start past end", offset: size + 10, whence: io.SeekStart, want: size + 10},
+		{description: "start after read", read: 3, offset: 20, whence: io.SeekStart, want: 20},
+		{description: "current position", offset: 0, whence: io.SeekCurrent, want: 0},
+		{description: "current position after read", read: 7, offset: 0, whence: io.SeekCurrent, want: 7},
+		{description: "current forward", read: 3, offset: 9, whence: io.SeekCurrent, want: 12},
+		{description: "current backward", read: 20, offset: -8, whence: io.SeekCurrent, want: 12},
+		{description: "current past end", read: 30, offset: 30, whence: io.SeekCurrent, want: 60},
+		{description: "end", offset: 0, whence: io.SeekEnd, want: size},
+		{description: "end last byte", offset: -1, whence: io.SeekEnd, want: size - 1},
+		{description: "end middle", read: 3, offset: -20, whence: io.SeekEnd, want: size - 20},
+		{description: "end start", offset: -size, whence: io.SeekEnd, want: 0},
+		{description: "end past end", offset: 5, whence: io.SeekEnd, want: size + 5},
+	} {
+		t.Run(row.description, func(t *testing.T) {
+			record, err := s.GetRecord("seek")
+			require.NoError(t, err)
+			defer closeReader(record)
+
+			_, err = io.ReadFull(record.Reader, make([]byte, row.read))
+			require.NoError(t, err)
+
+			pos, err := record.Reader.Seek(row.offset, row.whence)
+			require.NoError(t, err)
+			require.Equal(t, row.want, pos)
+
+			rest, err := io.ReadAll(record.Reader)
+			require.NoError(t, err)
+			if row.want >= size {
+				require.Empty(t, rest)
+			} else {
+				require.Equal(t, data[row.want:], rest)
+			}
+		})
+	}
+}
+
+func testSeekEdgeCases(t *testing.T, s store.Store) {
+	data := content(12)
+	insert(t, s, "edge", data)
+	insert(t, s, "empty", nil)
+
+	record, err := s.GetRecord("edge")
+	require.NoError(t, err)
+	defer closeReader(record)
+
+	_, err = record.Reader.Seek(-1, io.SeekStart)
+	require.Error(t, err, "seeking before the start")
+	_, err = record.Reader.Seek(-13, io.SeekEnd)
+	require.Error(t, err, "seeking before the start")
+	_, err = record.Reader.Seek(0, 42)
+	require.Error(t, err, "invalid whence")
+
+	// the failed seeks don't move the reader
+	pos, err := record.Reader.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), pos)
+
+	// reading to the end and seeking back
+	rest, err := io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data, rest)
+
+	n, err := record.Reader.Read(make([]byte, 4))
+	require.Equal(t, 0, n)
+	require.Equal(t, io.EOF, err)
+
+	pos, err = record.Reader.Seek(-4, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), pos)
+
+	rest, err = io.ReadAll(record.Reader)
+	require.NoError(t, err)
+	require.Equal(t, data[8:], rest)
+
+	// the same record is read independently by every reader
+	second, err := s.GetRecord("edge")
+	require.NoError(t, err)
+	defer closeReader(second)
+	require.Equal(t, data, readAll(t, second.Reader))
+
+	empty, err := s.GetRecord("empty")
+	require.NoError(t, err)
+	defer closeReader(empty)
+
+	pos, err = empty.Reader.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), pos)
+	require.Empty(t, readAll(t, empty.Reader))
+}
+
+func readAll(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func testMetadata(t *testing.T, s store.Store) {
+	insert(t, s, "metadata", content(10))
+
+	metadata, err := s.GetMetadata("metadata")
+	require.NoError(t, err)
+	require.Equal(t, types.ID("metadata"), metadata.ID)
+	require.Equal(t, types.Filename("metadata.bin"), metadata.Filename)
+	require.Equal(t, types.Note("conformance"), metadata.Note)
+	require.Equal(t, types.ContentType("application/octet-stream"), metadata.ContentType)
+
+	err = s.UpdateRecordMetadata("metadata", types.Metadata{Filename: "renamed.bin", Note: "updated"})
+	require.NoError(t, err)
+
+	metadata, err = s.GetMetadata("metadata")
+	require.NoError(t, err)
+	require.Equal(t, types.Filename("renamed.bin"), metadata.Filename)
+	require.Equal(t, types.Note("updated"), metadata.Note)
+	require.Equal(t, content(10), read(t, s, "metadata"))
+
+	// the expiration is kept unless a new one is given
+	expiresAt := metadata.ExpiresAt
+	err = s.UpdateRecordMetadata("metadata", types.Metadata{Filename: "renamed.bin"})
+	require.NoError(t, err)
+	metadata, err = s.GetMetadata("metadata")
+	require.NoError(t, err)
+	require.Equal(t, expiresAt, metadata.ExpiresAt)
+
+	expiresAt = time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err = s.UpdateRecordMetadata("metadata", types.Metadata{Filename: "renamed.bin", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	metadata, err = s.GetMetadata("metadata")
+	require.NoError(t, err)
+	require.Equal(t, expiresAt, metadata.ExpiresAt)
+
+	err = s.InsertRecord(bytes.NewReader(content(3)), types.Metadata{ID: "metadata", Filename: "duplicate.bin"})
+	require.Equal(t, types.ErrFileExists{ID: "metadata"}, err)
+	require.Equal(t, content(10), read(t, s, "metadata"))
+}
+
+func testLabels(t *testing.T, s store.Store) {
+	for _, metadata := range []types.Metadata{
+		{ID: "nightly", Attributes: map[string]string{"project": "uploader", "build": "42"}, Tags: []string{"nightly", "linux", "nightly"}},
+		{ID: "release", Attributes: map[string]string{"project": "uploader", "build": "43"}, Tags: []string{"release", "linux"}},
+		{ID: "plain"},
+	} {
+		metadata.Filename = types.Filename(metadata.ID + ".bin")
+		metadata.CreateAt = time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, s.InsertRecord(bytes.NewReader(content(5)), metadata))
+	}
+
+	metadata, err := s.GetMetadata("nightly")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"project": "uploader", "build": "42"}, metadata.Attributes)
+	require.Equal(t, []string{"linux", "nightly"}, metadata.Tags)
+
+	metadata, err = s.GetMetadata("plain")
+	require.NoError(t, err)
+	require.Nil(t, metadata.Attributes)
+	require.Nil(t, metadata.Tags)
+
+	for _, row := range []struct {
+		name       string
+		attributes map[string]string
+		tags       []string
+		want       []types.ID
+	}{
+		{name: "attribute", attributes: map[string]string{"project": "uploader"}, want: []types.ID{"nightly", "release"}},
+		{name: "attributes", attributes: map[string]string{"project": "uploader", "build": "43"}, want: []types.ID{"release"}},
+		{name: "attribute value", attributes: map[string]string{"build": "44"}, want: []types.ID{}},
+		{name: "tag", tags: []string{"linux"}, want: []types.ID{"nightly", "release"}},
+		{name: "tags", tags: []string{"linux", "nightly"}, want: []types.ID{"nightly"}},
+		{name: "both", attributes: map[string]string{"build": "42"}, tags: []string{"release"}, want: []types.ID{}},
+	} {
+		t.Run(row.name, func(t *testing.T) {
+			page, err := s.ListRecords(types.ListOptions{Attributes: row.attributes, Tags: row.tags, SortBy: types.SortByFilename})
+			require.NoError(t, err)
+
+			ids := []types.ID{}
+			for _, record := range page.Records {
+				ids = append(ids, record.ID)
+			}
+			require.Equal(t, row.want, ids)
+		})
+	}
+
+	// the listing returns the labels too
+	page, err := s.ListRecords(types.ListOptions{Tags: []string{"release"}})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 1)
+	require.Equal(t, map[string]string{"project": "uploader", "build": "43"}, page.Records[0].Attributes)
+	require.Equal(t, []string{"linux", "release"}, page.Records[0].Tags)
+
+	// nil labels are kept, the empty ones remove them
+	require.NoError(t, s.UpdateRecordMetadata("nightly", types.Metadata{Filename: "renamed.bin"}))
+	metadata, err = s.GetMetadata("nightly")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"project": "uploader", "build": "42"}, metadata.Attributes)
+	require.Equal(t, []string{"linux", "nightly"}, metadata.Tags)
+
+	require.NoError(t, s.UpdateRecordMetadata("nightly", types.Metadata{Filename: "renamed.bin", Attributes: map[string]string{"owner": "ci"}, Tags: []string{}}))
+	metadata, err = s.GetMetadata("nightly")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"owner": "ci"}, metadata.Attributes)
+	require.Nil(t, metadata.Tags)
+
+	// the labels of a deleted record don't come back with the ID
+	require.NoError(t, s.DeleteRecord("release"))
+	require.NoError(t, s.PurgeRecord("release"))
+	require.NoError(t, s.InsertRecord(bytes.NewReader(content(5)), types.Metadata{ID: "release", Filename: "release.bin", CreateAt: time.Now().UTC()}))
+	metadata, err = s.GetMetadata("release")
+	require.NoError(t, err)
+	require.Nil(t, metadata.Attributes)
+	require.Nil(t, metadata.Tags)
+
+	// the labels of an upload are the labels of the record it becomes
+	err = s.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "uploaded", Filename: "uploaded.bin", CreateAt: time.Now().UTC(), Attributes: map[string]string{"owner": "tus"}, Tags: []string{"resumable"}},
+		Length:    5,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = s.WriteUpload("uploaded", 0, bytes.NewReader(content(5)))
+	require.NoError(t, err)
+
+	metadata, err = s.GetMetadata("uploaded")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"owner": "tus"}, metadata.Attributes)
+	require.Equal(t, []string{"resumable"}, metadata.Tags)
+}
+
+func testBatch(t *testing.T, s store.Store) {
+	for _, metadata := range []types.Metadata{
+		{ID: "first", BatchID: "batch", Path: "photos/2024"},
+		{ID: "second", BatchID: "batch"},
+		{ID: "other", BatchID: "another", Path: "docs"},
+		{ID: "single"},
+	} {
+		metadata.Filename = types.Filename(metadata.ID + ".bin")
+		metadata.CreateAt = time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, s.InsertRecord(bytes.NewReader(content(5)), metadata))
+	}
+
+	metadata, err := s.GetMetadata("first")
+	require.NoError(t, err)
+	require.Equal(t, types.ID("batch"), metadata.BatchID)
+	require.Equal(t, "photos/2024", metadata.Path)
+
+	page, err := s.ListRecords(types.ListOptions{BatchID: "batch", SortBy: types.SortByFilename})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 2)
+	require.Equal(t, types.ID("first"), page.Records[0].ID)
+	require.Equal(t, "photos/2024", page.Records[0].Path)
+	require.Equal(t, types.ID("second"), page.Records[1].ID)
+
+	// the batch and the path stay when the metadata is updated
+	require.NoError(t, s.UpdateRecordMetadata("first", types.Metadata{Filename: "renamed.bin"}))
+	metadata, err = s.GetMetadata("first")
+	require.NoError(t, err)
+	require.Equal(t, types.ID("batch"), metadata.BatchID)
+	require.Equal(t, "photos/2024", metadata.Path)
+
+	metadata, err = s.GetMetadata("single")
+	require.NoError(t, err)
+	require.Empty(t, metadata.BatchID)
+	require.Empty(t, metadata.Path)
+}
+
+func testVersions(t *testing.T, s store.Store) {
+	insert(t, s, "doc", content(5))
+
+	metadata, err := s.GetMetadata("doc")
+	require.NoError(t, err)
+	require.Equal(t, 1, metadata.Version)
+	require.True(t, metadata.ModifiedAt.IsZero())
+
+	// spans several chunks, unlike the first version
+	second := content(3000)
+	metadata, err = s.PutRecordContent("doc", bytes.NewReader(second), "text/plain")
+	require.NoError(t, err)
+	require.Equal(t, 2, metadata.Version)
+	require.Equal(t, int64(len(second)), metadata.Size)
+	require.Equal(t, types.ContentType("text/plain"), metadata.ContentType)
+	require.Equal(t, types.Filename("doc.bin"), metadata.Filename)
+	require.False(t, metadata.ModifiedAt.IsZero())
+	require.Equal(t, second, read(t, s, "doc"))
+
+	// the content type is kept when it isn't given
+	third := content(11)
+	metadata, err = s.PutRecordContent("doc", bytes.NewReader(third), "")
+	require.NoError(t, err)
+	require.Equal(t, 3, metadata.Version)
+	require.Equal(t, types.ContentType("text/plain"), metadata.ContentType)
+
+	for _, row := range []struct {
+		version     int
+		want        []byte
+		contentType types.ContentType
+	}{
+		{version: 0, want: third, contentType: "text/plain"},
+		{version: 1, want: content(5), contentType: "application/octet-stream"},
+		{version: 2, want: second, contentType: "text/plain"},
+		{version: 3, want: third, contentType: "text/plain"},
+	} {
+		record, err := s.GetRecordVersion("doc", row.version)
+		require.NoError(t, err)
+		data, err := io.ReadAll(record.Reader)
+		closeReader(record)
+		require.NoError(t, err)
+		require.Equal(t, row.want, data, row.version)
+		require.Equal(t, row.contentType, record.ContentType)
+		require.Equal(t, int64(len(row.want)), record.Size)
+		require.Equal(t, types.Filename("doc.bin"), record.Filename)
+	}
+
+	versions, err := s.ListRecordVersions("doc")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		require.Equal(t, 3-i, version.Version)
+	}
+	require.Equal(t, int64(len(second)), versions[1].Size)
+	require.Equal(t, metadata.SHA256, versions[0].SHA256)
+
+	_, err = s.GetRecordVersion("doc", 4)
+	require.Equal(t, types.ErrVersionNotExists{ID: "doc", Version: 4}, err)
+
+	_, err = s.PutRecordContent("missing", bytes.NewReader(third), "")
+	require.Equal(t, types.ErrFileNotExists{ID: "missing"}, err)
+
+	// the retention prunes the oldest versions with the next one
+	settings, err := s.GetSettings()
+	require.NoError(t, err)
+	settings.MaxVersions = 2
+	require.NoError(t, s.UpdateSettings(settings))
+
+	_, err = s.PutRecordContent("doc", bytes.NewReader(content(7)), "")
+	require.NoError(t, err)
+
+	versions, err = s.ListRecordVersions("doc")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 4, versions[0].Version)
+	require.Equal(t, 3, versions[1].Version)
+
+	_, err = s.GetRecordVersion("doc", 2)
+	require.Equal(t, types.ErrVersionNotExists{ID: "doc", Version: 2}, err)
+
+	record, err := s.GetRecordVersion("doc", 3)
+	require.NoError(t, err)
+	data, err := io.ReadAll(record.Reader)
+	closeReader(record)
+	require.NoError(t, err)
+	require.Equal(t, third, data)
+
+	require.NoError(t, s.DeleteRecord("doc"))
+	_, err = s.GetRecordVersion("doc", 3)
+	require.Equal(t, types.ErrFileNotExists{ID: "doc"}, err)
+}
+
+func testNotFound(t *testing.T, s store.Store) {
+	notFound := types.ErrFileNotExists{ID: "missing"}
+
+	_, err := s.GetRecord("missing")
+	require.Equal(t, notFound, err)
+
+	_, err = s.GetMetadata("missing")
+	require.Equal(t, notFound, err)
+
+	require.Equal(t, notFound, s.UpdateRecordMetadata("missing", types.Metadata{Filename: "renamed.bin"}))
+
+	// deleting is idempotent
+	require.NoError(t, s.DeleteRecord("missing"))
+
+	_, err = s.GetUpload("missing")
+	require.Equal(t, types.ErrUploadNotExists{ID: "missing"}, err)
+
+	_, err = s.WriteUpload("missing", 0, bytes.NewReader(content(3)))
+	require.Equal(t, types.ErrUploadNotExists{ID: "missing"}, err)
+
+	require.Equal(t, types.ErrUploadNotExists{ID: "missing"}, s.DeleteUpload("missing"))
+}
+
+func testDelete(t *testing.T, s store.Store) {
+	insert(t, s, "deleted", content(12))
+	insert(t, s, "kept", content(12))
+
+	require.NoError(t, s.DeleteRecord("deleted"))
+
+	_, err := s.GetRecord("deleted")
+	require.Equal(t, types.ErrFileNotExists{ID: "deleted"}, err)
+
+	require.Equal(t, content(12), read(t, s, "kept"))
+
+	// the ID can be used again once the record is purged from the trash
+	require.Equal(t, types.ErrFileExists{ID: "deleted"}, s.InsertRecord(bytes.NewReader(content(3)), types.Metadata{ID: "deleted"}))
+	require.NoError(t, s.PurgeRecord("deleted"))
+	insert(t, s, "deleted", content(3))
+	require.Equal(t, content(3), read(t, s, "deleted"))
+}
+
+func testTrash(t *testing.T, s store.Store) {
+	for _, id := range []types.ID{"restored", "purged", "old", "kept"} {
+		insert(t, s, id, content(70))
+	}
+
+	for _, id := range []types.ID{"restored", "purged", "old"} {
+		require.NoError(t, s.DeleteRecord(id))
+	}
+
+	// the records in the trash are listed apart from the ones in service
+	list := func(trashed bool) []types.ID {
+		page, err := s.ListRecords(types.ListOptions{Trashed: trashed, SortBy: types.SortByFilename})
+		require.NoError(t, err)
+
+		ids := []types.ID{}
+		for _, record := range page.Records {
+			require.Equal(t, trashed, !record.DeletedAt.IsZero())
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []types.ID{"kept"}, list(false))
+	require.Equal(t, []types.ID{"old", "purged", "restored"}, list(true))
+
+	for _, update := range []error{
+		s.UpdateRecordMetadata("restored", types.Metadata{Filename: "renamed.bin"}),
+		s.RestoreRecord("kept"),
+		s.PurgeRecord("kept"),
+		s.RestoreRecord("missing"),
+	} {
+		require.IsType(t, types.ErrFileNotExists{}, update)
+	}
+
+	require.NoError(t, s.RestoreRecord("restored"))
+	require.Equal(t, content(70), read(t, s, "restored"))
+
+	require.NoError(t, s.PurgeRecord("purged"))
+	require.Equal(t, types.ErrFileNotExists{ID: "purged"}, s.RestoreRecord("purged"))
+
+	// the records moved to the trash after the time are kept
+	purged, err := s.PurgeTrash(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+
+	purged, err = s.PurgeTrash(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+
+	require.Equal(t, []types.ID{}, list(true))
+	require.Equal(t, []types.ID{"kept", "restored"}, list(false))
+}
+
+func testUpload(t *testing.T, s store.Store) {
+	data := content(23)
+
+	err := s.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "upload", Filename: "upload.bin", CreateAt: time.Now().UTC()},
+		Length:    int64(len(data)),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	upload, err := s.WriteUpload("upload", 0, bytes.NewReader(data[:7]))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), upload.Offset)
+
+	_, err = s.WriteUpload("upload", 3, bytes.NewReader(data[3:]))
+	require.Equal(t, types.ErrUploadOffsetMismatch{ID: "upload", Expected: 7, Got: 3}, err)
+
+	_, err = s.GetRecord("upload")
+	require.Equal(t, types.ErrFileNotExists{ID: "upload"}, err)
+
+	upload, err = s.WriteUpload("upload", 7, bytes.NewReader(data[7:]))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), upload.Offset)
+
+	require.Equal(t, data, read(t, s, "upload"))
+
+	// the completed upload is still reported as complete
+	upload, err = s.GetUpload("upload")
+	require.NoError(t, err)
+	require.Equal(t, upload.Length, upload.Offset)
+
+	err = s.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "terminated", Filename: "terminated.bin", CreateAt: time.Now().UTC()},
+		Length:    10,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = s.WriteUpload("terminated", 0, bytes.NewReader(content(4)))
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteUpload("terminated"))
+	_, err = s.GetUpload("terminated")
+	require.Equal(t, types.ErrUploadNotExists{ID: "terminated"}, err)
+	require.Equal(t, types.ErrUploadNotExists{ID: "terminated"}, s.DeleteUpload("terminated"))
+
+	// the empty upload is completed by writing nothing at all
+	err = s.CreateUpload(types.Upload{
+		Metadata:  types.Metadata{ID: "empty", Filename: "empty.bin", CreateAt: time.Now().UTC()},
+		Length:    0,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	upload, err = s.WriteUpload("empty", 0, bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), upload.Offset)
+	require.Empty(t, read(t, s, "empty"))
+}
+
+func testConcurrentReaders(t *testing.T, s store.Store) {
+	data := content(1000)
+	insert(t, s, "shared", data)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			errs <- readFrom(s, "shared", offset, data)
+		}(int64(i * 97))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, s store.Store) {
+	existing := content(300)
+	insert(t, s, "existing", existing)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(id types.ID, data []byte) {
+			defer wg.Done()
+			err := s.InsertRecord(bytes.NewReader(data), types.Metadata{ID: id, Filename: types.Filename(id + ".bin")})
+			if err == nil {
+				err = readFrom(s, id, 0, data)
+			}
+			errs <- err
+		}(types.ID(fmt.Sprintf("writer%d", i)), content(100+i*31))
+
+		// the records being written don't disturb the readers of the existing ones
+		go func() {
+			defer wg.Done()
+			errs <- readFrom(s, "existing", 0, existing)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 8; i++ {
+		id := types.ID(fmt.Sprintf("writer%d", i))
+		require.Equal(t, content(100+i*31), read(t, s, id))
+	}
+}
+
+// readFrom - checks the content of the record from offset, for the goroutines which can't use require
+func readFrom(s store.Store, id types.ID, offset int64, want []byte) error {
+	record, err := s.GetRecord(id)
+	if err != nil {
+		return err
+	}
+	defer closeReader(record)
+
+	if _, err := record.Reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(record.Reader)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want[offset:], data) {
+		return fmt.Errorf("content of %v from %d differs", id, offset)
+	}
+	return nil
+}
+
+func testLargeFile(t *testing.T, s store.Store) {
+	data := content(largeFileSize)
+	insert(t, s, "large", data)
+
+	metadata, err := s.GetMetadata("large")
+	require.NoError(t, err)
+	require.Equal(t, int64(largeFileSize), metadata.Size)
+
+	record, err := s.GetRecord("large")
+	require.NoError(t, err)
+	defer closeReader(record)
+
+	// copied in the chunks of the HTTP responses
+	var out bytes.Buffer
+	_, err = io.CopyBuffer(&out, record.Reader, make([]byte, 32<<10))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, out.Bytes()), "content of the large file differs")
+
+	for _, offset := range []int64{largeFileSize / 2, largeFileSize - 3, 12345} {
+		_, err = record.Reader.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(record.Reader, buf)
+		require.NoError(t, err)
+		require.Equal(t, data[offset:offset+3], buf)
+	}
+}
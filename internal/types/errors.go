@@ -12,3 +12,114 @@ type ErrFileNotExists struct {
 func (e ErrFileNotExists) Error() string {
 	return fmt.Sprintf("No record found ID %v", e.ID)
 }
+
+// ErrFileExists is an error when a record with the same ID is already stored
+type ErrFileExists struct {
+	ID ID
+}
+
+func (e ErrFileExists) Error() string {
+	return fmt.Sprintf("Record already exists ID %v", e.ID)
+}
+
+// ErrUploadNotExists is an error when resumable upload does not exist or has expired
+type ErrUploadNotExists struct {
+	ID ID
+}
+
+func (e ErrUploadNotExists) Error() string {
+	return fmt.Sprintf("No upload found ID %v", e.ID)
+}
+
+// ErrUploadOffsetMismatch is an error when the client tries to resume an upload from the wrong offset
+type ErrUploadOffsetMismatch struct {
+	ID       ID
+	Expected int64
+	Got      int64
+}
+
+func (e ErrUploadOffsetMismatch) Error() string {
+	return fmt.Sprintf("Upload %v offset mismatch: got %d, want %d", e.ID, e.Got, e.Expected)
+}
+
+// ErrUploadLocked is an error when another request is already writing to the same upload
+type ErrUploadLocked struct {
+	ID ID
+}
+
+func (e ErrUploadLocked) Error() string {
+	return fmt.Sprintf("Upload %v is locked by another request", e.ID)
+}
+
+// ErrVersionNotExists is an error when the record has no such version, or it was pruned
+type ErrVersionNotExists struct {
+	ID      ID
+	Version int
+}
+
+func (e ErrVersionNotExists) Error() string {
+	return fmt.Sprintf("No version %d found of record ID %v", e.Version, e.ID)
+}
+
+// ErrRecordLocked is an error when another request is already storing a new version of the same record
+type ErrRecordLocked struct {
+	ID ID
+}
+
+func (e ErrRecordLocked) Error() string {
+	return fmt.Sprintf("Record %v is locked by another request", e.ID)
+}
+
+// ErrInvalidCursor is an error when the listing cursor is malformed or was issued for another sort order
+type ErrInvalidCursor struct {
+	Cursor string
+}
+
+func (e ErrInvalidCursor) Error() string {
+	return fmt.Sprintf("Invalid cursor %q", e.Cursor)
+}
+
+// ErrChunkCorrupt is an error when the stored chunk doesn't match the checksum it was written with
+type ErrChunkCorrupt struct {
+	ID    ID
+	Index int
+}
+
+func (e ErrChunkCorrupt) Error() string {
+	return fmt.Sprintf("Chunk %d of %v is corrupt", e.Index, e.ID)
+}
+
+// ErrChunkMissing is an error when a chunk within the size of the record isn't stored
+type ErrChunkMissing struct {
+	ID    ID
+	Index int
+}
+
+func (e ErrChunkMissing) Error() string {
+	return fmt.Sprintf("Chunk %d of %v is missing", e.Index, e.ID)
+}
+
+// ErrSearchUnavailable is an error when the store is opened without the full-text search index
+type ErrSearchUnavailable struct{}
+
+func (e ErrSearchUnavailable) Error() string {
+	return "Full-text search is not available, SQLite is built without FTS5"
+}
+
+// ErrFileTooLarge is an error when the uploaded content exceeds the maximum file size
+type ErrFileTooLarge struct {
+	Limit int64
+}
+
+func (e ErrFileTooLarge) Error() string {
+	return fmt.Sprintf("File exceeds the maximum size of %d bytes", e.Limit)
+}
+
+// ErrCapacityExceeded is an error when the uploaded content doesn't fit into the space left in the store
+type ErrCapacityExceeded struct {
+	Capacity int64
+}
+
+func (e ErrCapacityExceeded) Error() string {
+	return fmt.Sprintf("Store capacity of %d bytes is exceeded", e.Capacity)
+}
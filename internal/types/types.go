@@ -7,35 +7,181 @@ import (
 	"time"
 )
 
+const (
+	SortByCreateAt SortField = "create_at"
+	SortByFilename SortField = "filename"
+	SortBySize     SortField = "size"
+)
+
 type (
 	ID          string
 	Filename    string
 	ContentType string
 	Note        string
+	SortField   string
 
 	Metadata struct {
-		ID          ID
-		Filename    Filename
-		Note        Note
-		ContentType ContentType
-		CreateAt    time.Time
-		Size        int64
+		ID          ID          `json:"id"`
+		Filename    Filename    `json:"filename"`
+		Note        Note        `json:"note"`
+		ContentType ContentType `json:"content_type"`
+		CreateAt    time.Time   `json:"create_at"`
+		ExpiresAt   time.Time   `json:"expires_at,omitzero"`
+		Size        int64       `json:"size"`
+		// SHA256 is the hex encoded digest of the whole content, empty for the records stored before it was recorded
+		SHA256 string `json:"sha256,omitempty"`
+		// Attributes are arbitrary key/value pairs, Tags are sorted without duplicates.
+		// Both are nil when the record has none
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Tags       []string          `json:"tags,omitempty"`
+		// Version of the content, every new content of the record is the next version starting from 1.
+		// ModifiedAt is when the content of the Version was stored, zero for the first version
+		Version    int       `json:"version"`
+		ModifiedAt time.Time `json:"modified_at,omitzero"`
+		// DeletedAt is when the record was moved to the trash, zero for the records in service
+		DeletedAt time.Time `json:"deleted_at,omitzero"`
+		// BatchID is shared by the files uploaded in one request, Path is the directory of the file
+		// relative to the uploaded folder. Both are empty for the records stored otherwise
+		BatchID ID     `json:"batch_id,omitempty"`
+		Path    string `json:"path,omitempty"`
+	}
+
+	// RecordVersion is a single version of the record content, the filename, note and labels are shared by all of them
+	RecordVersion struct {
+		Version     int         `json:"version"`
+		ContentType ContentType `json:"content_type"`
+		CreateAt    time.Time   `json:"create_at"`
+		Size        int64       `json:"size"`
+		SHA256      string      `json:"sha256,omitempty"`
+	}
+
+	// RecordVersions is the history of the record, the latest version first
+	RecordVersions struct {
+		Versions []RecordVersion `json:"versions"`
+	}
+
+	// ListOptions filters and orders the records, zero values are not applied
+	ListOptions struct {
+		Cursor         string
+		Limit          int
+		ContentType    ContentType
+		FilenamePrefix string
+		CreatedAfter   time.Time
+		CreatedBefore  time.Time
+		MinSize        int64
+		MaxSize        int64
+		// Attributes and Tags match the records having all of them
+		Attributes map[string]string
+		Tags       []string
+		SortBy     SortField
+		Descending bool
+		// Trashed lists the records in the trash instead of the ones in service
+		Trashed bool
+		// BatchID matches the records uploaded in the same request
+		BatchID ID
+	}
+
+	// RecordsPage is a single page of ListRecords, NextCursor is empty on the last page
+	RecordsPage struct {
+		Records    []Metadata `json:"records"`
+		NextCursor string     `json:"next_cursor,omitempty"`
+	}
+
+	// SearchResult is a record matching the search query, the best match first.
+	// Snippet is the matching text with the terms between <mark> and </mark>, the text itself isn't HTML escaped
+	SearchResult struct {
+		Metadata
+		Snippet string  `json:"snippet"`
+		Score   float64 `json:"score"`
+	}
+
+	// TrashPurgeResponse is the number of records permanently deleted from the trash
+	TrashPurgeResponse struct {
+		Purged int `json:"purged"`
+	}
+
+	SearchResults struct {
+		Results []SearchResult `json:"results"`
 	}
 
+	// MetadataRequest - the attributes and tags left out of the request are kept as they are,
+	// an empty object or list removes them
 	MetadataRequest struct {
-		Filename string `json:"filename"`
-		Note     string `json:"note"`
+		Filename   string            `json:"filename"`
+		Note       string            `json:"note"`
+		Attributes map[string]string `json:"attributes"`
+		Tags       []string          `json:"tags"`
+	}
+
+	Settings struct {
+		// DefaultExpirationInDays is applied to the new records, 0 disables expiration
+		DefaultExpirationInDays int `json:"default_expiration_in_days"`
+		// MaxVersions is the number of versions kept of every record, the oldest ones are pruned
+		// when a new version is stored. 0 keeps all of them
+		MaxVersions int `json:"max_versions"`
+	}
+
+	// SettingsRequest - the settings left out of the request are kept as they are
+	SettingsRequest struct {
+		DefaultExpirationInDays *int `json:"default_expiration_in_days"`
+		MaxVersions             *int `json:"max_versions"`
+	}
+
+	// StoreStats describes the space used by the records. LogicalBytes is the size of the latest versions, StoredBytes
+	// takes the earlier versions in too and is less than LogicalBytes when chunks are shared or compressed.
+	// DedupRatio is the size of the deduplicated chunks referenced by the records against the size they are stored in,
+	// 1 without deduplication
+	StoreStats struct {
+		Records      int64   `json:"records"`
+		LogicalBytes int64   `json:"logical_bytes"`
+		StoredBytes  int64   `json:"stored_bytes"`
+		DedupRatio   float64 `json:"dedup_ratio"`
+	}
+
+	// Usage is the space taken by the records, the trashed ones and the earlier versions included, against the limits.
+	// The zero limits are unlimited, Remaining is -1 then
+	Usage struct {
+		Records     int64 `json:"records"`
+		UsedBytes   int64 `json:"used_bytes"`
+		Capacity    int64 `json:"capacity"`
+		Remaining   int64 `json:"remaining"`
+		MaxFileSize int64 `json:"max_file_size"`
 	}
 
 	RecordPostResponse struct {
 		ID string `json:"id"`
 	}
 
+	// UploadResult is the outcome of a single file of the upload, either the ID of its record or the error
+	UploadResult struct {
+		Filename Filename `json:"filename"`
+		Path     string   `json:"path,omitempty"`
+		ID       ID       `json:"id,omitempty"`
+		Error    string   `json:"error,omitempty"`
+	}
+
+	// UploadResponse describes the files uploaded in one request. ID is the record of the first stored file,
+	// it keeps the response of the single file uploads as it was
+	UploadResponse struct {
+		ID      ID             `json:"ID,omitempty"`
+		BatchID ID             `json:"batch_id"`
+		Files   []UploadResult `json:"files"`
+	}
+
 	UploadRecord struct {
 		Metadata
+		// Reader is closed by the caller when it implements io.Closer
 		Reader io.ReadSeeker
 	}
 
+	// Upload is a partial (resumable) upload, it becomes a record once Offset reaches Length
+	Upload struct {
+		Metadata
+		Offset    int64
+		Length    int64
+		ExpiresAt time.Time
+	}
+
 	Authorizer interface {
 		Authenticate(r *http.Request) bool
 		StartSession(c *gin.Context)
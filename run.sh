@@ -1,6 +1,6 @@
 #!/usr/bin/env bash
 
-go run cmd/server/main.go --config config.json
+go run -tags sqlite_fts5 cmd/server/main.go --config config.json
 
 #
 #PORT=4001 secretKey=qwerty123
\ No newline at end of file